@@ -41,6 +41,13 @@ const (
 	LUA_OPLE        // <=
 )
 
+// 语言版本, 每个状态可以选择不同的兼容版本
+const (
+	LUA_VERSION_51  = 501
+	LUA_VERSION_53  = 503
+	LUA_VERSION_NUM = LUA_VERSION_53 // 默认版本
+)
+
 const LUA_MINSTACK = 20                         // 预留的栈空间
 const LUAI_MAXSTACK = 100_0000                  // 最大栈数量
 const LUA_REGISTRYINDEX = -LUAI_MAXSTACK - 1000 // 注册表的索引
@@ -48,6 +55,10 @@ const LUA_RIDX_GLOBALS int64 = 2                // 全局变量的索引
 const LUA_MULTRET = -1
 const LUA_RIDX_MAINTHREAD int64 = 1
 
+/* predefined references */
+const LUA_NOREF = -2
+const LUA_REFNIL = -1
+
 const (
 	LUA_OK = iota
 	LUA_YIELD
@@ -57,4 +68,19 @@ const (
 	LUA_ERRGCMM
 	LUA_ERRERR
 	LUA_ERRFILE
-)
\ No newline at end of file
+)
+// lua_gc 的选项
+const (
+	LUA_GCSTOP = iota
+	LUA_GCRESTART
+	LUA_GCCOLLECT
+	LUA_GCCOUNT
+	LUA_GCCOUNTB
+	LUA_GCSTEP
+	LUA_GCSETPAUSE
+	LUA_GCSETSTEPMUL
+	_
+	LUA_GCISRUNNING
+	LUA_GCGEN
+	LUA_GCINC
+)
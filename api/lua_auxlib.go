@@ -5,6 +5,8 @@ type FuncReg map[string]GoFunction
 type AuxLib interface {
 	/* Error-report functions */
 	Error2(fmt string, a ...interface{}) int
+	Where(level int)
+	Traceback(l1 LuaState, msg string, level int) // 把 l1 从第 level 层开始的调用栈推入栈顶
 	ArgError(arg int, extraMsg string) int
 	/* Argument check functions */
 	CheckStack2(sz int, msg string)
@@ -17,6 +19,7 @@ type AuxLib interface {
 	OptInteger(arg int, d int64) int64
 	OptNumber(arg int, d float64) float64
 	OptString(arg int, d string) string
+	CheckOption(arg int, def string, lst []string) int
 	/* Load functions */
 	DoFile(filename string) bool
 	DoString(str string) bool
@@ -30,9 +33,15 @@ type AuxLib interface {
 	GetSubTable(idx int, fname string) bool
 	GetMetafield(obj int, e string) LuaType
 	CallMeta(obj int, e string) bool
+	NewMetatable(tname string) bool
+	TestUdata(arg int, tname string) interface{}
+	CheckUdata(arg int, tname string) interface{}
 	OpenLibs()
 	RequireF(modname string, openf GoFunction, glb bool)
 	NewLib(l FuncReg)
 	NewLibTable(l FuncReg)
 	SetFuncs(l FuncReg, nup int)
+	/* Reference functions */
+	Ref(t int) int
+	Unref(t, ref int)
 }
@@ -0,0 +1,18 @@
+package api
+
+/*
+** 不受保护的错误: 宿主不通过 PCall 直接调用的函数出错时,
+** 以 *LuaError 为值 panic, 或者在 ErrorReturn 模式下由 Err 返回
+ */
+type LuaError struct {
+	Value     interface{} // 错误对象, 通常是字符串
+	Message   string      // 错误对象转换成的消息
+	Traceback string      // 出错时的调用栈, 格式和 luaL_traceback 一样
+}
+
+func (e *LuaError) Error() string {
+	if e.Traceback == "" {
+		return e.Message
+	}
+	return e.Message + "\n" + e.Traceback
+}
@@ -1,9 +1,24 @@
 package api
 
+import "io"
+import "luago/binchunk"
+
 type LuaType = int
 type ArithOp = int
 type CompareOp = int
 type GoFunction func(LuaState) int
+type KContext = int
+type KFunction func(ls LuaState, status int, ctx KContext) int
+
+// 源码的编译缓存, compiler.ProtoCache 实现了它. 返回的原型是只读的,
+// 同一个缓存可以设置给多个状态
+type ProtoCache interface {
+	Compile(chunk, chunkName string) *binchunk.Prototype
+}
+
+// 内存由 Go 管理, 分配函数只能观察和限制状态的内存用量:
+// 状态每次分配 nsize 字节前调用 (osize 总是 0), 返回 false 时抛出内存错误
+type Alloc func(ud interface{}, osize, nsize int) bool
 
 type LuaState interface {
 	BasicAPI
@@ -23,6 +38,8 @@ type BasicAPI interface {
 	Remove(idx int)
 	Rotate(idx, n int)
 	SetTop(idx int)
+	ToClose(idx int)   // 标记为待关闭变量, 函数返回或出错时调用它的 __close
+	CloseSlot(idx int) // 立即关闭并置为 nil
 	// access functions (stack -> Go)
 	TypeName(tp LuaType) string
 	Type(idx int) LuaType
@@ -35,6 +52,7 @@ type BasicAPI interface {
 	IsString(idx int) bool
 	IsFunction(idx int) bool
 	IsGoFunction(idx int) bool
+	IsUserdata(idx int) bool
 	ToBoolean(idx int) bool
 	ToInteger(idx int) int64
 	ToIntegerX(idx int) (int64, bool)
@@ -43,6 +61,7 @@ type BasicAPI interface {
 	ToString(idx int) string
 	ToStringX(idx int) (string, bool)
 	ToGoFunction(idx int) GoFunction
+	ToUserdata(idx int) interface{}
 	ToPointer(idx int) interface{}
 	// push functions (Go -> stack)
 	PushNil()
@@ -53,6 +72,8 @@ type BasicAPI interface {
 	PushFString(fmt string, a ...interface{})
 	PushGoFunction(f GoFunction)
 	PushGoClosure(f GoFunction, n int)
+	NewUserdata(data interface{})
+	PushLightUserdata(p interface{}) // p 必须可以比较, 通常是指针
 
 	Arith(op ArithOp)
 	Compare(idx1, idx2 int, op CompareOp) bool
@@ -72,6 +93,7 @@ type BasicAPI interface {
 
 	Load(chunk []byte, chunkName, mode string) int
 	Call(nArgs, nResults int)
+	CallK(nArgs, nResults int, ctx KContext, k KFunction)
 
 	RegisterCount() int
 	LoadVararg(n int)
@@ -90,16 +112,34 @@ type BasicAPI interface {
 	RawSet(idx int)
 	RawGetI(idx int, i int64) LuaType
 	RawSetI(idx int, i int64)
+	RawGetP(idx int, p interface{}) LuaType // 以轻量用户数据 p 为键
+	RawSetP(idx int, p interface{})
+	GetUserValue(idx int) LuaType
+	SetUserValue(idx int)
 
 	Next(idx int) bool
 	Error() int
 	PCall(nArgs, nResults, msgh int) int
-	StringToNumber(s string) bool
+	PCallK(nArgs, nResults, msgh int, ctx KContext, k KFunction) int
+	StringToNumber(s string) int // 成功时返回 len(s)+1, 否则返回 0
+	GC(what int, args ...int) int
+	Close()
+	Version() int
+	AtPanic(panicf GoFunction) GoFunction // 设置不受保护的错误的处理函数, 返回原来的
+	Dump(w io.Writer, strip bool) int     // 把栈顶的 Lua 函数写成二进制 chunk
+	GetAllocF() (Alloc, interface{})
+	SetAllocF(f Alloc, ud interface{})
+	SetProtoCache(cache ProtoCache)   // 之后 Load 源码时先查缓存, nil 表示不使用缓存
+	GetFenv(idx int)                  // 5.1: 把 idx 处函数的环境推入栈顶
+	SetFenv(idx int) bool             // 5.1: 弹出栈顶的表作为 idx 处函数的环境
+	PushStackFunction(level int) bool // 把第 level 层调用的函数推入栈顶, 0 是当前函数
 
 	NewThread() LuaState
 	Resume(from LuaState, nArgs int) int
 	Yield(nResult int) int
+	YieldK(nResults int, ctx KContext, k KFunction) int
 	Status() int
+	CloseThread(from LuaState) int
 	IsYieldable() bool
 	ToThread(idx int) LuaState
 	PushThread() bool
@@ -23,6 +23,20 @@ const (
 	TAG_LONG_STR  = 0x14
 )
 
+// 5.4 的头部去掉了 int 和 size_t 的大小, 长度改用变长整数保存
+const LUAC_VERSION_54 = 0x54
+
+// 5.4 的常量类型带上了变体位
+const (
+	TAG54_NIL       = 0x00
+	TAG54_FALSE     = 0x01
+	TAG54_TRUE      = 0x11
+	TAG54_INTEGER   = 0x03
+	TAG54_NUMBER    = 0x13
+	TAG54_SHORT_STR = 0x04
+	TAG54_LONG_STR  = 0x14
+)
+
 type binaryChunk struct {
 	header                  // 头部
 	sizeUpvalues byte       // 主函数upvalue数量
@@ -43,7 +57,12 @@ type header struct {
 	luacNum         float64 // luac浮点型 检测机器浮点数格式和chunk是否匹配 370.5
 }
 
+// 函数原型在 Undump 或 compiler.Compile 返回之后就不再修改, 所有字段
+// 包括各个切片都只读, 所以同一个原型可以被多个状态同时使用 (见 compiler.ProtoCache).
+// 只有 reader, reader54 和 compiler/codegen 在构造原型时写字段; vm 和 state
+// (执行, 调试信息, Dump, 快照) 都只读取它们. 需要修改时先复制一份 (见 Clone)
 type Prototype struct {
+	Version         byte          // 字节码版本 决定用哪套指令集执行
 	Source          string        // 源文件名
 	LineDefined     uint32        // 开始行
 	LastLineDefined uint32        // 结束行
@@ -62,6 +81,7 @@ type Prototype struct {
 type Upvalue struct {
 	Instack byte // 1-当前函数的局部变量 0-已经被当前函数捕获
 	Idx     byte
+	Kind    byte // 5.4: 变量种类 0-普通 1-const 2-close
 }
 
 type LocVar struct {
@@ -72,11 +92,30 @@ type LocVar struct {
 
 func Undump(data []byte) *Prototype {
 	reader := &reader{data}
-	reader.checkHeader()        // 校验头部
-	reader.readByte()           // 跳过upvalue数量
+	version := reader.checkHeader() // 校验头部
+	reader.readByte()               // 跳过upvalue数量
+	if version == LUAC_VERSION_54 {
+		return reader.readProto54("")
+	}
 	return reader.readProto("") // 读取函数原型
 }
 
+// 深复制原型和所有子函数原型, 副本可以随意修改
+func (proto *Prototype) Clone() *Prototype {
+	c := *proto
+	c.Code = append([]uint32(nil), proto.Code...)
+	c.Constants = append([]interface{}(nil), proto.Constants...)
+	c.Upvalues = append([]Upvalue(nil), proto.Upvalues...)
+	c.Protos = make([]*Prototype, len(proto.Protos))
+	for i, p := range proto.Protos {
+		c.Protos[i] = p.Clone()
+	}
+	c.LineInfo = append([]uint32(nil), proto.LineInfo...)
+	c.LocVars = append([]LocVar(nil), proto.LocVars...)
+	c.UpvalueNames = append([]string(nil), proto.UpvalueNames...)
+	return &c
+}
+
 func IsBinaryChunk(data []byte) bool {
 	return len(data) > 4 && string(data[:4]) == LUA_SIGNATURE
 }
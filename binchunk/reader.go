@@ -22,6 +22,15 @@ func (r *reader) readUint32() uint32 {
 	return i
 }
 
+// 表的长度: 每一项至少占一个字节, 比剩下的数据还长说明 chunk 已经损坏
+func (r *reader) readCount() int {
+	n := r.readUint32()
+	if uint64(n) > uint64(len(r.data)) {
+		panic("truncated precompiled chunk!")
+	}
+	return int(n)
+}
+
 func (r *reader) readUint64() uint64 {
 	i := binary.LittleEndian.Uint64(r.data)
 	r.data = r.data[8:]
@@ -54,12 +63,24 @@ func (r *reader) readBytes(n uint) []byte {
 	return bytes
 }
 
-func (r *reader) checkHeader() {
+func (r *reader) checkHeader() byte {
 	if string(r.readBytes(4)) != LUA_SIGNATURE {
 		panic("not a precompiled chunk!")
-	} else if r.readByte() != LUAC_VERSION {
+	}
+	switch version := r.readByte(); version {
+	case LUAC_VERSION:
+		r.checkHeader53()
+		return version
+	case LUAC_VERSION_54:
+		r.checkHeader54()
+		return version
+	default:
 		panic("version mismatch!")
-	} else if r.readByte() != LUAC_FORMAT {
+	}
+}
+
+func (r *reader) checkHeader53() {
+	if r.readByte() != LUAC_FORMAT {
 		panic("format mismatch!")
 	} else if string(r.readBytes(6)) != LUAC_DATA {
 		panic("corrupted!")
@@ -87,6 +108,7 @@ func (r *reader) readProto(parentSource string) *Prototype {
 		source = parentSource
 	}
 	return &Prototype{
+		Version:         LUAC_VERSION,
 		Source:          source,
 		LineDefined:     r.readUint32(),
 		LastLineDefined: r.readUint32(),
@@ -104,7 +126,7 @@ func (r *reader) readProto(parentSource string) *Prototype {
 }
 
 func (r *reader) readCode() []uint32 {
-	code := make([]uint32, r.readUint32())
+	code := make([]uint32, r.readCount())
 	for i := range code {
 		code[i] = r.readUint32()
 	}
@@ -112,7 +134,7 @@ func (r *reader) readCode() []uint32 {
 }
 
 func (r *reader) readConstants() []interface{} {
-	constants := make([]interface{}, r.readUint32())
+	constants := make([]interface{}, r.readCount())
 	for i := range constants {
 		constants[i] = r.readConstant()
 	}
@@ -140,7 +162,7 @@ func (r *reader) readConstant() interface{} {
 }
 
 func (r *reader) readUpvalues() []Upvalue {
-	upvalues := make([]Upvalue, r.readUint32())
+	upvalues := make([]Upvalue, r.readCount())
 	for i := range upvalues {
 		upvalues[i] = Upvalue{
 			Instack: r.readByte(),
@@ -151,7 +173,7 @@ func (r *reader) readUpvalues() []Upvalue {
 }
 
 func (r *reader) readProtos(parentSource string) []*Prototype {
-	protos := make([]*Prototype, r.readUint32())
+	protos := make([]*Prototype, r.readCount())
 	for i := range protos {
 		protos[i] = r.readProto(parentSource)
 	}
@@ -159,7 +181,7 @@ func (r *reader) readProtos(parentSource string) []*Prototype {
 }
 
 func (r *reader) readLineInfo() []uint32 {
-	lineInfo := make([]uint32, r.readUint32())
+	lineInfo := make([]uint32, r.readCount())
 	for i := range lineInfo {
 		lineInfo[i] = r.readUint32()
 	}
@@ -167,7 +189,7 @@ func (r *reader) readLineInfo() []uint32 {
 }
 
 func (r *reader) readLocVars() []LocVar {
-	locVars := make([]LocVar, r.readUint32())
+	locVars := make([]LocVar, r.readCount())
 	for i := range locVars {
 		locVars[i] = LocVar{
 			VarName: r.readString(),
@@ -179,7 +201,7 @@ func (r *reader) readLocVars() []LocVar {
 }
 
 func (r *reader) readUpvalueNames() []string {
-	names := make([]string, r.readUint32())
+	names := make([]string, r.readCount())
 	for i := range names {
 		names[i] = r.readString()
 	}
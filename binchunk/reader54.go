@@ -0,0 +1,179 @@
+package binchunk
+
+// 5.4 的头部 版本号已经在 checkHeader 里读过了
+func (r *reader) checkHeader54() {
+	if r.readByte() != LUAC_FORMAT {
+		panic("format mismatch!")
+	} else if string(r.readBytes(6)) != LUAC_DATA {
+		panic("corrupted!")
+	} else if r.readByte() != INSTRUCTION_SIZE {
+		panic("Instruction size mismatch!")
+	} else if r.readByte() != LUA_INTEGER_SIZE {
+		panic("lua_Integer size mismatch!")
+	} else if r.readByte() != LUA_NUMBER_SIZE {
+		panic("lua_Number size mismatch!")
+	} else if r.readLuaInteger() != LUAC_INT {
+		panic("integer format mismatch!")
+	} else if r.readLuaNumber() != LUAC_NUM {
+		panic("float format mismatch!")
+	}
+}
+
+// 变长整数 每个字节 7 位 高位在前 最后一个字节的最高位是 1
+func (r *reader) readVarint() uint64 {
+	var x uint64
+	for {
+		b := r.readByte()
+		if x >= 1<<57 {
+			panic("integer overflow!")
+		}
+		x = x<<7 | uint64(b&0x7F)
+		if b&0x80 != 0 {
+			return x
+		}
+	}
+}
+
+func (r *reader) readInt54() uint32 {
+	x := r.readVarint()
+	if x > 1<<31-1 {
+		panic("integer overflow!")
+	}
+	return uint32(x)
+}
+
+func (r *reader) readCount54() int {
+	n := r.readInt54()
+	if uint64(n) > uint64(len(r.data)) {
+		panic("truncated precompiled chunk!")
+	}
+	return int(n)
+}
+
+// 长度为 0 表示 NULL, 否则实际长度是 size-1
+func (r *reader) readString54() string {
+	size := uint(r.readVarint())
+	if size == 0 {
+		return ""
+	}
+	return string(r.readBytes(size - 1))
+}
+
+func (r *reader) readProto54(parentSource string) *Prototype {
+	source := r.readString54()
+	if source == "" {
+		source = parentSource
+	}
+	proto := &Prototype{
+		Version:         LUAC_VERSION_54,
+		Source:          source,
+		LineDefined:     r.readInt54(),
+		LastLineDefined: r.readInt54(),
+		NumParams:       r.readByte(),
+		IsVararg:        r.readByte(),
+		MaxStackSize:    r.readByte(),
+		Code:            r.readCode54(),
+		Constants:       r.readConstants54(),
+		Upvalues:        r.readUpvalues54(),
+		Protos:          r.readProtos54(source),
+	}
+	proto.LineInfo = r.readLineInfo54(proto.LineDefined)
+	proto.LocVars = r.readLocVars54()
+	proto.UpvalueNames = r.readUpvalueNames54()
+	return proto
+}
+
+func (r *reader) readCode54() []uint32 {
+	code := make([]uint32, r.readCount54())
+	for i := range code {
+		code[i] = r.readUint32()
+	}
+	return code
+}
+
+func (r *reader) readConstants54() []interface{} {
+	constants := make([]interface{}, r.readCount54())
+	for i := range constants {
+		switch r.readByte() {
+		case TAG54_NIL:
+			constants[i] = nil
+		case TAG54_FALSE:
+			constants[i] = false
+		case TAG54_TRUE:
+			constants[i] = true
+		case TAG54_INTEGER:
+			constants[i] = r.readLuaInteger()
+		case TAG54_NUMBER:
+			constants[i] = r.readLuaNumber()
+		case TAG54_SHORT_STR, TAG54_LONG_STR:
+			constants[i] = r.readString54()
+		default:
+			panic("corrupted!")
+		}
+	}
+	return constants
+}
+
+func (r *reader) readUpvalues54() []Upvalue {
+	upvalues := make([]Upvalue, r.readCount54())
+	for i := range upvalues {
+		upvalues[i] = Upvalue{
+			Instack: r.readByte(),
+			Idx:     r.readByte(),
+			Kind:    r.readByte(),
+		}
+	}
+	return upvalues
+}
+
+func (r *reader) readProtos54(parentSource string) []*Prototype {
+	protos := make([]*Prototype, r.readCount54())
+	for i := range protos {
+		protos[i] = r.readProto54(parentSource)
+	}
+	return protos
+}
+
+// 5.4 的行号表只存相对上一条指令的增量, 增量放不下时
+// 对应的 pc 在绝对行号表里有一项, 这里还原成每条指令的行号
+func (r *reader) readLineInfo54(lineDefined uint32) []uint32 {
+	deltas := r.readBytes(uint(r.readInt54()))
+	type absLine struct{ pc, line uint32 }
+	absLines := make([]absLine, r.readCount54())
+	for i := range absLines {
+		absLines[i] = absLine{pc: r.readInt54(), line: r.readInt54()}
+	}
+
+	lineInfo := make([]uint32, len(deltas))
+	line, j := int64(lineDefined), 0
+	for pc, delta := range deltas {
+		if j < len(absLines) && absLines[j].pc == uint32(pc) {
+			line = int64(absLines[j].line)
+			j++
+		} else {
+			line += int64(int8(delta))
+		}
+		lineInfo[pc] = uint32(line)
+	}
+	return lineInfo
+}
+
+func (r *reader) readLocVars54() []LocVar {
+	locVars := make([]LocVar, r.readCount54())
+	for i := range locVars {
+		locVars[i] = LocVar{
+			VarName: r.readString54(),
+			StartPC: r.readInt54(),
+			EndPC:   r.readInt54(),
+		}
+	}
+	return locVars
+}
+
+func (r *reader) readUpvalueNames54() []string {
+	names := make([]string, r.readCount54())
+	for i := range names {
+		names[i] = r.readString54()
+	}
+	return names
+}
@@ -0,0 +1,169 @@
+package binchunk
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+type writer struct {
+	data  []byte
+	strip bool
+}
+
+// 按 5.3 的格式把函数原型写成二进制 chunk, strip 时去掉调试信息
+func Dump(proto *Prototype, strip bool) []byte {
+	if proto.Version == LUAC_VERSION_54 {
+		panic("cannot dump a 5.4 prototype!")
+	}
+	w := &writer{strip: strip}
+	w.writeHeader()
+	w.writeByte(byte(len(proto.Upvalues))) // 主函数upvalue数量
+	w.writeProto(proto, "")
+	return w.data
+}
+
+func (w *writer) writeByte(b byte) {
+	w.data = append(w.data, b)
+}
+
+func (w *writer) writeUint32(i uint32) {
+	w.data = binary.LittleEndian.AppendUint32(w.data, i)
+}
+
+func (w *writer) writeUint64(i uint64) {
+	w.data = binary.LittleEndian.AppendUint64(w.data, i)
+}
+
+func (w *writer) writeLuaInteger(i int64) {
+	w.writeUint64(uint64(i))
+}
+
+func (w *writer) writeLuaNumber(f float64) {
+	w.writeUint64(math.Float64bits(f))
+}
+
+func (w *writer) writeInt(n int) {
+	w.writeUint32(uint32(n))
+}
+
+// null 为 true 时写入 NULL, 读回来是空字符串
+func (w *writer) writeString(s string, null bool) {
+	if null {
+		w.writeByte(0)
+		return
+	}
+	size := len(s) + 1
+	if size < 0xFF {
+		w.writeByte(byte(size))
+	} else { // 长字符串
+		w.writeByte(0xFF)
+		w.writeUint64(uint64(size))
+	}
+	w.data = append(w.data, s...)
+}
+
+func (w *writer) writeHeader() {
+	w.data = append(w.data, LUA_SIGNATURE...)
+	w.writeByte(LUAC_VERSION)
+	w.writeByte(LUAC_FORMAT)
+	w.data = append(w.data, LUAC_DATA...)
+	w.writeByte(CINT_SIZE)
+	w.writeByte(CSIZET_SIZE)
+	w.writeByte(INSTRUCTION_SIZE)
+	w.writeByte(LUA_INTEGER_SIZE)
+	w.writeByte(LUA_NUMBER_SIZE)
+	w.writeLuaInteger(LUAC_INT)
+	w.writeLuaNumber(LUAC_NUM)
+}
+
+func (w *writer) writeProto(proto *Prototype, parentSource string) {
+	// 和父函数相同的源文件名不重复保存
+	w.writeString(proto.Source, w.strip || proto.Source == parentSource)
+	w.writeUint32(proto.LineDefined)
+	w.writeUint32(proto.LastLineDefined)
+	w.writeByte(proto.NumParams)
+	w.writeByte(proto.IsVararg)
+	w.writeByte(proto.MaxStackSize)
+	w.writeCode(proto.Code)
+	w.writeConstants(proto.Constants)
+	w.writeUpvalues(proto.Upvalues)
+	w.writeProtos(proto.Protos, proto.Source)
+	w.writeDebug(proto)
+}
+
+func (w *writer) writeCode(code []uint32) {
+	w.writeInt(len(code))
+	for _, inst := range code {
+		w.writeUint32(inst)
+	}
+}
+
+func (w *writer) writeConstants(constants []interface{}) {
+	w.writeInt(len(constants))
+	for _, k := range constants {
+		switch x := k.(type) {
+		case nil:
+			w.writeByte(TAG_NIL)
+		case bool:
+			w.writeByte(TAG_BOOLEAN)
+			if x {
+				w.writeByte(1)
+			} else {
+				w.writeByte(0)
+			}
+		case int64:
+			w.writeByte(TAG_INTEGER)
+			w.writeLuaInteger(x)
+		case float64:
+			w.writeByte(TAG_NUMBER)
+			w.writeLuaNumber(x)
+		case string:
+			if len(x) <= 40 { // LUAI_MAXSHORTLEN
+				w.writeByte(TAG_SHORT_STR)
+			} else {
+				w.writeByte(TAG_LONG_STR)
+			}
+			w.writeString(x, false)
+		default:
+			panic("corrupted!")
+		}
+	}
+}
+
+func (w *writer) writeUpvalues(upvalues []Upvalue) {
+	w.writeInt(len(upvalues))
+	for _, upval := range upvalues {
+		w.writeByte(upval.Instack)
+		w.writeByte(upval.Idx)
+	}
+}
+
+func (w *writer) writeProtos(protos []*Prototype, source string) {
+	w.writeInt(len(protos))
+	for _, p := range protos {
+		w.writeProto(p, source)
+	}
+}
+
+func (w *writer) writeDebug(proto *Prototype) {
+	if w.strip {
+		w.writeInt(0) // 行号表
+		w.writeInt(0) // 局部变量表
+		w.writeInt(0) // 提升值名
+		return
+	}
+	w.writeInt(len(proto.LineInfo))
+	for _, line := range proto.LineInfo {
+		w.writeUint32(line)
+	}
+	w.writeInt(len(proto.LocVars))
+	for _, locVar := range proto.LocVars {
+		w.writeString(locVar.VarName, false)
+		w.writeUint32(locVar.StartPC)
+		w.writeUint32(locVar.EndPC)
+	}
+	w.writeInt(len(proto.UpvalueNames))
+	for _, name := range proto.UpvalueNames {
+		w.writeString(name, false)
+	}
+}
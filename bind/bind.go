@@ -0,0 +1,154 @@
+// bind 在栈操作之上提供按 Go 类型调用 Lua 和转换值的接口.
+//
+// Go 到 Lua: nil, bool, 整数和浮点数, string, []byte (字符串), time.Time
+// (和 os.time 一样的秒数, 有小数部分时是浮点数), 切片和数组 (序列),
+// map, 结构体 (按 `lua:"name,omitempty"` 标签, "-" 跳过, 嵌入的结构体展开),
+// 指针 (指向的值), api.GoFunction.
+//
+// Lua 到 Go: 按目标类型转换; 目标是 interface{} 时整数是 int64, 浮点数是 float64,
+// 序列是 []interface{}, 键都是字符串的表是 map[string]interface{}, 其他表是
+// map[interface{}]interface{}, Go 用户数据是它的 data.
+package bind
+
+import (
+	"context"
+	"fmt"
+	"luago/api"
+)
+
+// 转换失败时返回, Path 指出是哪个值, 比如 "args[2].Items[3].Name"
+type ConversionError struct {
+	Path string
+	Msg  string
+}
+
+func (e *ConversionError) Error() string {
+	return "bind: " + e.Path + ": " + e.Msg
+}
+
+func convError(path, format string, a ...interface{}) error {
+	return &ConversionError{Path: path, Msg: fmt.Sprintf(format, a...)}
+}
+
+// 可以在别的 goroutine 里打断正在执行的 Lua 代码的状态, state 包的状态实现了它
+type interrupter interface {
+	Interrupt(err error)
+}
+
+type State struct {
+	L api.LuaState
+}
+
+func New(ls api.LuaState) *State {
+	return &State{L: ls}
+}
+
+// 调用全局函数 name, 返回它的所有返回值
+func (s *State) CallGlobal(ctx context.Context, name string, args ...interface{}) ([]interface{}, error) {
+	s.L.GetGlobal(name)
+	return s.call(ctx, name, args)
+}
+
+// 调用栈顶的函数, 和 CallGlobal 一样转换参数和返回值
+func (s *State) Call(ctx context.Context, args ...interface{}) ([]interface{}, error) {
+	return s.call(ctx, "function", args)
+}
+
+func (s *State) call(ctx context.Context, name string, args []interface{}) (results []interface{}, err error) {
+	ls := s.L
+	base := ls.GetTop() - 1 /* below the function */
+	defer func() {
+		if err != nil {
+			ls.SetTop(base)
+		}
+	}()
+	if !ls.IsFunction(-1) {
+		return nil, fmt.Errorf("bind: %s is not a function", name)
+	}
+	var traceback string
+	ls.PushGoFunction(func(l api.LuaState) int { /* message handler */
+		l.Traceback(l, "", 1)
+		traceback = l.ToString(-1)
+		l.Pop(1)
+		return 1 /* the error object */
+	})
+	ls.Insert(-2)
+	for i, arg := range args {
+		if err = s.push(arg, fmt.Sprintf("args[%d]", i+1)); err != nil {
+			return nil, err
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if in, ok := ls.(interrupter); ok && ctx.Done() != nil {
+		done := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			defer close(done)
+			in.Interrupt(ctx.Err())
+		})
+		defer func() {
+			if !stop() { /* interrupt has fired: wait for it before clearing it */
+				<-done
+				in.Interrupt(nil)
+			}
+		}()
+	}
+	if ls.PCall(len(args), api.LUA_MULTRET, base+1) != api.LUA_OK {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, &api.LuaError{Value: ls.ToPointer(-1), Message: errorMessage(ls), Traceback: traceback}
+	}
+
+	n := ls.GetTop() - base - 1
+	results = make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		if err = s.get(base+i+2, &results[i], fmt.Sprintf("results[%d]", i+1)); err != nil {
+			return nil, err
+		}
+	}
+	ls.SetTop(base)
+	return results, nil
+}
+
+// 和 lua.c 的 msghandler 一样转换栈顶的错误对象
+func errorMessage(ls api.LuaState) string {
+	switch ls.Type(-1) {
+	case api.LUA_TSTRING, api.LUA_TNUMBER:
+		return ls.ToString(-1)
+	}
+	msg := fmt.Sprintf("(error object is a %s value)", ls.TypeName2(-1))
+	if ls.CallMeta(-1, "__tostring") {
+		if ls.Type(-1) == api.LUA_TSTRING {
+			msg = ls.ToString(-1)
+		}
+		ls.Pop(1)
+	}
+	return msg
+}
+
+// 把 v 转换成 Lua 值压入栈顶, 出错时栈不变
+func (s *State) Push(v interface{}) error {
+	return s.push(v, "value")
+}
+
+// 把 idx 处的值转换后存入 out 指向的变量
+func (s *State) Get(idx int, out interface{}) error {
+	return s.get(idx, out, "value")
+}
+
+func (s *State) SetGlobal(name string, v interface{}) error {
+	if err := s.push(v, name); err != nil {
+		return err
+	}
+	s.L.SetGlobal(name)
+	return nil
+}
+
+func (s *State) GetGlobal(name string, out interface{}) error {
+	s.L.GetGlobal(name)
+	defer s.L.Pop(1)
+	return s.get(-1, out, name)
+}
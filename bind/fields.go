@@ -0,0 +1,107 @@
+package bind
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	tagged    bool
+}
+
+var fieldCache sync.Map // reflect.Type -> []field
+
+// 结构体对应到 Lua 表的字段, 规则和 encoding/json 相同:
+// 嵌入结构体的字段展开到外层, 同名时层次浅的优先, 同一层有标签的优先,
+// 仍然分不出来的都忽略
+func structFields(t reflect.Type) []field {
+	if fs, ok := fieldCache.Load(t); ok {
+		return fs.([]field)
+	}
+	var all []field
+	collectFields(t, nil, map[reflect.Type]bool{}, &all)
+
+	byName := map[string][]field{}
+	var names []string
+	for _, f := range all {
+		if _, ok := byName[f.name]; !ok {
+			names = append(names, f.name)
+		}
+		byName[f.name] = append(byName[f.name], f)
+	}
+	fields := make([]field, 0, len(names))
+	for _, name := range names {
+		if f, ok := dominantField(byName[name]); ok {
+			fields = append(fields, f)
+		}
+	}
+	fs, _ := fieldCache.LoadOrStore(t, fields)
+	return fs.([]field)
+}
+
+func collectFields(t reflect.Type, index []int, visited map[reflect.Type]bool, all *[]field) {
+	if visited[t] {
+		return
+	}
+	visited[t] = true
+	defer delete(visited, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("lua")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		idx := append(append([]int(nil), index...), i)
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct && ft != timeType {
+			collectFields(ft, idx, visited, all)
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		f := field{name: name, index: idx, omitEmpty: opts == "omitempty", tagged: name != ""}
+		if f.name == "" {
+			f.name = sf.Name
+		}
+		*all = append(*all, f)
+	}
+}
+
+func dominantField(fs []field) (field, bool) {
+	depth := len(fs[0].index)
+	for _, f := range fs {
+		if len(f.index) < depth {
+			depth = len(f.index)
+		}
+	}
+	var found []field
+	for _, f := range fs {
+		if len(f.index) == depth {
+			found = append(found, f)
+		}
+	}
+	if len(found) > 1 {
+		var tagged []field
+		for _, f := range found {
+			if f.tagged {
+				tagged = append(tagged, f)
+			}
+		}
+		found = tagged
+	}
+	if len(found) != 1 {
+		return field{}, false
+	}
+	return found[0], true
+}
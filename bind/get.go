@@ -0,0 +1,386 @@
+package bind
+
+import (
+	"fmt"
+	"luago/api"
+	"math"
+	"reflect"
+	"time"
+)
+
+var emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+func (s *State) get(idx int, out interface{}, path string) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("bind: Get needs a non-nil pointer, got %T", out)
+	}
+	d := decoder{ls: s.L, seen: map[interface{}]bool{}}
+	return d.decode(s.L.AbsIndex(idx), v.Elem(), path)
+}
+
+type decoder struct {
+	ls   api.LuaState
+	seen map[interface{}]bool // 当前路径上的表, 用来发现环
+}
+
+func (d *decoder) decode(idx int, v reflect.Value, path string) error {
+	ls := d.ls
+	tp := ls.Type(idx)
+	if tp == api.LUA_TNIL || tp == api.LUA_TNONE {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Type() {
+	case emptyInterfaceType:
+		x, err := d.natural(idx, path)
+		if err == nil && x != nil {
+			v.Set(reflect.ValueOf(x))
+		}
+		return err
+	case timeType:
+		return d.decodeTime(idx, v, path)
+	case goFunctionType:
+		if !ls.IsGoFunction(idx) {
+			return d.mismatch(idx, "Go function", path)
+		}
+		v.Set(reflect.ValueOf(ls.ToGoFunction(idx)))
+		return nil
+	case bytesType:
+		if tp != api.LUA_TSTRING {
+			return d.mismatch(idx, "string", path)
+		}
+		str, _ := ls.ToStringX(idx)
+		v.SetBytes([]byte(str))
+		return nil
+	}
+
+	if tp == api.LUA_TUSERDATA {
+		if data := ls.ToUserdata(idx); data != nil && reflect.TypeOf(data).AssignableTo(v.Type()) {
+			v.Set(reflect.ValueOf(data))
+			return nil
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if tp != api.LUA_TBOOLEAN {
+			return d.mismatch(idx, "boolean", path)
+		}
+		v.SetBool(ls.ToBoolean(idx))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := d.integer(idx, path)
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(n) {
+			return convError(path, "%d overflows %s", n, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := d.integer(idx, path)
+		if err != nil {
+			return err
+		}
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			return convError(path, "%d overflows %s", n, v.Type())
+		}
+		v.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		if tp != api.LUA_TNUMBER {
+			return d.mismatch(idx, "number", path)
+		}
+		f, _ := ls.ToNumberX(idx)
+		if v.Kind() == reflect.Float32 && math.Abs(f) > math.MaxFloat32 && !math.IsInf(f, 0) {
+			return convError(path, "%g overflows float32", f)
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		if tp != api.LUA_TSTRING {
+			return d.mismatch(idx, "string", path)
+		}
+		str, _ := ls.ToStringX(idx)
+		v.SetString(str)
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(idx, v.Elem(), path)
+	case reflect.Interface:
+		x, err := d.natural(idx, path)
+		if err != nil {
+			return err
+		}
+		xv := reflect.ValueOf(x)
+		if !xv.Type().AssignableTo(v.Type()) {
+			return convError(path, "%s does not implement %s", xv.Type(), v.Type())
+		}
+		v.Set(xv)
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct:
+		if tp != api.LUA_TTABLE {
+			return d.mismatch(idx, "table", path)
+		}
+		key := ls.ToPointer(idx)
+		if d.seen[key] {
+			return convError(path, "table contains a cycle")
+		}
+		d.seen[key] = true
+		defer delete(d.seen, key)
+		if !ls.CheckStack(3) {
+			return convError(path, "stack overflow")
+		}
+		switch v.Kind() {
+		case reflect.Slice:
+			return d.decodeSlice(idx, v, path)
+		case reflect.Array:
+			return d.decodeArray(idx, v, path)
+		case reflect.Map:
+			return d.decodeMap(idx, v, path)
+		default:
+			return d.decodeStruct(idx, v, path)
+		}
+	default:
+		return convError(path, "cannot convert a Lua value to %s", v.Type())
+	}
+	return nil
+}
+
+func (d *decoder) mismatch(idx int, want, path string) error {
+	return convError(path, "%s expected, got %s", want, d.ls.TypeName2(idx))
+}
+
+func (d *decoder) integer(idx int, path string) (int64, error) {
+	if d.ls.Type(idx) != api.LUA_TNUMBER {
+		return 0, d.mismatch(idx, "number", path)
+	}
+	n, ok := d.ls.ToIntegerX(idx)
+	if !ok {
+		return 0, convError(path, "number has no integer representation")
+	}
+	return n, nil
+}
+
+func (d *decoder) decodeSlice(idx int, v reflect.Value, path string) error {
+	n := int(d.ls.RawLen(idx))
+	s := reflect.MakeSlice(v.Type(), n, n)
+	for i := 0; i < n; i++ {
+		if err := d.decodeElem(idx, int64(i+1), s.Index(i), path); err != nil {
+			return err
+		}
+	}
+	v.Set(s)
+	return nil
+}
+
+func (d *decoder) decodeArray(idx int, v reflect.Value, path string) error {
+	n := int(d.ls.RawLen(idx))
+	if n > v.Len() {
+		return convError(path, "sequence of %d values does not fit in %s", n, v.Type())
+	}
+	for i := 0; i < v.Len(); i++ {
+		if i >= n {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+			continue
+		}
+		if err := d.decodeElem(idx, int64(i+1), v.Index(i), path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) decodeElem(idx int, i int64, v reflect.Value, path string) error {
+	d.ls.RawGetI(idx, i)
+	defer d.ls.Pop(1)
+	return d.decode(d.ls.GetTop(), v, fmt.Sprintf("%s[%d]", path, i))
+}
+
+func (d *decoder) decodeMap(idx int, v reflect.Value, path string) error {
+	ls := d.ls
+	t := v.Type()
+	m := reflect.MakeMap(t)
+	ls.PushNil()
+	for ls.Next(idx) {
+		kpath := keyPath(path, d.keyString(-2))
+		k := reflect.New(t.Key()).Elem()
+		if err := d.decode(ls.GetTop()-1, k, kpath); err != nil {
+			ls.Pop(2)
+			return err
+		}
+		e := reflect.New(t.Elem()).Elem()
+		if err := d.decode(ls.GetTop(), e, kpath); err != nil {
+			ls.Pop(2)
+			return err
+		}
+		m.SetMapIndex(k, e)
+		ls.Pop(1)
+	}
+	v.Set(m)
+	return nil
+}
+
+// 表里没有的字段保持原值
+func (d *decoder) decodeStruct(idx int, v reflect.Value, path string) error {
+	ls := d.ls
+	for _, f := range structFields(v.Type()) {
+		if ls.GetField(idx, f.name) == api.LUA_TNIL {
+			ls.Pop(1)
+			continue
+		}
+		err := d.decode(ls.GetTop(), fieldByIndexAlloc(v, f.index), path+"."+f.name)
+		ls.Pop(1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 数字是 Unix 秒数; 表和 os.time 的参数一样, 按本地时间解释
+func (d *decoder) decodeTime(idx int, v reflect.Value, path string) error {
+	ls := d.ls
+	switch ls.Type(idx) {
+	case api.LUA_TNUMBER:
+		if n, ok := ls.ToIntegerX(idx); ok && ls.IsInteger(idx) {
+			v.Set(reflect.ValueOf(time.Unix(n, 0)))
+		} else {
+			f, _ := ls.ToNumberX(idx)
+			sec, frac := math.Modf(f)
+			v.Set(reflect.ValueOf(time.Unix(int64(sec), int64(frac*1e9))))
+		}
+		return nil
+	case api.LUA_TTABLE:
+		var fields [6]int
+		for i, name := range []string{"year", "month", "day", "hour", "min", "sec"} {
+			ls.GetField(idx, name)
+			n, ok := ls.ToIntegerX(-1)
+			isNil := ls.IsNil(-1)
+			ls.Pop(1)
+			switch {
+			case ok:
+				fields[i] = int(n)
+			case isNil && i == 3:
+				fields[i] = 12
+			case isNil && i > 3:
+				fields[i] = 0
+			default:
+				return convError(path+"."+name, "integer field expected")
+			}
+		}
+		t := time.Date(fields[0], time.Month(fields[1]), fields[2],
+			fields[3], fields[4], fields[5], 0, time.Local)
+		v.Set(reflect.ValueOf(t))
+		return nil
+	default:
+		return d.mismatch(idx, "number or table", path)
+	}
+}
+
+// 目标是 interface{} 时的转换
+func (d *decoder) natural(idx int, path string) (interface{}, error) {
+	ls := d.ls
+	switch ls.Type(idx) {
+	case api.LUA_TNIL, api.LUA_TNONE:
+		return nil, nil
+	case api.LUA_TBOOLEAN:
+		return ls.ToBoolean(idx), nil
+	case api.LUA_TNUMBER:
+		if ls.IsInteger(idx) {
+			n, _ := ls.ToIntegerX(idx)
+			return n, nil
+		}
+		f, _ := ls.ToNumberX(idx)
+		return f, nil
+	case api.LUA_TSTRING:
+		str, _ := ls.ToStringX(idx)
+		return str, nil
+	case api.LUA_TUSERDATA, api.LUA_TLIGHTUSERDATA:
+		return ls.ToUserdata(idx), nil
+	case api.LUA_TFUNCTION:
+		if ls.IsGoFunction(idx) {
+			return ls.ToGoFunction(idx), nil
+		}
+	case api.LUA_TTABLE:
+		var x interface{}
+		switch d.tableKind(idx) {
+		case seqTable:
+			x = &[]interface{}{}
+		case strTable:
+			x = &map[string]interface{}{}
+		default:
+			x = &map[interface{}]interface{}{}
+		}
+		v := reflect.ValueOf(x).Elem()
+		if err := d.decode(idx, v, path); err != nil {
+			return nil, err
+		}
+		return v.Interface(), nil
+	}
+	return nil, convError(path, "cannot convert %s", ls.TypeName2(idx))
+}
+
+const (
+	seqTable = iota // 键是 1..n
+	strTable        // 键都是字符串, 空表也算
+	anyTable
+)
+
+func (d *decoder) tableKind(idx int) int {
+	ls := d.ls
+	n := int64(ls.RawLen(idx))
+	count, strKeys := int64(0), true
+	ls.PushNil()
+	for ls.Next(idx) {
+		count++
+		if ls.Type(-2) != api.LUA_TSTRING {
+			strKeys = false
+		}
+		ls.Pop(1)
+	}
+	switch {
+	case count > 0 && count == n:
+		return seqTable
+	case strKeys:
+		return strTable
+	default:
+		return anyTable
+	}
+}
+
+// 用在路径里的键, 不改变栈上的值
+func (d *decoder) keyString(idx int) interface{} {
+	switch d.ls.Type(idx) {
+	case api.LUA_TSTRING:
+		str, _ := d.ls.ToStringX(idx)
+		return str
+	case api.LUA_TNUMBER:
+		x, _ := d.natural(idx, "")
+		return x
+	case api.LUA_TBOOLEAN:
+		return d.ls.ToBoolean(idx)
+	default:
+		return d.ls.TypeName2(idx)
+	}
+}
+
+func keyPath(path string, key interface{}) string {
+	if str, ok := key.(string); ok {
+		return fmt.Sprintf("%s[%q]", path, str)
+	}
+	return fmt.Sprintf("%s[%v]", path, key)
+}
+
+// 沿着嵌入的指针取字段, 指针为 nil 时分配一个
+func fieldByIndexAlloc(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
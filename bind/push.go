@@ -0,0 +1,147 @@
+package bind
+
+import (
+	"fmt"
+	"luago/api"
+	"math"
+	"reflect"
+	"time"
+)
+
+// 超过这个深度认为 Go 值里有环
+const maxDepth = 200
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	goFunctionType = reflect.TypeOf(api.GoFunction(nil))
+	bytesType      = reflect.TypeOf([]byte(nil))
+)
+
+func (s *State) push(v interface{}, path string) error {
+	top := s.L.GetTop()
+	if err := s.pushValue(reflect.ValueOf(v), path, 0); err != nil {
+		s.L.SetTop(top)
+		return err
+	}
+	return nil
+}
+
+func (s *State) pushValue(v reflect.Value, path string, depth int) error {
+	ls := s.L
+	if depth > maxDepth {
+		return convError(path, "value is nested too deeply (cycle?)")
+	}
+	if !v.IsValid() {
+		ls.PushNil()
+		return nil
+	}
+	if !ls.CheckStack(3) {
+		return convError(path, "stack overflow")
+	}
+
+	switch v.Type() {
+	case timeType:
+		t := v.Interface().(time.Time)
+		if t.Nanosecond() == 0 {
+			ls.PushInteger(t.Unix())
+		} else {
+			ls.PushNumber(float64(t.UnixNano()) / 1e9)
+		}
+		return nil
+	case goFunctionType:
+		if v.IsNil() {
+			ls.PushNil()
+		} else {
+			ls.PushGoFunction(v.Interface().(api.GoFunction))
+		}
+		return nil
+	case bytesType:
+		ls.PushString(string(v.Bytes()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		ls.PushBoolean(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		ls.PushInteger(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return convError(path, "%d overflows a Lua integer", v.Uint())
+		}
+		ls.PushInteger(int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		ls.PushNumber(v.Float())
+	case reflect.String:
+		ls.PushString(v.String())
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			ls.PushNil()
+			return nil
+		}
+		return s.pushValue(v.Elem(), path, depth+1)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			ls.PushNil()
+			return nil
+		}
+		n := v.Len()
+		ls.CreateTable(n, 0)
+		for i := 0; i < n; i++ {
+			if err := s.pushValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i+1), depth+1); err != nil {
+				return err
+			}
+			ls.RawSetI(-2, int64(i+1))
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			ls.PushNil()
+			return nil
+		}
+		ls.CreateTable(0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			kpath := keyPath(path, iter.Key().Interface())
+			if err := s.pushValue(iter.Key(), kpath, depth+1); err != nil {
+				return err
+			}
+			if ls.IsNil(-1) {
+				return convError(kpath, "table key is nil")
+			}
+			if err := s.pushValue(iter.Value(), kpath, depth+1); err != nil {
+				return err
+			}
+			ls.RawSet(-3)
+		}
+	case reflect.Struct:
+		fields := structFields(v.Type())
+		ls.CreateTable(0, len(fields))
+		for _, f := range fields {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok || f.omitEmpty && fv.IsZero() {
+				continue
+			}
+			if err := s.pushValue(fv, path+"."+f.name, depth+1); err != nil {
+				return err
+			}
+			ls.SetField(-2, f.name)
+		}
+	default:
+		return convError(path, "cannot convert %s to a Lua value", v.Type())
+	}
+	return nil
+}
+
+// 沿着嵌入的指针取字段, 指针为 nil 时 ok 为 false
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -9,10 +9,12 @@ type BreakStat struct {
 }
 
 type LabelStat struct {
+	Line int
 	Name string
 }
 
 type GotoStat struct {
+	Line int
 	Name string
 }
 
@@ -54,10 +56,12 @@ type ForInStat struct {
 	Block    *Block
 }
 
+// AttribList 与 NameList 一一对应, 取值为 "", "const" 或 "close"
 type LocalVarDeclStat struct {
-	LastLine int
-	NameList []string
-	ExpList  []Exp
+	LastLine   int
+	NameList   []string
+	AttribList []string
+	ExpList    []Exp
 }
 
 type AssignStat struct {
@@ -0,0 +1,156 @@
+package compiler
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"luago/binchunk"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 修改代码生成或者二进制 chunk 的格式时加一, 旧版本写到磁盘上的缓存就不会再被使用
+const cacheVersion = 1
+
+// 内存中最多保存的原型数量, 超过时丢弃最久没有使用的
+const maxCacheEntries = 1024
+
+// 在多个状态之间共享编译结果, 可以被多个 goroutine 同时使用.
+// 以 chunk 名和源码的哈希作为键, 缓存的原型是只读的.
+// 修改过的文件 (比如 package.reload) 会得到新的键, 旧的原型留在缓存里直到被挤出或者 Reset.
+// dir 不为空时编译结果还会以二进制 chunk 的形式保存到这个目录, 进程重启后直接读取
+type ProtoCache struct {
+	dir     string
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	lru     *list.List // 最近使用的在前面
+}
+
+type cacheEntry struct {
+	once  sync.Once
+	proto *binchunk.Prototype
+	err   interface{} // 编译时 panic 的值
+	elem  *list.Element
+}
+
+func NewProtoCache(dir string) *ProtoCache {
+	return &ProtoCache{dir: dir, entries: map[string]*cacheEntry{}, lru: list.New()}
+}
+
+// 和 Compile 一样, 语法错误时 panic; 同一个键同时只编译一次.
+// nil 缓存每次都重新编译
+func (c *ProtoCache) Compile(chunk, chunkName string) *binchunk.Prototype {
+	if c == nil {
+		return Compile(chunk, chunkName)
+	}
+	key := cacheKey(chunk, chunkName)
+	c.mu.Lock()
+	e := c.entries[key]
+	if e == nil {
+		e = &cacheEntry{}
+		e.elem = c.lru.PushFront(key)
+		c.entries[key] = e
+		if c.lru.Len() > maxCacheEntries {
+			c.remove(c.lru.Back().Value.(string))
+		}
+	} else {
+		c.lru.MoveToFront(e.elem)
+	}
+	c.mu.Unlock()
+
+	e.once.Do(func() {
+		defer func() {
+			if e.err = recover(); e.err != nil {
+				c.mu.Lock()
+				if c.entries[key] == e {
+					c.remove(key) // 错误不缓存, 下次重新编译
+				}
+				c.mu.Unlock()
+			}
+		}()
+		e.proto = c.load(key, chunk, chunkName)
+	})
+	if e.err != nil {
+		panic(e.err)
+	}
+	return e.proto
+}
+
+// 缓存中的原型数量
+func (c *ProtoCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// 清空内存中的缓存, 磁盘上的文件不受影响
+func (c *ProtoCache) Reset() {
+	c.mu.Lock()
+	c.entries = map[string]*cacheEntry{}
+	c.lru.Init()
+	c.mu.Unlock()
+}
+
+// 调用时要持有 mu
+func (c *ProtoCache) remove(key string) {
+	c.lru.Remove(c.entries[key].elem)
+	delete(c.entries, key)
+}
+
+func (c *ProtoCache) load(key, chunk, chunkName string) *binchunk.Prototype {
+	if c.dir == "" {
+		return Compile(chunk, chunkName)
+	}
+	filename := filepath.Join(c.dir, key+".luac")
+	if proto := undumpFile(filename, chunkName); proto != nil {
+		return proto
+	}
+	proto := Compile(chunk, chunkName)
+	writeFile(filename, binchunk.Dump(proto, false))
+	return proto
+}
+
+func cacheKey(chunk, chunkName string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:%d:%s", cacheVersion, len(chunkName), chunkName)
+	h.Write([]byte(chunk))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 文件不存在或已损坏时返回 nil, 重新编译
+func undumpFile(filename, chunkName string) (proto *binchunk.Prototype) {
+	data, err := os.ReadFile(filename)
+	if err != nil || !binchunk.IsBinaryChunk(data) {
+		return nil
+	}
+	defer func() {
+		if recover() != nil {
+			proto = nil
+		}
+	}()
+	proto = binchunk.Undump(data)
+	if proto.Source != chunkName {
+		return nil
+	}
+	return proto
+}
+
+// 先写临时文件再改名, 其他进程不会读到写了一半的文件; 写失败只是不缓存
+func writeFile(filename string, data []byte) {
+	f, err := os.CreateTemp(filepath.Dir(filename), ".luac-*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(data)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
@@ -3,8 +3,18 @@ package codegen
 import "luago/compiler/ast"
 
 func cgBlock(fi *funcInfo, node *ast.Block) {
-	for _, stat := range node.Stats {
-		cgStat(fi, stat)
+	cgBlockUntil(fi, node, false)
+}
+
+// repeat 的循环体后面还有 until 表达式, 末尾的标签仍在局部变量的作用域里
+func cgBlockUntil(fi *funcInfo, node *ast.Block, hasUntil bool) {
+	for i, stat := range node.Stats {
+		if label, ok := stat.(*ast.LabelStat); ok && !hasUntil &&
+			node.RetExps == nil && onlyLabels(node.Stats[i+1:]) {
+			fi.addLabel(label.Name, label.Line, true)
+		} else {
+			cgStat(fi, stat)
+		}
 	}
 
 	if node.RetExps != nil {
@@ -36,4 +46,12 @@ func cgRetStat(fi *funcInfo, exps []ast.Exp, lastLine int) {
 	} else {
 		fi.emitReturn(lastLine, a, nExps)
 	}
-}
\ No newline at end of file
+}
+func onlyLabels(stats []ast.Stat) bool {
+	for _, stat := range stats {
+		if _, ok := stat.(*ast.LabelStat); !ok {
+			return false
+		}
+	}
+	return true
+}
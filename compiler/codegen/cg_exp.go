@@ -70,6 +70,7 @@ func cgFuncDefExp(fi *funcInfo, node *ast.FuncDefExp, a int) {
 
 	cgBlock(subFI, node.Block)
 	subFI.exitScope(subFI.pc() + 2)
+	subFI.checkPendingGotos()
 	subFI.emitReturn(node.LastLine, 0, 0)
 
 	bx := len(fi.subFuncs) - 1
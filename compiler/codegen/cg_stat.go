@@ -26,8 +26,10 @@ func cgStat(fi *funcInfo, node ast.Stat) {
 		cgLocalVarDeclStat(fi, stat)
 	case *ast.LocalFuncDefStat:
 		cgLocalFuncDefStat(fi, stat)
-	case *ast.LabelStat, *ast.GotoStat:
-		panic("not supported!")
+	case *ast.LabelStat:
+		fi.addLabel(stat.Name, stat.Line, false)
+	case *ast.GotoStat:
+		fi.addGoto(stat.Name, stat.Line)
 	}
 }
 
@@ -43,7 +45,7 @@ func cgFuncCallStat(fi *funcInfo, node *ast.FuncCallStat) {
 }
 
 func cgBreakStat(fi *funcInfo, node *ast.BreakStat) {
-	pc := fi.emitJmp(node.Line, 0, 0)
+	pc := fi.emitJmp(node.Line, fi.getBreakJmpArgA(), 0)
 	fi.addBreakJmp(pc)
 }
 
@@ -78,7 +80,7 @@ func cgRepeatStat(fi *funcInfo, node *ast.RepeatStat) {
 	fi.enterScope(true)
 
 	pcBeforeBlock := fi.pc()
-	cgBlock(fi, node.Block)
+	cgBlockUntil(fi, node.Block, true)
 
 	oldRegs := fi.usedRegs
 	a, _ := expToOpArg(fi, node.Exp, ARG_REG)
@@ -229,8 +231,12 @@ func cgLocalVarDeclStat(fi *funcInfo, node *ast.LocalVarDeclStat) {
 
 	fi.usedRegs = oldRegs
 	startPC := fi.pc() + 1
-	for _, name := range node.NameList {
-		fi.addLocVar(name, startPC)
+	for i, name := range node.NameList {
+		a := fi.addLocVar(name, startPC)
+		if node.AttribList != nil && node.AttribList[i] == "close" {
+			fi.locNames[name].tbc = true
+			fi.emitTBC(node.LastLine, a)
+		}
 	}
 }
 
@@ -5,7 +5,7 @@ import (
 	"luago/compiler/ast"
 )
 
-func GenProto(chunk *ast.Block) *binchunk.Prototype {
+func GenProto(chunk *ast.Block, chunkName string) *binchunk.Prototype {
 	fd := &ast.FuncDefExp{
 		LastLine: chunk.LastLine,
 		IsVararg: true,
@@ -13,6 +13,7 @@ func GenProto(chunk *ast.Block) *binchunk.Prototype {
 	}
 
 	fi := newFuncInfo(nil, fd)
+	fi.chunkName = chunkName
 	fi.addLocVar("_ENV", 0)
 	cgFuncDefExp(fi, fd, 0)
 	return toProto(fi.subFuncs[0])
@@ -4,6 +4,7 @@ import "luago/binchunk"
 
 func toProto(fi *funcInfo) *binchunk.Prototype {
 	proto := &binchunk.Prototype{
+		Version:         binchunk.LUAC_VERSION,
 		LineDefined:     uint32(fi.line),
 		LastLineDefined: uint32(fi.lastLine),
 		NumParams:       byte(fi.numParams),
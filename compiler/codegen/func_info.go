@@ -1,6 +1,7 @@
 package codegen
 
 import (
+	"fmt"
 	"luago/compiler/ast"
 	"luago/compiler/lexer"
 	"luago/vm"
@@ -29,6 +30,9 @@ type funcInfo struct {
 	locVars   []*locVarInfo
 	locNames  map[string]*locVarInfo
 	breaks    [][]int
+	scopeRegs []int // 进入每层作用域时已经占用的寄存器数
+	labels    []*labelInfo
+	gotos     []*gotoInfo // 还没有找到标签的 goto
 	parent    *funcInfo
 	upvalues  map[string]upvalInfo
 	insts     []uint32
@@ -38,10 +42,11 @@ type funcInfo struct {
 	lineNums  []uint32
 	line      int
 	lastLine  int
+	chunkName string // 用于错误信息
 }
 
 func newFuncInfo(parent *funcInfo, fd *ast.FuncDefExp) *funcInfo {
-	return &funcInfo{
+	fi := &funcInfo{
 		parent:    parent,
 		subFuncs:  []*funcInfo{},
 		locVars:   make([]*locVarInfo, 0, 8),
@@ -49,6 +54,7 @@ func newFuncInfo(parent *funcInfo, fd *ast.FuncDefExp) *funcInfo {
 		upvalues:  map[string]upvalInfo{},
 		constants: map[interface{}]int{},
 		breaks:    make([][]int, 1),
+		scopeRegs: make([]int, 1),
 		insts:     make([]uint32, 0, 8),
 		lineNums:  make([]uint32, 0, 8),
 		line:      fd.Line,
@@ -56,6 +62,10 @@ func newFuncInfo(parent *funcInfo, fd *ast.FuncDefExp) *funcInfo {
 		numParams: len(fd.ParList),
 		isVararg:  fd.IsVararg,
 	}
+	if parent != nil {
+		fi.chunkName = parent.chunkName
+	}
+	return fi
 }
 
 func (fi *funcInfo) indexOfConstant(k interface{}) int {
@@ -110,10 +120,29 @@ type locVarInfo struct {
 	startPC  int
 	endPC    int
 	captured bool
+	tbc      bool // <close> 变量
+}
+
+type labelInfo struct {
+	name    string
+	line    int
+	scopeLv int
+	nRegs   int // 标签处活跃的局部变量占用的寄存器数
+	pc      int
+}
+
+type gotoInfo struct {
+	name     string
+	line     int
+	scopeLv  int
+	nRegs    int // 离开内层块时会降到块开始时的寄存器数
+	origRegs int // goto 处实际占用的寄存器数, 决定跳转时要关闭哪些变量
+	pc       int
 }
 
 func (fi *funcInfo) enterScope(breakable bool) {
 	fi.scopeLv++
+	fi.scopeRegs = append(fi.scopeRegs, fi.usedRegs)
 	if breakable {
 		fi.breaks = append(fi.breaks, []int{})
 	} else {
@@ -131,6 +160,104 @@ func (fi *funcInfo) addBreakJmp(pc int) {
 	panic("<break> at line ? not inside a loop!")
 }
 
+// break 要关闭循环体里已经声明的局部变量
+func (fi *funcInfo) getBreakJmpArgA() int {
+	loopLv := fi.scopeLv
+	for fi.breaks[loopLv] == nil {
+		if loopLv--; loopLv < 0 {
+			return 0 // addBreakJmp 会报错
+		}
+	}
+	minSlotOfLocVars := -1
+	for _, locVar := range fi.locNames {
+		for v := locVar; v != nil && v.scopeLv >= loopLv; v = v.prev {
+			if v.name[0] != '(' && (minSlotOfLocVars < 0 || v.slot < minSlotOfLocVars) {
+				minSlotOfLocVars = v.slot
+			}
+		}
+	}
+	return minSlotOfLocVars + 1
+}
+
+func (fi *funcInfo) addLabel(name string, line int, atBlockEnd bool) {
+	for _, label := range fi.labels {
+		if label.name == name {
+			panic(fmt.Sprintf("label '%s' already defined on line %d", name, label.line))
+		}
+	}
+
+	nRegs := fi.usedRegs
+	if atBlockEnd { // 块末尾的标签不在块内局部变量的作用域里
+		nRegs = fi.scopeRegs[fi.scopeLv]
+	}
+	label := &labelInfo{name, line, fi.scopeLv, nRegs, fi.pc() + 1}
+	fi.labels = append(fi.labels, label)
+
+	pending := fi.gotos[:0]
+	for _, g := range fi.gotos {
+		if g.name == name && g.scopeLv == fi.scopeLv {
+			fi.fixGotoJmp(g, label)
+		} else {
+			pending = append(pending, g)
+		}
+	}
+	fi.gotos = pending
+}
+
+func (fi *funcInfo) addGoto(name string, line int) {
+	for i := len(fi.labels) - 1; i >= 0; i-- {
+		if label := fi.labels[i]; label.name == name { // 向后跳转
+			a := 0
+			if fi.usedRegs > label.nRegs {
+				a = label.nRegs + 1
+			}
+			pc := fi.emitJmp(line, a, 0)
+			fi.fixSbx(pc, label.pc-pc-1)
+			return
+		}
+	}
+
+	pc := fi.emitJmp(line, 0, 0)
+	fi.gotos = append(fi.gotos, &gotoInfo{
+		name:     name,
+		line:     line,
+		scopeLv:  fi.scopeLv,
+		nRegs:    fi.usedRegs,
+		origRegs: fi.usedRegs,
+		pc:       pc,
+	})
+}
+
+func (fi *funcInfo) fixGotoJmp(g *gotoInfo, label *labelInfo) {
+	if g.nRegs < label.nRegs {
+		name := "?"
+		for _, locVar := range fi.locNames {
+			for v := locVar; v != nil; v = v.prev {
+				if v.slot == g.nRegs {
+					name = v.name
+				}
+			}
+		}
+		panic(fmt.Sprintf("%s:%d: <goto %s> at line %d jumps into the scope of local '%s'",
+			fi.chunkName, g.line, g.name, g.line, name))
+	}
+
+	a := 0
+	if g.origRegs > label.nRegs {
+		a = label.nRegs + 1
+	}
+	sBx := label.pc - g.pc - 1
+	fi.insts[g.pc] = uint32((sBx+vm.MAXARG_sBx)<<14 | a<<6 | vm.OP_JMP)
+}
+
+func (fi *funcInfo) checkPendingGotos() {
+	if len(fi.gotos) > 0 {
+		g := fi.gotos[0]
+		panic(fmt.Sprintf("%s:%d: no visible label '%s' for <goto> at line %d",
+			fi.chunkName, g.line, g.name, g.line))
+	}
+}
+
 func (fi *funcInfo) addLocVar(name string, startPC int) int {
 	newVar := &locVarInfo{
 		name:    name,
@@ -158,14 +285,30 @@ func (fi *funcInfo) exitScope(endPC int) {
 	pendingBreakJmps := fi.breaks[len(fi.breaks)-1]
 	fi.breaks = fi.breaks[:len(fi.breaks)-1]
 
-	a := fi.getJmpArgA()
 	for _, pc := range pendingBreakJmps {
-		sBx := fi.pc() - pc
-		i := (sBx+vm.MAXARG_sBx)<<14 | a<<6 | vm.OP_JMP
-		fi.insts[pc] = uint32(i)
+		fi.fixSbx(pc, fi.pc()-pc)
 	}
 
+	blockRegs := fi.scopeRegs[len(fi.scopeRegs)-1]
+	fi.scopeRegs = fi.scopeRegs[:len(fi.scopeRegs)-1]
 	fi.scopeLv--
+
+	labels := fi.labels[:0]
+	for _, label := range fi.labels {
+		if label.scopeLv <= fi.scopeLv {
+			labels = append(labels, label)
+		}
+	}
+	fi.labels = labels
+	for _, g := range fi.gotos { // 移到外层块里继续找标签
+		if g.scopeLv > fi.scopeLv {
+			g.scopeLv = fi.scopeLv
+			if g.nRegs > blockRegs {
+				g.nRegs = blockRegs
+			}
+		}
+	}
+
 	for _, locVar := range fi.locNames {
 		if locVar.scopeLv > fi.scopeLv { // out of scope
 			locVar.endPC = endPC
@@ -267,7 +410,7 @@ func (fi *funcInfo) getJmpArgA() int {
 	for _, locVar := range fi.locNames {
 		if locVar.scopeLv == fi.scopeLv {
 			for v := locVar; v != nil && v.scopeLv == fi.scopeLv; v = v.prev {
-				if v.captured {
+				if v.captured || v.tbc {
 					hasCapturedLocVars = true
 				}
 				if v.slot < minSlotOfLocVars && v.name[0] != '(' {
@@ -434,3 +577,7 @@ func (fi *funcInfo) emitSetTabUp(line, a, b, c int) {
 func (fi *funcInfo) emitSetUpval(line, a, b int) {
 	fi.emitABC(line, vm.OP_SETUPVAL, a, b, 0)
 }
+
+func (fi *funcInfo) emitTBC(line, a int) {
+	fi.emitABC(line, vm.OP_TBC, a, 0, 0)
+}
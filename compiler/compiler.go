@@ -8,7 +8,7 @@ import (
 
 func Compile(chunk, chunkName string) *binchunk.Prototype {
 	ast := parser.Parse(chunk, chunkName)
-	proto := codegen.GenProto(ast)
+	proto := codegen.GenProto(ast, chunkName)
 	setSource(proto, chunkName)
 	return proto
 }
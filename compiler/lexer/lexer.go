@@ -297,6 +297,11 @@ func isNewLine(c byte) bool {
 	return c == '\r' || c == '\n'
 }
 
+// 供语法分析报告错误, 带上源文件名和行号
+func (l *Lexer) Error(f string, a ...interface{}) {
+	l.error(f, a...)
+}
+
 func (l *Lexer) error(f string, a ...interface{}) {
 	err := fmt.Sprintf(f, a...)
 	err = fmt.Sprintf("%s:%d: %s", l.chunkName, l.line, err)
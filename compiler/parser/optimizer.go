@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"fmt"
 	"luago/compiler/ast"
 	"luago/compiler/lexer"
 	"luago/number"
@@ -187,3 +188,218 @@ func castToFloat(exp ast.Exp) (float64, bool) {
 		return 0, false
 	}
 }
+
+func optimizeBinaryOp(exp *ast.BinopExp) ast.Exp {
+	switch exp.Op {
+	case lexer.TOKEN_OP_AND:
+		return optimizeLogicalAnd(exp)
+	case lexer.TOKEN_OP_BAND, lexer.TOKEN_OP_BOR, lexer.TOKEN_OP_BXOR,
+		lexer.TOKEN_OP_SHL, lexer.TOKEN_OP_SHR:
+		return optimizeBitwiseBinaryOp(exp)
+	case lexer.TOKEN_OP_ADD, lexer.TOKEN_OP_SUB, lexer.TOKEN_OP_MUL,
+		lexer.TOKEN_OP_MOD, lexer.TOKEN_OP_POW, lexer.TOKEN_OP_DIV,
+		lexer.TOKEN_OP_IDIV:
+		return optimizeArithBinaryOp(exp)
+	default:
+		return exp
+	}
+}
+
+// <const> 局部变量, 初始值是字面量时就是编译期常量
+type constVar struct {
+	name   string
+	attrib string
+	val    ast.Exp
+}
+
+// 语法分析之后再遍历一遍语法树: 把编译期常量替换成字面量并重新折叠,
+// 同时检查对 <const> 和 <close> 变量的赋值
+type constFolder struct {
+	chunkName string
+	vars      []constVar // 当前可见的局部变量
+}
+
+func foldConstants(block *ast.Block, chunkName string) {
+	cf := &constFolder{chunkName: chunkName}
+	cf.foldBlock(block)
+}
+
+func (cf *constFolder) lookup(name string) *constVar {
+	for i := len(cf.vars) - 1; i >= 0; i-- {
+		if cf.vars[i].name == name {
+			return &cf.vars[i]
+		}
+	}
+	return nil
+}
+
+func (cf *constFolder) addVar(name, attrib string, val ast.Exp) {
+	cf.vars = append(cf.vars, constVar{name, attrib, val})
+}
+
+func (cf *constFolder) foldBlock(block *ast.Block) {
+	n := len(cf.vars)
+	cf.foldStats(block.Stats)
+	cf.foldExps(block.RetExps)
+	cf.vars = cf.vars[:n]
+}
+
+func (cf *constFolder) foldStats(stats []ast.Stat) {
+	for _, stat := range stats {
+		cf.foldStat(stat)
+	}
+}
+
+func (cf *constFolder) foldStat(node ast.Stat) {
+	switch stat := node.(type) {
+	case *ast.FuncCallStat:
+		cf.foldFuncCallExp(stat)
+	case *ast.DoStat:
+		cf.foldBlock(stat.Block)
+	case *ast.WhileStat:
+		stat.Exp = cf.foldExp(stat.Exp)
+		cf.foldBlock(stat.Block)
+	case *ast.RepeatStat: // until 后面的表达式能看到循环体里的局部变量
+		n := len(cf.vars)
+		cf.foldStats(stat.Block.Stats)
+		cf.foldExps(stat.Block.RetExps)
+		stat.Exp = cf.foldExp(stat.Exp)
+		cf.vars = cf.vars[:n]
+	case *ast.IfStat:
+		cf.foldExps(stat.Exps)
+		for _, block := range stat.Blocks {
+			cf.foldBlock(block)
+		}
+	case *ast.ForNumStat:
+		stat.InitExp = cf.foldExp(stat.InitExp)
+		stat.LimitExp = cf.foldExp(stat.LimitExp)
+		stat.StepExp = cf.foldExp(stat.StepExp)
+		n := len(cf.vars)
+		cf.addVar(stat.VarName, "", nil)
+		cf.foldBlock(stat.Block)
+		cf.vars = cf.vars[:n]
+	case *ast.ForInStat:
+		cf.foldExps(stat.ExpList)
+		n := len(cf.vars)
+		for _, name := range stat.NameList {
+			cf.addVar(name, "", nil)
+		}
+		cf.foldBlock(stat.Block)
+		cf.vars = cf.vars[:n]
+	case *ast.LocalVarDeclStat:
+		cf.foldExps(stat.ExpList)
+		for i, name := range stat.NameList {
+			attrib := stat.AttribList[i]
+			var val ast.Exp
+			if attrib == "const" {
+				if i < len(stat.ExpList) {
+					val = constValue(stat.ExpList[i])
+				} else if !isMultRet(stat.ExpList) {
+					val = &ast.NilExp{}
+				}
+			}
+			cf.addVar(name, attrib, val)
+		}
+	case *ast.AssignStat:
+		for i, exp := range stat.VarList {
+			if nameExp, ok := exp.(*ast.NameExp); ok {
+				if v := cf.lookup(nameExp.Name); v != nil && v.attrib != "" {
+					panic(fmt.Sprintf("%s:%d: attempt to assign to const variable '%s'",
+						cf.chunkName, nameExp.Line, nameExp.Name))
+				}
+			} else {
+				stat.VarList[i] = cf.foldExp(exp)
+			}
+		}
+		cf.foldExps(stat.ExpList)
+	case *ast.LocalFuncDefStat:
+		cf.addVar(stat.Name, "", nil)
+		cf.foldExp(stat.Exp)
+	}
+}
+
+func (cf *constFolder) foldExps(exps []ast.Exp) {
+	for i, exp := range exps {
+		exps[i] = cf.foldExp(exp)
+	}
+}
+
+func (cf *constFolder) foldExp(node ast.Exp) ast.Exp {
+	switch exp := node.(type) {
+	case *ast.NameExp:
+		if v := cf.lookup(exp.Name); v != nil && v.val != nil {
+			return copyConst(v.val, exp.Line)
+		}
+	case *ast.ParensExp:
+		exp.Exp = cf.foldExp(exp.Exp)
+		if constValue(exp.Exp) != nil {
+			return exp.Exp
+		}
+	case *ast.UnopExp:
+		exp.Exp = cf.foldExp(exp.Exp)
+		return optimizeUnaryOp(exp)
+	case *ast.BinopExp:
+		exp.Exp1 = cf.foldExp(exp.Exp1)
+		exp.Exp2 = cf.foldExp(exp.Exp2)
+		return optimizeBinaryOp(exp)
+	case *ast.ConcatExp:
+		cf.foldExps(exp.Exps)
+	case *ast.TableConstructorExp:
+		for i, keyExp := range exp.KeyExps {
+			if keyExp != nil {
+				exp.KeyExps[i] = cf.foldExp(keyExp)
+			}
+		}
+		cf.foldExps(exp.ValExps)
+	case *ast.FuncDefExp:
+		n := len(cf.vars)
+		for _, param := range exp.ParList {
+			cf.addVar(param, "", nil)
+		}
+		cf.foldBlock(exp.Block)
+		cf.vars = cf.vars[:n]
+	case *ast.TableAccessExp:
+		exp.PrefixExp = cf.foldExp(exp.PrefixExp)
+		exp.KeyExp = cf.foldExp(exp.KeyExp)
+	case *ast.FuncCallExp:
+		cf.foldFuncCallExp(exp)
+	}
+	return node
+}
+
+func (cf *constFolder) foldFuncCallExp(exp *ast.FuncCallExp) {
+	exp.PrefixExp = cf.foldExp(exp.PrefixExp)
+	cf.foldExps(exp.Args)
+}
+
+func isMultRet(exps []ast.Exp) bool {
+	return len(exps) > 0 && isVarargOrFuncCall(exps[len(exps)-1])
+}
+
+// 字面量返回自身, 否则返回 nil
+func constValue(exp ast.Exp) ast.Exp {
+	switch exp.(type) {
+	case *ast.NilExp, *ast.TrueExp, *ast.FalseExp,
+		*ast.IntegerExp, *ast.FloatExp, *ast.StringExp:
+		return exp
+	}
+	return nil
+}
+
+// 折叠时会原地修改字面量节点, 所以每次替换都要复制一份
+func copyConst(exp ast.Exp, line int) ast.Exp {
+	switch x := exp.(type) {
+	case *ast.NilExp:
+		return &ast.NilExp{Line: line}
+	case *ast.TrueExp:
+		return &ast.TrueExp{Line: line}
+	case *ast.FalseExp:
+		return &ast.FalseExp{Line: line}
+	case *ast.IntegerExp:
+		return &ast.IntegerExp{Line: line, Val: x.Val}
+	case *ast.FloatExp:
+		return &ast.FloatExp{Line: line, Val: x.Val}
+	default:
+		return &ast.StringExp{Line: line, Str: x.(*ast.StringExp).Str}
+	}
+}
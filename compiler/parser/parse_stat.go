@@ -46,15 +46,15 @@ func parseBreakStat(l *lexer.Lexer) *ast.BreakStat {
 
 func parseLabelStat(l *lexer.Lexer) *ast.LabelStat {
 	l.NextTokenOfKind(lexer.TOKEN_SEP_LABEL)
-	_, name := l.NextIdentifier()
+	line, name := l.NextIdentifier()
 	l.NextTokenOfKind(lexer.TOKEN_SEP_LABEL)
-	return &ast.LabelStat{Name: name}
+	return &ast.LabelStat{Line: line, Name: name}
 }
 
 func parseGotoStat(l *lexer.Lexer) *ast.GotoStat {
-	l.NextTokenOfKind(lexer.TOKEN_KW_GOTO)
+	line, _ := l.NextTokenOfKind(lexer.TOKEN_KW_GOTO)
 	_, name := l.NextIdentifier()
-	return &ast.GotoStat{Name: name}
+	return &ast.GotoStat{Line: line, Name: name}
 }
 
 func parseDoStat(l *lexer.Lexer) *ast.DoStat {
@@ -188,9 +188,9 @@ func _finishLocalFuncDefStat(l *lexer.Lexer) *ast.LocalFuncDefStat {
 	return &ast.LocalFuncDefStat{Name: name, Exp: fdExp}
 }
 
+// local attnamelist [`=` explist]
 func _finishLocalVarDeclStat(l *lexer.Lexer) *ast.LocalVarDeclStat {
-	_, name0 := l.NextIdentifier()
-	nameList := _finishNameList(l, name0)
+	nameList, attribList := _finishAttNameList(l)
 	var expList []ast.Exp = nil
 	if l.LookAhead() == lexer.TOKEN_OP_ASSIGN {
 		l.NextToken()
@@ -198,10 +198,46 @@ func _finishLocalVarDeclStat(l *lexer.Lexer) *ast.LocalVarDeclStat {
 	}
 	lastLine := l.Line()
 	return &ast.LocalVarDeclStat{
-		LastLine: lastLine,
-		NameList: nameList,
-		ExpList:  expList,
+		LastLine:   lastLine,
+		NameList:   nameList,
+		AttribList: attribList,
+		ExpList:    expList,
+	}
+}
+
+// attnamelist ::=  Name attrib {`,` Name attrib}
+func _finishAttNameList(l *lexer.Lexer) (names, attribs []string) {
+	hasClose := false
+	for {
+		_, name := l.NextIdentifier()
+		attrib := _parseAttrib(l)
+		if attrib == "close" {
+			if hasClose {
+				l.Error("multiple to-be-closed variables in local list")
+			}
+			hasClose = true
+		}
+		names = append(names, name)
+		attribs = append(attribs, attrib)
+		if l.LookAhead() != lexer.TOKEN_SEP_COMMA {
+			return
+		}
+		l.NextToken()
+	}
+}
+
+// attrib ::= [`<` Name `>`]
+func _parseAttrib(l *lexer.Lexer) string {
+	if l.LookAhead() != lexer.TOKEN_OP_LT {
+		return ""
+	}
+	l.NextToken()
+	_, attrib := l.NextIdentifier()
+	l.NextTokenOfKind(lexer.TOKEN_OP_GT)
+	if attrib != "const" && attrib != "close" {
+		l.Error("unknown attribute '%s'", attrib)
 	}
+	return attrib
 }
 
 func parseAssignOrFuncCallStat(l *lexer.Lexer) ast.Stat {
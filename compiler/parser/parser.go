@@ -9,5 +9,6 @@ func Parse(chunk, chunkName string) *ast.Block {
 	l := lexer.NewLexer(chunk, chunkName)
 	block := parseBlock(l)
 	l.NextTokenOfKind(lexer.TOKEN_EOF)
+	foldConstants(block, chunkName)
 	return block
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"luago/api"
 	"luago/state"
 	"os"
 )
@@ -9,7 +11,15 @@ func main() {
 	if len(os.Args) > 1 {
 		ls := state.New()
 		ls.OpenLibs()
-		ls.LoadFile(os.Args[1])
+		if ls.LoadFile(os.Args[1]) != api.LUA_OK {
+			fmt.Fprintln(os.Stderr, ls.ToString(-1))
+			os.Exit(1)
+		}
+		ls.SetErrorMode(state.ErrorReturn) // 出错时打印消息和调用栈, 不让进程崩溃
 		ls.Call(0, -1)
+		if err := ls.Err(); err != nil {
+			fmt.Fprintln(os.Stderr, "lua:", err)
+			os.Exit(1)
+		}
 	}
 }
@@ -1,8 +1,11 @@
 package number
 
 import (
+	"errors"
+	"fmt"
 	"math"
 	"strconv"
+	"strings"
 )
 
 // lua 整除是向下取， 3 // -2 == -2
@@ -47,13 +50,110 @@ func FloatToInteger(f float64) (int64, bool) {
 	return i, float64(i) == f
 }
 
+// 和 C 的 isspace 一样的空白字符
+const spaces = " \f\n\r\t\v"
+
+// 和 l_str2int 一样: 允许前后的空白, 十六进制溢出时回绕, 十进制溢出时失败 (按浮点数转换)
 func ParseInteger(str string) (int64, bool) {
+	str = strings.Trim(str, spaces)
+	neg, digits := splitSign(str)
+	if len(digits) == 0 || digits[0] == '+' || digits[0] == '-' {
+		return 0, false
+	}
+	if hex, ok := cutHexPrefix(digits); ok {
+		var n uint64
+		for i := 0; i < len(hex); i++ {
+			d := hexDigit(hex[i])
+			if d < 0 {
+				return 0, false
+			}
+			n = n*16 + uint64(d)
+		}
+		if len(hex) == 0 {
+			return 0, false
+		}
+		if neg {
+			n = -n
+		}
+		return int64(n), true
+	}
+	for i := 0; i < len(digits); i++ {
+		if digits[i] < '0' || digits[i] > '9' {
+			return 0, false
+		}
+	}
 	i, err := strconv.ParseInt(str, 10, 64)
 	return i, err == nil
 }
 
+// 和 l_str2d 一样: 不接受 'inf' 和 'nan', 十六进制可以没有指数
 func ParseFloat(str string) (float64, bool) {
+	str = strings.Trim(str, spaces)
+	if strings.ContainsAny(str, "nN_") {
+		return 0, false
+	}
+	_, digits := splitSign(str)
+	if _, ok := cutHexPrefix(digits); ok && !strings.ContainsAny(str, "pP") {
+		str += "p0"
+	}
 	f, err := strconv.ParseFloat(str, 64)
-	return f, err == nil
+	if err != nil && !errors.Is(err, strconv.ErrRange) { // 溢出时和 strtod 一样得到 HUGE_VAL
+		return 0, false
+	}
+	return f, true
+}
+
+func splitSign(str string) (neg bool, rest string) {
+	if len(str) > 0 && (str[0] == '-' || str[0] == '+') {
+		return str[0] == '-', str[1:]
+	}
+	return false, str
 }
 
+func cutHexPrefix(str string) (string, bool) {
+	if len(str) >= 2 && str[0] == '0' && (str[1] == 'x' || str[1] == 'X') {
+		return str[2:], true
+	}
+	return str, false
+}
+
+func hexDigit(c byte) int {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0')
+	case 'a' <= c && c <= 'f':
+		return int(c-'a') + 10
+	case 'A' <= c && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
+
+// 和 LUAI_NUMFFORMAT 一样按 %.14g 格式化, 无穷和 NaN 的写法和 C 一致
+func FormatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f) && math.Signbit(f):
+		return "-nan"
+	case math.IsNaN(f):
+		return "nan"
+	default:
+		return fmt.Sprintf("%.14g", f)
+	}
+}
+
+// 浮点数转字符串 看起来像整数时加上 ".0", 例如 3.0 和 1e+15
+func FloatToString(f float64) string {
+	s := FormatFloat(f)
+	if strings.Trim(s, "-0123456789") == "" {
+		s += ".0"
+	}
+	return s
+}
+
+func IntegerToString(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
@@ -1,9 +1,6 @@
 package state
 
-import (
-	"fmt"
-	"luago/api"
-)
+import "luago/api"
 
 
 func (ls *luaState) RawLen(idx int) uint {
@@ -28,6 +25,8 @@ func (ls *luaState) TypeName(tp api.LuaType) string {
 		return "boolean"
 	case api.LUA_TNUMBER:
 		return "number"
+	case api.LUA_TSTRING:
+		return "string"
 	case api.LUA_TTABLE:
 		return "table"
 	case api.LUA_TFUNCTION:
@@ -130,7 +129,7 @@ func (ls *luaState) ToStringX(idx int) (string, bool) {
 	case string:
 		return x, true
 	case int64, float64:
-		s := fmt.Sprintf("%v", x)
+		s := ls.numberToString(x)
 		ls.stack.set(idx, s) // 这里会修改栈
 		return s, true
 	default:
@@ -138,6 +137,22 @@ func (ls *luaState) ToStringX(idx int) (string, bool) {
 	}
 }
 
+func (ls *luaState) IsUserdata(idx int) bool {
+	tp := ls.Type(idx)
+	return tp == api.LUA_TUSERDATA || tp == api.LUA_TLIGHTUSERDATA
+}
+
+func (ls *luaState) ToUserdata(idx int) interface{} {
+	val := ls.stack.get(idx)
+	switch x := val.(type) {
+	case *userdata:
+		return x.data
+	case lightUserdata:
+		return x.p
+	}
+	return nil
+}
+
 func (ls *luaState) IsGoFunction(idx int) bool {
 	val := ls.stack.get(idx)
 	if c, ok := val.(*closure); ok {
@@ -1,6 +1,7 @@
 package state
 
 import (
+	"fmt"
 	"luago/api"
 	"luago/number"
 	"math"
@@ -53,8 +54,15 @@ var operators = []operator{
 }
 
 func (ls *luaState) Arith(op api.ArithOp) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	var a, b luaValue // operands
 
+	if op < api.LUA_OPADD || op > api.LUA_OPBNOT {
+		panic(fmt.Sprintf("invalid arithmetic operator %d", op))
+	}
+
 	b = ls.stack.pop()
 	if op != api.LUA_OPUNM && op != api.LUA_OPBNOT {
 		a = ls.stack.pop()
@@ -74,10 +82,26 @@ func (ls *luaState) Arith(op api.ArithOp) {
 		return
 	}
 
-	panic("arithmetic error!")
+	ls.runError("%s", ls.arithError(a, b, operator))
 }
 
-
+// 和 luaG_opinterror 一样, 报告第一个不是数字的操作数
+func (ls *luaState) arithError(a, b luaValue, op operator) string {
+	_, aNum := convertToFloat(a)
+	_, bNum := convertToFloat(b)
+	if op.floatFunc == nil && aNum && bNum { // bitwise
+		return "number has no integer representation"
+	}
+	culprit := a
+	if aNum {
+		culprit = b
+	}
+	opName := "perform arithmetic on"
+	if op.floatFunc == nil {
+		opName = "perform bitwise operation on"
+	}
+	return fmt.Sprintf("attempt to %s a %s value", opName, ls.TypeName(typeOf(culprit)))
+}
 
 func _arith(a, b luaValue, op operator) luaValue {
 	if op.floatFunc == nil { // bitwise
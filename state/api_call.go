@@ -1,20 +1,36 @@
 package state
 
 import (
+	"fmt"
+	"io"
 	"luago/api"
 	"luago/binchunk"
 	"luago/compiler"
 	"luago/vm"
+	"luago/vm/lua54"
+	"runtime"
+	"strings"
 )
 
+// mode 里没有 "b" 时拒绝二进制 chunk, 没有 "t" 时拒绝文本 chunk, 空串不限制
 func (ls *luaState) Load(chunk []byte, chunkName string, mode string) int {
-	var proto *binchunk.Prototype
 	if binchunk.IsBinaryChunk(chunk) {
-		proto = binchunk.Undump(chunk)
-	} else {
-		proto = compiler.Compile(string(chunk), chunkName)
+		if !checkMode(ls, mode, "binary") {
+			return api.LUA_ERRSYNTAX
+		}
+	} else if !checkMode(ls, mode, "text") {
+		return api.LUA_ERRSYNTAX
+	}
+	proto, err := loadProto(chunk, chunkName, ls.g.protoCache)
+	if err != nil { // 语法错误时把错误信息压栈, 不再直接 panic
+		ls.stack.push(err.Error())
+		return api.LUA_ERRSYNTAX
 	}
 
+	if !ls.g.tryAllocate(sizeClosure + int64(len(proto.Upvalues))*sizeUpvalue) {
+		ls.stack.push("not enough memory")
+		return api.LUA_ERRMEM
+	}
 	c := newLuaClosure(proto)
 	ls.stack.push(c)
 	if len(proto.Upvalues) > 0 {
@@ -24,7 +40,41 @@ func (ls *luaState) Load(chunk []byte, chunkName string, mode string) int {
 	return api.LUA_OK
 }
 
+// lua-5.3.6/src/ldo.c#checkmode()
+func checkMode(ls *luaState, mode, x string) bool {
+	if mode != "" && strings.IndexByte(mode, x[0]) < 0 {
+		ls.stack.push(fmt.Sprintf("attempt to load a %s chunk (mode is '%s')", x, mode))
+		return false
+	}
+	return true
+}
+
+func loadProto(chunk []byte, chunkName string, cache api.ProtoCache) (proto *binchunk.Prototype, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	if binchunk.IsBinaryChunk(chunk) {
+		proto = binchunk.Undump(chunk) // 根据头部的版本号选择 5.3 或 5.4 格式
+	} else if cache != nil {
+		proto = cache.Compile(string(chunk), chunkName)
+	} else {
+		proto = compiler.Compile(string(chunk), chunkName)
+	}
+	return
+}
+
+// 之后 Load 源码时先查缓存, 同一个缓存可以设置给多个状态; nil 表示不使用缓存
+func (ls *luaState) SetProtoCache(cache api.ProtoCache) {
+	ls.g.protoCache = cache
+}
+
 func (ls *luaState) Call(nArgs, nResults int) {
+	if ls.unprotected() {
+		defer ls.unprotectedCall(ls.stack, ls.stack.top-(nArgs+1), nResults)
+	}
+	ls.gcCheck()
 	val := ls.stack.get(-(nArgs + 1))
 
 	c, ok := val.(*closure)
@@ -45,8 +95,20 @@ func (ls *luaState) Call(nArgs, nResults int) {
 			ls.callGoClosure(nArgs, nResults, c)
 		}
 	} else {
-		panic("not function!")
+		ls.runError("attempt to call a %s value", ls.objTypeName(val))
+	}
+}
+
+// 把栈顶的 Lua 函数写成二进制 chunk, 不是 Lua 函数或写入失败时返回 1
+func (ls *luaState) Dump(w io.Writer, strip bool) int {
+	c, ok := ls.stack.get(-1).(*closure)
+	if !ok || c.proto == nil || c.proto.Version == binchunk.LUAC_VERSION_54 {
+		return 1
 	}
+	if _, err := w.Write(binchunk.Dump(c.proto, strip)); err != nil {
+		return 1
+	}
+	return 0
 }
 
 func (ls *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
@@ -86,7 +148,8 @@ func (ls *luaState) callGoClosure(nArgs, nResults int, c *closure) {
 	ls.stack.pop()
 
 	ls.pushLuaStack(newStack)
-	r := c.goFunc(ls)
+	r := ls.runGoFunction(c, newStack)
+	ls.closeTBCs(0, nil)
 	ls.popLuaStack()
 
 	if nResults != 0 {
@@ -96,7 +159,57 @@ func (ls *luaState) callGoClosure(nArgs, nResults int, c *closure) {
 	}
 }
 
+// 让出之后调用了延续函数, 用 panic 跳过 Go 函数剩下的部分
+type kReturn struct {
+	stack *luaStack
+	n     int
+}
+
+func (ls *luaState) runGoFunction(c *closure, stack *luaStack) (r int) {
+	defer func() {
+		if err := recover(); err != nil {
+			if kr, ok := err.(kReturn); ok && kr.stack == stack {
+				r = kr.n // 延续函数的返回值
+				return
+			}
+			panic(err)
+		}
+	}()
+	return c.goFunc(ls)
+}
+
+// 调用期间发生过让出时, 由延续函数 k 完成剩下的工作
+func (ls *luaState) CallK(nArgs, nResults int, ctx api.KContext, k api.KFunction) {
+	nYields := ls.nYields
+	ls.Call(nArgs, nResults)
+	if k != nil && ls.nYields != nYields {
+		ls.finishK(k, api.LUA_YIELD, ctx)
+	}
+}
+
+func (ls *luaState) PCallK(nArgs, nResults, msgh int, ctx api.KContext, k api.KFunction) int {
+	nYields := ls.nYields
+	status := ls.PCall(nArgs, nResults, msgh)
+	if k != nil && ls.nYields != nYields {
+		if status == api.LUA_OK {
+			status = api.LUA_YIELD
+		}
+		ls.finishK(k, status, ctx)
+	}
+	return status
+}
+
+func (ls *luaState) finishK(k api.KFunction, status int, ctx api.KContext) {
+	stack := ls.stack
+	n := k(ls, status, ctx)
+	panic(kReturn{stack, n})
+}
+
 func (ls *luaState) runLuaClosure() {
+	if ls.stack.closure.proto.Version == binchunk.LUAC_VERSION_54 {
+		ls.runLuaClosure54()
+		return
+	}
 	for {
 		inst := vm.Instruction(ls.Fetch())
 		inst.Execute(ls)
@@ -106,16 +219,48 @@ func (ls *luaState) runLuaClosure() {
 	}
 }
 
+// 5.4 的字节码用它自己的指令集执行
+func (ls *luaState) runLuaClosure54() {
+	for {
+		inst := lua54.Instruction(ls.Fetch())
+		inst.Execute(ls)
+		if inst.IsReturn() {
+			break
+		}
+	}
+}
+
 func (ls *luaState) PCall(nArgs, nResults, msgh int) (status int) {
 	caller := ls.stack
 	status = api.LUA_ERRRUN
+	var handler luaValue
+	if msgh != 0 {
+		handler = ls.stack.get(msgh)
+	}
 
+	ls.nPCalls++
 	defer func() {
+		ls.nPCalls--
 		if err := recover(); err != nil {
-			for ls.stack != caller {
-				ls.popLuaStack()
+			if _, ok := err.(memoryError); ok {
+				ls.stack.push(ls.unwind(caller, "not enough memory"))
+				status = api.LUA_ERRMEM // 不调用消息处理函数
+				return
 			}
-			ls.stack.push(err)
+			if _, ok := err.(threadKilled); ok {
+				// __close 出错时记下错误, 由 CloseThread 返回
+				ls.coErr = ls.unwind(caller, ls.coErr)
+				panic(err) // 协程被关闭, 不能被捕获
+			}
+			if _, ok := err.(interrupted); ok && caller.prev != nil {
+				panic(err) // 被打断时只有宿主直接调用的 PCall 返回错误, 外层的 PCall 会弹出栈帧
+			}
+			err = errorValue(err)
+			if handler != nil {
+				// 出错的栈帧还在, 消息处理函数可以查看调用栈
+				err, status = ls.callMsgHandler(handler, err)
+			}
+			ls.stack.push(ls.unwind(caller, err))
 		}
 	}()
 
@@ -123,3 +268,52 @@ func (ls *luaState) PCall(nArgs, nResults, msgh int) (status int) {
 	status = api.LUA_OK
 	return
 }
+
+func errorValue(err interface{}) luaValue {
+	if _, ok := err.(*runtime.PanicNilError); ok {
+		return nil // error(nil)
+	}
+	if e, ok := err.(interrupted); ok {
+		return e.err.Error()
+	}
+	return err
+}
+
+// 弹出 caller 之上的栈帧, 弹出前用错误对象关闭每一帧的 <close> 变量;
+// __close 本身出错时, 新的错误代替原来的错误, 继续关闭剩下的变量
+func (ls *luaState) unwind(caller *luaStack, err luaValue) luaValue {
+	for ls.stack != caller {
+		for len(ls.stack.tbcs) > 0 {
+			err = ls.closeTBCsProtected(err)
+		}
+		ls.popLuaStack()
+	}
+	return err
+}
+
+func (ls *luaState) closeTBCsProtected(err luaValue) (result luaValue) {
+	stack := ls.stack
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(threadKilled); ok {
+				panic(e)
+			}
+			result = ls.unwind(stack, errorValue(e))
+		}
+	}()
+	ls.closeTBCs(0, err)
+	return err
+}
+
+func (ls *luaState) callMsgHandler(handler, err luaValue) (result luaValue, status int) {
+	defer func() {
+		if recover() != nil {
+			result, status = "error in error handling", api.LUA_ERRERR
+		}
+	}()
+	ls.stack.check(2)
+	ls.stack.push(handler)
+	ls.stack.push(err)
+	ls.Call(1, 1)
+	return ls.stack.pop(), api.LUA_ERRRUN
+}
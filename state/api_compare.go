@@ -12,7 +12,14 @@ func (ls *luaState) RawEqual(idx1, idx2 int) bool {
 	return _eq(a, b, nil)
 }
 
+// 任何一个索引无效时返回 false
 func (ls *luaState) Compare(idx1, idx2 int, op api.CompareOp) bool {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
+	if !ls.stack.isValid(idx1) || !ls.stack.isValid(idx2) {
+		return false
+	}
 	a := ls.stack.get(idx1)
 	b := ls.stack.get(idx2)
 
@@ -63,6 +70,13 @@ func _eq(a, b luaValue, ls *luaState) bool {
 			}
 		}
 		return a == b
+	case *userdata: // 和表一样, 两个都是完全用户数据时才使用 __eq
+		if y, ok := b.(*userdata); ok && x != y && ls != nil {
+			if result, ok := callMetamethod(x, y, "__eq", ls); ok {
+				return convertToBoolean(result)
+			}
+		}
+		return a == b
 	default:
 		return a == b
 	}
@@ -92,7 +106,8 @@ func _lt(a, b luaValue, ls *luaState) bool {
 	if result, ok := callMetamethod(a, b, "__lt", ls); ok {
 		return convertToBoolean(result)
 	} else {
-		panic("comparison error!")
+		ls.orderError(a, b)
+		return false
 	}
 }
 
@@ -122,6 +137,16 @@ func _le(a, b luaValue, ls *luaState) bool {
 	} else if result, ok := callMetamethod(a, b, "__lt", ls); ok {
 		return !convertToBoolean(result)
 	} else {
-		panic("comparison error!")
+		ls.orderError(a, b)
+		return false
+	}
+}
+
+// 和 luaG_ordererror 一样
+func (ls *luaState) orderError(a, b luaValue) {
+	t1, t2 := ls.objTypeName(a), ls.objTypeName(b)
+	if t1 == t2 {
+		ls.runError("attempt to compare two %s values", t1)
 	}
+	ls.runError("attempt to compare %s with %s", t1, t2)
 }
@@ -0,0 +1,53 @@
+package state
+
+import "luago/api"
+
+func (ls *luaState) Version() int {
+	return ls.g.version
+}
+
+// 5.1 的函数环境对应 Lua 函数的 _ENV 提升值, 没有 _ENV 的函数和 Go 函数使用全局表
+func (ls *luaState) GetFenv(idx int) {
+	switch x := ls.stack.get(idx).(type) {
+	case *closure:
+		if i := envIndex(x); i >= 0 {
+			ls.stack.push(*x.upvals[i].val)
+			return
+		}
+		ls.stack.push(ls.registry.get(api.LUA_RIDX_GLOBALS))
+	case *luaState:
+		ls.stack.push(ls.registry.get(api.LUA_RIDX_GLOBALS))
+	default:
+		ls.stack.push(nil)
+	}
+}
+
+// 不能修改 Go 函数的环境; 线程的环境就是全局表, 所有线程和之后加载的函数共用, 也不能修改
+func (ls *luaState) SetFenv(idx int) bool {
+	val := ls.stack.get(idx)
+	env := ls.stack.pop()
+	switch x := val.(type) {
+	case *closure:
+		if x.proto == nil {
+			return false
+		}
+		if i := envIndex(x); i >= 0 {
+			// 换成新的提升值, 共享原来 _ENV 的其他函数不受影响
+			x.upvals[i] = &upvalue{&env}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func envIndex(c *closure) int {
+	if c.proto != nil {
+		for i, name := range c.proto.UpvalueNames {
+			if name == "_ENV" && c.upvals[i] != nil {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -2,25 +2,60 @@ package state
 
 import "luago/api"
 
+// 关闭挂起的协程时, 在 Yield 中抛出, 一直展开到协程的 goroutine
+type threadKilled struct{}
+
 func (ls *luaState) NewThread() api.LuaState {
-	t := &luaState{registry: ls.registry}
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
+	t := &luaState{registry: ls.registry, g: ls.g}
 	t.pushLuaStack(newLuaStack(api.LUAI_MAXSTACK, t))
+	ls.g.allocate(sizeThread + api.LUAI_MAXSTACK*sizeTValue)
 	ls.stack.push(t)
 	return t
 }
 
 func (ls *luaState) Resume(from api.LuaState, nArgs int) int {
 	lsFrom := from.(*luaState)
+	if ls.coStatus == api.LUA_OK { /* may be starting a coroutine */
+		if ls.stack.prev != nil { /* not in base level? */
+			return ls.resumeError("cannot resume non-suspended coroutine", nArgs)
+		}
+	} else if ls.coStatus != api.LUA_YIELD {
+		return ls.resumeError("cannot resume dead coroutine", nArgs)
+	}
+
 	if lsFrom.coChan == nil {
 		lsFrom.coChan = make(chan int)
 	}
+	ls.coCaller = lsFrom
 
 	if ls.coChan == nil {
 		ls.coChan = make(chan int)
-		ls.coCaller = lsFrom
+		if ls.g.coroutines == nil {
+			ls.g.coroutines = map[*luaState]bool{}
+		}
+		ls.g.coroutines[ls] = true
 		go func() {
+			defer func() {
+				if err := recover(); err != nil {
+					if _, ok := err.(threadKilled); !ok {
+						panic(err)
+					}
+				}
+				delete(ls.g.coroutines, ls)
+				ls.coCaller.coChan <- 1
+			}()
+			if ls.restored != nil { /* 从快照恢复的协程 */
+				ls.stack.push(newGoClosure(resumeRestored, 0))
+				ls.Insert(-(nArgs + 1))
+			}
 			ls.coStatus = ls.PCall(nArgs, -1, 0)
-			lsFrom.coChan <- 1
+			if ls.coStatus != api.LUA_OK {
+				ls.coErr = ls.stack.get(-1)
+			}
+			ls.coChan = nil
 		}()
 	} else {
 		ls.coStatus = api.LUA_OK
@@ -31,11 +66,89 @@ func (ls *luaState) Resume(from api.LuaState, nArgs int) int {
 	return ls.coStatus
 }
 
+func (ls *luaState) resumeError(msg string, nArgs int) int {
+	ls.stack.popN(nArgs)
+	ls.stack.push(msg)
+	return api.LUA_ERRRUN
+}
+
+// 只让出栈顶的 nResults 个值, 恢复时下面的值保持不变, 上面换成 Resume 传入的参数
 func (ls *luaState) Yield(nResults int) int {
+	if ls.isMainThread() {
+		panic("attempt to yield from outside a coroutine")
+	}
+	stack := ls.stack
+	results := stack.popN(nResults)
+	rest := stack.popN(stack.top)
+	stack.pushN(results, nResults)
+
+	ls.nYields++
 	ls.coStatus = api.LUA_YIELD
 	ls.coCaller.coChan <- 1
 	<-ls.coChan
-	return ls.GetTop()
+	if ls.coKilled {
+		panic(threadKilled{})
+	}
+
+	args := stack.popN(stack.top)
+	stack.check(len(rest) + len(args))
+	stack.pushN(rest, -1)
+	stack.pushN(args, -1)
+	return len(args)
+}
+
+// 恢复之后调用延续函数 k, 它的返回值就是让出的 Go 函数的返回值
+func (ls *luaState) YieldK(nResults int, ctx api.KContext, k api.KFunction) int {
+	n := ls.Yield(nResults)
+	if k == nil {
+		return n
+	}
+	ls.finishK(k, api.LUA_YIELD, ctx)
+	return 0 // 不会执行到这里
+}
+
+// 关闭挂起或已经结束的协程, 出错时错误对象留在栈顶
+func (ls *luaState) CloseThread(from api.LuaState) int {
+	status := ls.coStatus
+	if status == api.LUA_YIELD && ls.restored != nil { /* 还没有 goroutine */
+		base := ls.stack
+		for _, frame := range ls.restored {
+			ls.pushLuaStack(frame)
+		}
+		ls.restored = nil
+		ls.coErr = ls.unwind(base, nil)
+		status = api.LUA_OK
+		if ls.coErr != nil { /* __close 出错 */
+			status = api.LUA_ERRRUN
+		}
+	} else if status == api.LUA_YIELD {
+		lsFrom := from.(*luaState)
+		if lsFrom.coChan == nil {
+			lsFrom.coChan = make(chan int)
+		}
+		ls.coCaller = lsFrom
+		ls.coKilled = true
+		ls.coChan <- 1
+		<-lsFrom.coChan
+		ls.coKilled = false
+		status = api.LUA_OK
+		if ls.coErr != nil { /* __close 出错 */
+			status = api.LUA_ERRRUN
+		}
+	}
+
+	for ls.stack.prev != nil {
+		ls.popLuaStack()
+	}
+	ls.SetTop(0)
+	if status != api.LUA_OK { /* 保留原来的错误 */
+		ls.stack.push(ls.coErr)
+	}
+	ls.coStatus = api.LUA_OK
+	ls.coChan = nil
+	ls.coCaller = nil
+	ls.coErr = nil
+	return status
 }
 
 func (ls *luaState) Status() int {
@@ -51,4 +164,4 @@ func (ls *luaState) IsYieldable() bool {
 		return false
 	}
 	return ls.coStatus != api.LUA_YIELD // todo
-}
\ No newline at end of file
+}
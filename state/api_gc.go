@@ -0,0 +1,79 @@
+package state
+
+import "luago/api"
+
+func (ls *luaState) GC(what int, args ...int) int {
+	g := ls.g
+	arg := func(i int) int {
+		if i < len(args) {
+			return args[i]
+		}
+		return 0
+	}
+
+	switch what {
+	case api.LUA_GCSTOP:
+		g.gcStopped = true
+	case api.LUA_GCRESTART:
+		g.gcStopped = false
+	case api.LUA_GCCOLLECT:
+		ls.fullGC() // 不调用 runtime.GC, 它会停下整个进程, 影响池里的其他状态
+	case api.LUA_GCCOUNT:
+		return int(g.totalBytes() >> 10)
+	case api.LUA_GCCOUNTB:
+		return int(g.totalBytes() & 0x3ff)
+	case api.LUA_GCSTEP:
+		// 没有增量回收, 欠债足够时做一次完整的回收
+		data := int64(arg(0))
+		g.debt += data << 10
+		if data == 0 || g.debt >= g.threshold() {
+			ls.fullGC()
+			return 1 // 完成了一个周期
+		}
+	case api.LUA_GCSETPAUSE:
+		old := g.gcPause
+		g.gcPause = arg(0)
+		return old
+	case api.LUA_GCSETSTEPMUL:
+		old := g.gcStepMul
+		g.gcStepMul = arg(0)
+		return old
+	case api.LUA_GCISRUNNING:
+		if !g.gcStopped {
+			return 1
+		}
+	case api.LUA_GCGEN:
+		old := g.gcMode
+		if minorMul := arg(0); minorMul != 0 {
+			g.gcMinorMul = minorMul
+		}
+		if majorMul := arg(1); majorMul != 0 {
+			g.gcMajorMul = majorMul
+		}
+		g.gcMode = api.LUA_GCGEN
+		return old
+	case api.LUA_GCINC:
+		old := g.gcMode
+		if pause := arg(0); pause != 0 {
+			g.gcPause = pause
+		}
+		if stepMul := arg(1); stepMul != 0 {
+			g.gcStepMul = stepMul
+		}
+		if stepSize := arg(2); stepSize != 0 {
+			g.gcStepSize = stepSize
+		}
+		g.gcMode = api.LUA_GCINC
+		return old
+	}
+	return 0
+}
+
+func (ls *luaState) GetAllocF() (api.Alloc, interface{}) {
+	return ls.g.allocf, ls.g.allocud
+}
+
+// f 为 nil 时不限制分配
+func (ls *luaState) SetAllocF(f api.Alloc, ud interface{}) {
+	ls.g.allocf, ls.g.allocud = f, ud
+}
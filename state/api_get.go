@@ -3,7 +3,11 @@ package state
 import "luago/api"
 
 func (ls *luaState) CreateTable(nArr, nRec int) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	t := newLuaTable(nArr, nRec)
+	ls.g.allocate(tableSize(t))
 	ls.stack.push(t)
 }
 
@@ -12,49 +16,73 @@ func (ls *luaState) NewTable() {
 }
 
 func (ls *luaState) GetTable(idx int) api.LuaType {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	t := ls.stack.get(idx)
 	k := ls.stack.pop()
 	return ls.getTable(t, k, false)
 }
 
+// 最多沿着 __index 或 __newindex 查找这么多次, 超过时认为有环
+const maxTagLoop = 2000
+
 func (ls *luaState) getTable(t, k luaValue, raw bool) api.LuaType {
-	if tbl, ok := t.(*luaTable); ok {
-		v := tbl.get(k)
-		if raw || v != nil || !tbl.hasMetafield("__index") {
-			ls.stack.push(v)
-			return typeOf(v)
+	if raw {
+		tbl, ok := t.(*luaTable)
+		if !ok {
+			panic("table expected!")
 		}
+		v := tbl.get(k)
+		ls.stack.push(v)
+		return typeOf(v)
 	}
-	if !raw {
-		if mf := getMetafield(t, "__index", ls); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				return ls.getTable(x, k, false)
-			case *closure:
-				ls.stack.push(mf)
-				ls.stack.push(t)
-				ls.stack.push(k)
-				ls.Call(2, 1)
-				v := ls.stack.get(-1)
+	for loop := 0; loop < maxTagLoop; loop++ {
+		if tbl, ok := t.(*luaTable); ok {
+			v := tbl.get(k)
+			if v != nil || !tbl.hasMetafield("__index") {
+				ls.stack.push(v)
 				return typeOf(v)
 			}
 		}
+		mf := getMetafield(t, "__index", ls)
+		if mf == nil {
+			ls.runError("attempt to index a %s value", ls.objTypeName(t))
+		}
+		if _, ok := mf.(*closure); ok {
+			ls.stack.push(mf)
+			ls.stack.push(t)
+			ls.stack.push(k)
+			ls.Call(2, 1)
+			v := ls.stack.get(-1)
+			return typeOf(v)
+		}
+		t = mf // 在 __index 上重复查找
 	}
-
-	panic("index error!")
+	ls.runError("'__index' chain too long; possible loop")
+	return api.LUA_TNONE
 }
 
 func (ls *luaState) GetField(idx int, k string) api.LuaType {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	t := ls.stack.get(idx)
 	return ls.getTable(t, k, false)
 }
 
 func (ls *luaState) GetI(idx int, i int64) api.LuaType {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	t := ls.stack.get(idx)
 	return ls.getTable(t, i, false)
 }
 
 func (ls *luaState) GetGlobal(name string) api.LuaType {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	t := ls.registry.get(api.LUA_RIDX_GLOBALS)
 	return ls.getTable(t, name, false)
 }
@@ -71,12 +99,38 @@ func (ls *luaState) GetMetatable(idx int) bool {
 }
 
 func (ls *luaState) RawGet(idx int) api.LuaType {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	t := ls.stack.get(idx)
 	k := ls.stack.pop()
 	return ls.getTable(t, k, true)
 }
 
 func (ls *luaState) RawGetI(idx int, i int64) api.LuaType {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	t := ls.stack.get(idx)
 	return ls.getTable(t, i, true)
-}
\ No newline at end of file
+}
+func (ls *luaState) RawGetP(idx int, p interface{}) api.LuaType {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
+	t := ls.stack.get(idx)
+	return ls.getTable(t, newLightUserdata(p), true)
+}
+
+// 把 idx 处完全用户数据的关联值推入栈顶
+func (ls *luaState) GetUserValue(idx int) api.LuaType {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
+	u, ok := ls.stack.get(idx).(*userdata)
+	if !ok {
+		panic("full userdata expected!")
+	}
+	ls.stack.push(u.uservalue)
+	return typeOf(u.uservalue)
+}
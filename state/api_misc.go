@@ -1,8 +1,14 @@
 package state
 
-import "luago/number"
+import (
+	"luago/api"
+	"luago/number"
+)
 
 func (ls *luaState) Len(idx int) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	val := ls.stack.get(idx)
 	if s, ok := val.(string); ok {
 		ls.stack.push(int64(len(s)))
@@ -11,11 +17,14 @@ func (ls *luaState) Len(idx int) {
 	} else if t, ok := val.(*luaTable); ok {
 		ls.stack.push(int64(t.len()))
 	} else {
-		panic("length error!")
+		ls.runError("attempt to get length of a %s value", ls.objTypeName(val))
 	}
 }
 
 func (ls *luaState) Concat(n int) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	if n == 0 {
 		ls.stack.push("")
 	} else if n >= 2 {
@@ -25,6 +34,7 @@ func (ls *luaState) Concat(n int) {
 				s1 := ls.ToString(-2)
 				ls.stack.pop()
 				ls.stack.pop()
+				ls.g.allocate(sizeString + int64(len(s1)+len(s2)))
 				ls.stack.push(s1 + s2)
 				continue
 			}
@@ -34,7 +44,10 @@ func (ls *luaState) Concat(n int) {
 				ls.stack.push(result)
 				continue
 			}
-			panic("concatenation error!")
+			if _, ok := convertToFloat(a); ok || typeOf(a) == api.LUA_TSTRING {
+				a = b // 报告第一个不能连接的操作数
+			}
+			ls.runError("attempt to concatenate a %s value", ls.objTypeName(a))
 		}
 	}
 
@@ -42,6 +55,9 @@ func (ls *luaState) Concat(n int) {
 }
 
 func (ls *luaState) Next(idx int) bool {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	val := ls.stack.get(idx)
 	if t, ok := val.(*luaTable); ok {
 		key := ls.stack.pop()
@@ -56,18 +72,22 @@ func (ls *luaState) Next(idx int) bool {
 }
 
 func (ls *luaState) Error() int {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	err := ls.stack.pop()
 	panic(err)
 }
 
-func (ls *luaState) StringToNumber(s string) bool {
+// 转换成功时把数字推入栈顶, 返回 len(s)+1 (和 C 一样算上结尾的 '\0'), 否则返回 0
+func (ls *luaState) StringToNumber(s string) int {
 	if n, ok := number.ParseInteger(s); ok {
 		ls.PushInteger(n)
-		return true
+		return len(s) + 1
 	}
 	if n, ok := number.ParseFloat(s); ok {
 		ls.PushNumber(n)
-		return true
+		return len(s) + 1
 	}
-	return false
+	return 0
 }
\ No newline at end of file
@@ -3,6 +3,7 @@ package state
 import (
 	"fmt"
 	"luago/api"
+	"reflect"
 )
 
 func (ls *luaState) PushNil() {
@@ -26,6 +27,10 @@ func (ls *luaState) PushString(s string) {
 }
 
 func (ls *luaState) PushGoFunction(f api.GoFunction) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
+	ls.g.allocate(sizeClosure)
 	ls.stack.push(newGoClosure(f, 0))
 }
 
@@ -35,10 +40,14 @@ func (ls *luaState) PushGlobalTable() {
 }
 
 func (ls *luaState) PushGoClosure(f api.GoFunction, n int) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	closure := newGoClosure(f, n)
+	ls.g.allocate(sizeClosure + int64(n)*sizeUpvalue)
 	for i := n; i > 0; i-- {
 		val := ls.stack.pop()
-		closure.upvals[n-1] = &upvalue{&val}
+		closure.upvals[i-1] = &upvalue{&val}
 	}
 	ls.stack.push(closure)
 }
@@ -48,6 +57,29 @@ func (ls *luaState) PushFString(fmtStr string, a ...interface{}) {
 	ls.stack.push(str)
 }
 
+func (ls *luaState) NewUserdata(data interface{}) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
+	ls.g.allocate(sizeUserdata)
+	ls.stack.push(newUserdata(data))
+}
+
+func (ls *luaState) PushLightUserdata(p interface{}) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
+	ls.stack.push(newLightUserdata(p))
+}
+
+// 轻量用户数据可以作为表的键, 所以 p 必须可以比较
+func newLightUserdata(p interface{}) lightUserdata {
+	if p != nil && !reflect.TypeOf(p).Comparable() {
+		panic(fmt.Sprintf("light userdata must be comparable, got %T", p))
+	}
+	return lightUserdata{p}
+}
+
 func (ls *luaState) PushThread() bool {
 	ls.stack.push(ls)
 	return ls.isMainThread()
@@ -1,8 +1,14 @@
 package state
 
-import "luago/api"
+import (
+	"luago/api"
+	"math"
+)
 
 func (ls *luaState) SetTable(idx int) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	t := ls.stack.get(idx)
 	v := ls.stack.pop()
 	k := ls.stack.pop()
@@ -10,45 +16,70 @@ func (ls *luaState) SetTable(idx int) {
 }
 
 func (ls *luaState) setTable(t, k, v luaValue, raw bool) {
-	if tbl, ok := t.(*luaTable); ok {
-		if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
-			tbl.put(k, v)
-			return
+	if raw {
+		tbl, ok := t.(*luaTable)
+		if !ok {
+			panic("table expected!")
 		}
+		ls.tablePut(tbl, k, v)
+		return
 	}
-
-	if !raw {
-		if mf := getMetafield(t, "__newindex", ls); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				ls.setTable(x, k, v, false)
-				return
-			case *closure:
-				ls.stack.push(mf)
-				ls.stack.push(t)
-				ls.stack.push(k)
-				ls.stack.push(v)
-				ls.Call(3, 0)
+	for loop := 0; loop < maxTagLoop; loop++ {
+		if tbl, ok := t.(*luaTable); ok {
+			if tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
+				ls.tablePut(tbl, k, v)
 				return
 			}
 		}
+		mf := getMetafield(t, "__newindex", ls)
+		if mf == nil {
+			ls.runError("attempt to index a %s value", ls.objTypeName(t))
+		}
+		if _, ok := mf.(*closure); ok {
+			ls.stack.push(mf)
+			ls.stack.push(t)
+			ls.stack.push(k)
+			ls.stack.push(v)
+			ls.Call(3, 0)
+			return
+		}
+		t = mf // 在 __newindex 上重复赋值
 	}
-	panic("index error!")
+	ls.runError("'__newindex' chain too long; possible loop")
+}
+
+// 键无效时报告出错的位置
+func (ls *luaState) tablePut(tbl *luaTable, k, v luaValue) {
+	if k == nil {
+		ls.runError("table index is nil")
+	} else if f, ok := k.(float64); ok && math.IsNaN(f) {
+		ls.runError("table index is NaN")
+	}
+	tbl.put(k, v)
 }
 
 func (ls *luaState) SetField(idx int, k string) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	t := ls.stack.get(idx)
 	v := ls.stack.pop()
 	ls.setTable(t, k, v, false)
 }
 
 func (ls *luaState) SetI(idx int, i int64) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	t := ls.stack.get(idx)
 	v := ls.stack.pop()
 	ls.setTable(t, i, v, false)
 }
 
 func (ls *luaState) SetGlobal(name string) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	t := ls.registry.get(api.LUA_RIDX_GLOBALS)
 	v := ls.stack.pop()
 	ls.setTable(t, name, v, false)
@@ -60,6 +91,9 @@ func (ls *luaState) Register(name string, f api.GoFunction) {
 }
 
 func (ls *luaState) SetMetatable(idx int) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	val := ls.stack.get(idx)
 	mtVal := ls.stack.pop()
 
@@ -73,6 +107,9 @@ func (ls *luaState) SetMetatable(idx int) {
 }
 
 func (ls *luaState) RawSet(idx int) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	t := ls.stack.get(idx)
 	v := ls.stack.pop()
 	k := ls.stack.pop()
@@ -80,7 +117,31 @@ func (ls *luaState) RawSet(idx int) {
 }
 
 func (ls *luaState) RawSetI(idx int, i int64) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
 	t := ls.stack.get(idx)
 	v := ls.stack.pop()
 	ls.setTable(t, i, v, true)
 }
+
+func (ls *luaState) RawSetP(idx int, p interface{}) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
+	t := ls.stack.get(idx)
+	v := ls.stack.pop()
+	ls.setTable(t, newLightUserdata(p), v, true)
+}
+
+// 弹出栈顶的值, 作为 idx 处完全用户数据的关联值
+func (ls *luaState) SetUserValue(idx int) {
+	if ls.unprotected() {
+		defer ls.unprotectedError(ls.stack)
+	}
+	u, ok := ls.stack.get(idx).(*userdata)
+	if !ok {
+		panic("full userdata expected!")
+	}
+	u.uservalue = ls.stack.pop()
+}
@@ -79,3 +79,23 @@ func (ls *luaState) XMove(to api.LuaState, n int) {
 	vals := ls.stack.popN(n)
 	to.(*luaState).stack.pushN(vals, n)
 }
+
+// nil 和 false 不需要关闭
+func (ls *luaState) ToClose(idx int) {
+	stack := ls.stack
+	slot := stack.absIndex(idx) - 1
+	val := stack.slots[slot]
+	if val == nil || val == false {
+		return
+	}
+	if getMetafield(val, "__close", ls) == nil {
+		ls.runError("variable '%s' got a non-closable value", stack.localName(slot))
+	}
+	stack.tbcs = append(stack.tbcs, slot)
+}
+
+func (ls *luaState) CloseSlot(idx int) {
+	slot := ls.stack.absIndex(idx) - 1
+	ls.closeTBCs(slot, nil)
+	ls.stack.slots[slot] = nil
+}
@@ -8,7 +8,15 @@ func (ls *luaState) AddPC(n int) {
 	ls.stack.pc += n
 }
 
+// Interrupt 设置的错误, pcall 不能捕获它, 只有宿主直接调用的 PCall 把它当作普通错误返回
+type interrupted struct {
+	err error
+}
+
 func (ls *luaState) Fetch() uint32 {
+	if err := ls.g.interrupt.Load(); err != nil {
+		panic(interrupted{*err}) // 不清除, 之后的每条指令都会抛出, 直到宿主取消
+	}
 	i := ls.stack.closure.proto.Code[ls.stack.pc]
 	ls.stack.pc++
 	return i
@@ -44,6 +52,7 @@ func (ls *luaState) LoadProto(idx int) {
 	stack := ls.stack
 	subProto := stack.closure.proto.Protos[idx]
 	closure := newLuaClosure(subProto)
+	ls.g.allocate(sizeClosure + int64(len(subProto.Upvalues))*sizeUpvalue)
 	ls.stack.push(closure)
 
 	for i, uvInfo := range subProto.Upvalues {
@@ -64,6 +73,7 @@ func (ls *luaState) LoadProto(idx int) {
 	}
 }
 
+// 同时关闭 R(A-1) 及以上的 <close> 变量
 func (ls *luaState) CloseUpvalues(a int) {
 	for i, openuv := range ls.stack.openuvs {
 		if i >= a-1 {
@@ -72,4 +82,31 @@ func (ls *luaState) CloseUpvalues(a int) {
 			delete(ls.stack.openuvs, i)
 		}
 	}
+	ls.closeTBCs(a-1, nil)
+}
+
+// 按声明的相反顺序调用 __close(v, err), 先出栈再调用, 出错时剩下的由 unwind 关闭
+func (ls *luaState) closeTBCs(from int, err luaValue) {
+	stack := ls.stack
+	for n := len(stack.tbcs); n > 0 && stack.tbcs[n-1] >= from; n = len(stack.tbcs) {
+		val := stack.slots[stack.tbcs[n-1]]
+		stack.tbcs = stack.tbcs[:n-1]
+		if mm := getMetafield(val, "__close", ls); mm != nil {
+			stack.check(3)
+			stack.push(mm)
+			stack.push(val)
+			stack.push(err)
+			ls.Call(2, 0)
+		}
+	}
+}
+
+// 可以在其他 goroutine 里调用: 让正在执行的 Lua 代码在下一条指令抛出 err, nil 表示取消.
+// 错误一直有效, 宿主的调用返回之后要用 Interrupt(nil) 取消, 否则状态不能再执行 Lua 代码
+func (ls *luaState) Interrupt(err error) {
+	if err == nil {
+		ls.g.interrupt.Store(nil)
+	} else {
+		ls.g.interrupt.Store(&err)
+	}
 }
@@ -4,7 +4,8 @@ import (
 	"fmt"
 	"luago/api"
 	"luago/stdlib"
-	"os"
+	"sort"
+	"strings"
 )
 
 func (ls *luaState) TypeName2(idx int) string {
@@ -32,19 +33,131 @@ func (ls *luaState) CheckStack2(sz int, msg string) {
 }
 
 func (ls *luaState) Error2(fmt string, a ...interface{}) int {
+	ls.Where(1)
 	ls.PushFString(fmt, a...)
+	ls.Concat(2)
 	return ls.Error()
 }
 
+func (ls *luaState) Where(level int) {
+	if stack := ls.getFrame(level); stack != nil { /* check function at level */
+		if line := currentLine(stack); line > 0 { /* is there info? */
+			ls.PushFString("%s:%d: ", chunkID(stack.closure.proto.Source), line)
+			return
+		}
+	}
+	ls.PushString("") /* else, no information available... */
+}
+
+/* size of the first part of the stack */
+const levels1 = 10
+
+/* size of the second part of the stack */
+const levels2 = 11
+
+func (ls *luaState) Traceback(l1 api.LuaState, msg string, level int) {
+	ls.PushString(l1.(*luaState).traceback(msg, level))
+}
+
+func (ls *luaState) traceback(msg string, level int) string {
+	var b strings.Builder
+	if msg != "" {
+		b.WriteString(msg)
+		b.WriteByte('\n')
+	}
+	b.WriteString("stack traceback:")
+	last := level
+	for ls.getFrame(last) != nil {
+		last++
+	}
+	n1 := -1 /* show all levels */
+	if last-level > levels1+levels2 {
+		n1 = levels1 /* show only the first and the last levels */
+	}
+	for stack := ls.getFrame(level); stack != nil; stack = ls.getFrame(level) {
+		if n1 == 0 { /* too many levels? */
+			b.WriteString("\n\t...") /* add a '...' */
+			level = last - levels2     /* and skip to last ones */
+			n1--
+			continue
+		}
+		n1--
+		level++
+		proto := stack.closure.proto
+		if proto == nil {
+			b.WriteString("\n\t[C]: in ")
+		} else if line := currentLine(stack); line > 0 {
+			fmt.Fprintf(&b, "\n\t%s:%d: in ", chunkID(proto.Source), line)
+		} else {
+			fmt.Fprintf(&b, "\n\t%s: in ", chunkID(proto.Source))
+		}
+		b.WriteString(ls.funcName(stack.closure))
+	}
+	return b.String()
+}
+
+// 和 pushfuncname 一样; 没有调用点的名字信息, 只查找已加载的模块
+func (ls *luaState) funcName(c *closure) string {
+	if name := ls.globalFuncName(c); name != "" {
+		return "function '" + name + "'"
+	}
+	if c.proto == nil {
+		return "?"
+	}
+	if c.proto.LineDefined == 0 {
+		return "main chunk"
+	}
+	return fmt.Sprintf("function <%s:%d>", chunkID(c.proto.Source), c.proto.LineDefined)
+}
+
+/*
+** Search for a module field holding the function, like 'pushglobalfuncname'.
+** Global functions are reported without the '_G.' prefix.
+ */
+func (ls *luaState) globalFuncName(c *closure) string {
+	loaded, ok := ls.registry.get(stdlib.LUA_LOADED_TABLE).(*luaTable)
+	if !ok {
+		return ""
+	}
+	var mods []string
+	for k, v := range loaded._map {
+		if name, ok := k.(string); ok && name != "_G" {
+			if _, ok := v.(*luaTable); ok {
+				mods = append(mods, name)
+			}
+		}
+	}
+	sort.Strings(mods)
+	mods = append([]string{"_G"}, mods...) /* globals first */
+	for _, mod := range mods {
+		t, ok := loaded.get(mod).(*luaTable)
+		if !ok {
+			continue
+		}
+		for k, v := range t._map {
+			if name, ok := k.(string); ok && v == c {
+				if mod == "_G" {
+					return name
+				}
+				return mod + "." + name
+			}
+		}
+	}
+	return ""
+}
+
 func (ls *luaState) LoadString(s string) int {
 	return ls.Load([]byte(s), s, "bt")
 }
 
+// 按挂在状态上的文件系统的顺序查找, 见 stdlib.SetFileSystems
 func (ls *luaState) LoadFileX(filename, mode string) int {
-	if data, err := os.ReadFile(filename); err == nil {
-		return ls.Load(data, "@"+filename, mode)
+	data, err := stdlib.ReadFile(ls, filename)
+	if err != nil {
+		ls.PushFString("cannot open %s", filename)
+		return api.LUA_ERRFILE
 	}
-	return api.LUA_ERRFILE
+	return ls.Load(data, "@"+filename, mode)
 }
 
 func (ls *luaState) LoadFile(filename string) int {
@@ -139,6 +252,7 @@ func (ls *luaState) OpenLibs() {
 		"string": stdlib.OpenStringLib,
 		"utf8": stdlib.OpenUTF8Lib,
 		"os": stdlib.OpenOSLib,
+		"io": stdlib.OpenIOLib,
 		"package": stdlib.OpenPackageLib,
 		"coroutine": stdlib.OpenCoroutineLib,
 	}
@@ -210,6 +324,21 @@ func (ls *luaState) OptString(arg int, def string) string {
 	return ls.CheckString(arg)
 }
 
+func (ls *luaState) CheckOption(arg int, def string, lst []string) int {
+	var name string
+	if def != "" {
+		name = ls.OptString(arg, def)
+	} else {
+		name = ls.CheckString(arg)
+	}
+	for i, s := range lst {
+		if s == name {
+			return i
+		}
+	}
+	return ls.ArgError(arg, fmt.Sprintf("invalid option '%s'", name))
+}
+
 func (ls *luaState) ToString2(idx int) string {
 	if ls.CallMeta(idx, "__tostring") { /* metafield? */
 		if !ls.IsString(-1) {
@@ -218,11 +347,7 @@ func (ls *luaState) ToString2(idx int) string {
 	} else {
 		switch ls.Type(idx) {
 		case api.LUA_TNUMBER:
-			if ls.IsInteger(idx) {
-				ls.PushString(fmt.Sprintf("%d", ls.ToInteger(idx))) // todo
-			} else {
-				ls.PushString(fmt.Sprintf("%g", ls.ToNumber(idx))) // todo
-			}
+			ls.PushString(ls.numberToString(ls.stack.get(idx)))
 		case api.LUA_TSTRING:
 			ls.PushValue(idx)
 		case api.LUA_TBOOLEAN:
@@ -262,6 +387,46 @@ func (ls *luaState) CallMeta(obj int, event string) bool {
 	return true
 }
 
+func (ls *luaState) NewMetatable(tname string) bool {
+	if ls.GetField(api.LUA_REGISTRYINDEX, tname) != api.LUA_TNIL {
+		return false /* leave previous value on top, but return false */
+	}
+	ls.Pop(1)
+	ls.CreateTable(0, 2) /* create metatable */
+	ls.PushString(tname)
+	ls.SetField(-2, "__name") /* metatable.__name = tname */
+	ls.PushValue(-1)
+	ls.SetField(api.LUA_REGISTRYINDEX, tname) /* registry.name = metatable */
+	return true
+}
+
+func (ls *luaState) TestUdata(arg int, tname string) interface{} {
+	if !ls.isUdata(arg, tname) {
+		return nil
+	}
+	return ls.ToUserdata(arg)
+}
+
+func (ls *luaState) CheckUdata(arg int, tname string) interface{} {
+	if !ls.isUdata(arg, tname) {
+		ls.typeError(arg, tname)
+	}
+	return ls.ToUserdata(arg)
+}
+
+func (ls *luaState) isUdata(arg int, tname string) bool {
+	if !ls.IsUserdata(arg) { /* value is not a userdata? */
+		return false
+	}
+	if !ls.GetMetatable(arg) { /* does it have a metatable? */
+		return false
+	}
+	ls.GetField(api.LUA_REGISTRYINDEX, tname) /* get correct metatable */
+	ok := ls.RawEqual(-1, -2)                 /* the same? */
+	ls.Pop(2)                                 /* remove both metatables */
+	return ok
+}
+
 func (ls *luaState) NewLib(l api.FuncReg) {
 	ls.NewLibTable(l)
 	ls.SetFuncs(l, 0)
@@ -278,12 +443,55 @@ func (ls *luaState) SetFuncs(l api.FuncReg, nup int) {
 			ls.PushValue(-nup)
 		}
 		// r[-(nup+2)][name]=fun
-		ls.PushGoClosure(fun, nup) /* closure with those upvalues */
+		if fun == nil { /* place holder? */
+			ls.Pop(nup)
+			ls.PushBoolean(false)
+		} else {
+			ls.PushGoClosure(fun, nup) /* closure with those upvalues */
+		}
 		ls.SetField(-(nup + 2), name)
 	}
 	ls.Pop(nup) /* remove upvalues */
 }
 
+/* index of free-list header */
+const freelist = 0
+
+/*
+** Creates and returns a reference in the table at index t for the
+** value at the top of the stack (and pops the value). Free references
+** are kept in a linked list starting at t[freelist].
+ */
+func (ls *luaState) Ref(t int) int {
+	if ls.IsNil(-1) {
+		ls.Pop(1)             /* remove it from stack */
+		return api.LUA_REFNIL /* 'nil' has a unique fixed reference */
+	}
+	t = ls.AbsIndex(t)
+	ls.RawGetI(t, freelist)      /* get first free element */
+	ref := int(ls.ToInteger(-1)) /* ref = t[freelist] */
+	ls.Pop(1)                    /* remove it from stack */
+	if ref != 0 {                /* any free element? */
+		ls.RawGetI(t, int64(ref)) /* remove it from list */
+		ls.RawSetI(t, freelist)   /* (t[freelist] = t[ref]) */
+	} else { /* no free elements */
+		ref = int(ls.RawLen(t)) + 1 /* get a new reference */
+	}
+	ls.RawSetI(t, int64(ref))
+	return ref
+}
+
+/* Releases reference ref from the table at index t. */
+func (ls *luaState) Unref(t, ref int) {
+	if ref >= 0 {
+		t = ls.AbsIndex(t)
+		ls.RawGetI(t, freelist)
+		ls.RawSetI(t, int64(ref)) /* t[ref] = t[freelist] */
+		ls.PushInteger(int64(ref))
+		ls.RawSetI(t, freelist) /* t[freelist] = ref */
+	}
+}
+
 func (ls *luaState) intError(arg int) {
 	if ls.IsNumber(arg) {
 		ls.ArgError(arg, "number has no integer representation")
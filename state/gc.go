@@ -0,0 +1,525 @@
+package state
+
+import (
+	"fmt"
+	"luago/api"
+	"luago/binchunk"
+	"luago/vm"
+	"luago/vm/lua54"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+)
+
+/*
+luaTable 持有的都是 Go 的强引用, Go 的 GC 无法知道哪些表项是"弱"的,
+所以在 Go 完成一轮 GC 之后, 在安全点上做一次 Lua 层面的标记,
+然后清理弱表中不可达的键或值.
+*/
+
+// 所有线程共享的全局状态
+type globalState struct {
+	trigger *gcTrigger
+	owner   *gcOwner
+	finobj  []luaValue        // 设置了 __gc 的对象, 按注册的顺序
+	finset  map[luaValue]bool // finobj 中的对象
+	tobefnz []luaValue        // 不可达, 等待执行终结器的对象
+	gcBusy  bool              // 正在回收或执行终结器
+	closed  bool
+
+	gcStopped  bool
+	gcMode     int // api.LUA_GCINC 或 api.LUA_GCGEN
+	gcPause    int // 百分比, 内存增长到上次回收后的多少时开始新的回收
+	gcStepMul  int
+	gcStepSize int // log2(KB)
+	gcMinorMul int // 分代模式下的增长百分比
+	gcMajorMul int
+	estimate   int64 // 上次回收后存活对象的估计大小
+	debt       int64 // 上次回收后新分配的大小
+
+	version int // 兼容的语言版本, api.LUA_VERSION_*
+
+	protoCache api.ProtoCache        // 编译源码时使用, 可以被多个状态共享
+	interrupt  atomic.Pointer[error] // 不为 nil 时, 下一条指令抛出这个错误
+	coroutines map[*luaState]bool    // 有 goroutine 的协程, goroutine 结束时删除
+
+	panicf    api.GoFunction // 不受保护的错误的处理函数
+	errorMode int            // ErrorPanic 或 ErrorReturn
+	allocf    api.Alloc      // 可以拒绝分配, 用来限制内存
+	allocud   interface{}
+}
+
+// 对象大小的估计值, 只用于统计
+const (
+	sizeTable    = 64
+	sizeTValue   = 16
+	sizeNode     = 48
+	sizeString   = 16
+	sizeClosure  = 40
+	sizeUpvalue  = 24
+	sizeThread   = 128
+	sizeUserdata = 48
+)
+
+const (
+	gcDefaultPause    = 200
+	gcDefaultStepMul  = 100
+	gcDefaultStepSize = 13
+	gcDefaultMinorMul = 20
+	gcDefaultMajorMul = 100
+	gcMinThreshold    = 256 * 1024
+)
+
+func newGlobalState() *globalState {
+	trigger := &gcTrigger{}
+	g := &globalState{
+		trigger:    trigger,
+		finset:     map[luaValue]bool{},
+		gcMode:     api.LUA_GCINC,
+		gcPause:    gcDefaultPause,
+		gcStepMul:  gcDefaultStepMul,
+		gcStepSize: gcDefaultStepSize,
+		gcMinorMul: gcDefaultMinorMul,
+		gcMajorMul: gcDefaultMajorMul,
+		version:    api.LUA_VERSION_NUM,
+	}
+	// owner 只被 g 引用, g 不可达后停止哨兵的重新注册
+	owner := &gcOwner{trigger}
+	runtime.SetFinalizer(owner, func(o *gcOwner) { o.trigger.dead.Store(true) })
+	g.owner = owner
+	newGCSentinel(trigger)
+	return g
+}
+
+type gcTrigger struct {
+	pending atomic.Bool // Go 完成了一轮 GC, 等待在安全点上处理
+	dead    atomic.Bool // 状态已经不可达
+}
+
+type gcOwner struct {
+	trigger *gcTrigger
+}
+
+// 每轮 Go GC 都会执行哨兵的终结器, 在终结器中再次注册自己
+type gcSentinel struct {
+	trigger *gcTrigger
+}
+
+func newGCSentinel(trigger *gcTrigger) {
+	runtime.SetFinalizer(&gcSentinel{trigger}, (*gcSentinel).fire)
+}
+
+func (s *gcSentinel) fire() {
+	if s.trigger.dead.Load() {
+		return
+	}
+	s.trigger.pending.Store(true)
+	runtime.SetFinalizer(s, (*gcSentinel).fire)
+}
+
+// 记录新分配的对象
+func (g *globalState) allocate(size int64) {
+	if !g.tryAllocate(size) {
+		panic(memoryError{})
+	}
+}
+
+// 分配函数拒绝时返回 false
+func (g *globalState) tryAllocate(size int64) bool {
+	if g.allocf != nil && !g.allocf(g.allocud, 0, int(size)) {
+		return false
+	}
+	g.debt += size
+	return true
+}
+
+// 分配函数拒绝分配时抛出, PCall 返回 LUA_ERRMEM
+type memoryError struct{}
+
+func (g *globalState) totalBytes() int64 {
+	return g.estimate + g.debt
+}
+
+// 新分配多少之后开始下一轮回收
+func (g *globalState) threshold() int64 {
+	mul := int64(g.gcPause - 100)
+	if g.gcMode == api.LUA_GCGEN {
+		mul = int64(g.gcMinorMul)
+	}
+	if t := g.estimate / 100 * mul; t > gcMinThreshold {
+		return t
+	}
+	return gcMinThreshold
+}
+
+// 安全点, 在调用函数之前检查
+func (ls *luaState) gcCheck() {
+	g := ls.g
+	if g.gcStopped {
+		return
+	}
+	if g.trigger.pending.Load() || g.debt >= g.threshold() {
+		ls.fullGC()
+	}
+}
+
+func (ls *luaState) fullGC() {
+	g := ls.g
+	if g.gcBusy || g.closed {
+		return
+	}
+	g.trigger.pending.Store(false)
+	g.gcBusy = true
+
+	m := &gcMarker{marked: map[luaValue]bool{}}
+	m.markValue(ls.registry)
+	for t := ls; t != nil; t = t.coCaller {
+		m.markValue(t)
+	}
+	for _, obj := range g.tobefnz {
+		m.markValue(obj)
+	}
+	m.propagateAll()
+	m.convergeEphemerons()
+	// 被复活的对象在执行终结器之前就要从弱值表中移除
+	m.clearByValues(m.weakValues)
+	m.clearByValues(m.allWeak)
+	origWeak, origAll := len(m.weakValues), len(m.allWeak)
+	m.separateToBeFnz(g)
+	m.propagateAll()
+	m.convergeEphemerons()
+	m.clearByKeys(m.ephemerons)
+	m.clearByKeys(m.allWeak)
+	m.clearByValues(m.weakValues[origWeak:])
+	m.clearByValues(m.allWeak[origAll:])
+
+	g.estimate = m.bytes
+	g.debt = 0
+	g.gcBusy = false
+	ls.callPendingFinalizers()
+}
+
+// setmetatable 时元表里有 __gc 的对象才需要终结
+func (ls *luaState) checkFinalizer(obj luaValue, mt *luaTable) {
+	g := ls.g
+	if mt == nil || mt.get("__gc") == nil || g.finset[obj] || g.closed {
+		return
+	}
+	g.finset[obj] = true
+	g.finobj = append(g.finobj, obj)
+}
+
+// 把不可达的对象移到 tobefnz 并复活它们, 后注册的先终结
+func (m *gcMarker) separateToBeFnz(g *globalState) {
+	live := g.finobj[:0]
+	var dead []luaValue
+	for _, obj := range g.finobj {
+		if m.marked[obj] {
+			live = append(live, obj)
+		} else {
+			dead = append(dead, obj)
+			delete(g.finset, obj)
+		}
+	}
+	for i := len(live); i < len(g.finobj); i++ {
+		g.finobj[i] = nil
+	}
+	g.finobj = live
+	for i := len(dead) - 1; i >= 0; i-- {
+		g.tobefnz = append(g.tobefnz, dead[i])
+		m.markValue(dead[i])
+	}
+}
+
+func (ls *luaState) callPendingFinalizers() {
+	g := ls.g
+	if g.gcBusy {
+		return
+	}
+	g.gcBusy = true
+	defer func() { g.gcBusy = false }()
+	ls.runFinalizers()
+}
+
+// 依次执行 tobefnz 里的终结器, 调用者要先设置 gcBusy
+func (ls *luaState) runFinalizers() {
+	g := ls.g
+	for len(g.tobefnz) > 0 {
+		obj := g.tobefnz[0]
+		g.tobefnz[0] = nil
+		g.tobefnz = g.tobefnz[1:]
+		ls.callFinalizer(obj)
+	}
+}
+
+// 终结器中的错误不会传播, 只输出一条警告
+func (ls *luaState) callFinalizer(obj luaValue) {
+	tm := getMetafield(obj, "__gc", ls)
+	if tm == nil {
+		return
+	}
+	ls.stack.check(2)
+	ls.stack.push(tm)
+	ls.stack.push(obj)
+	if ls.PCall(1, 0, 0) != api.LUA_OK {
+		err := ls.stack.pop()
+		msg, ok := err.(string)
+		if !ok {
+			msg = fmt.Sprintf("error object is a %s value", ls.TypeName(typeOf(err)))
+		}
+		fmt.Fprintf(os.Stderr, "Lua warning: error in __gc metamethod (%s)\n", msg)
+	}
+}
+
+type gcMarker struct {
+	marked     map[luaValue]bool
+	gray       []luaValue
+	weakValues []*luaTable // __mode = "v"
+	ephemerons []*luaTable // __mode = "k"
+	allWeak    []*luaTable // __mode = "kv"
+	bytes      int64       // 存活对象的估计大小
+}
+
+// 只有对象才会从弱表中被移除, 字符串和数字都是值
+func isCollectable(val luaValue) bool {
+	switch val.(type) {
+	case *luaTable, *closure, *luaState, *userdata:
+		return true
+	}
+	return false
+}
+
+func (m *gcMarker) isCleared(val luaValue) bool {
+	return isCollectable(val) && !m.marked[val]
+}
+
+func (m *gcMarker) markValue(val luaValue) {
+	if s, ok := val.(string); ok {
+		m.bytes += sizeString + int64(len(s))
+		return
+	}
+	if isCollectable(val) && !m.marked[val] {
+		m.marked[val] = true
+		m.gray = append(m.gray, val)
+	}
+}
+
+func (m *gcMarker) propagateAll() {
+	for len(m.gray) > 0 {
+		val := m.gray[len(m.gray)-1]
+		m.gray = m.gray[:len(m.gray)-1]
+		switch x := val.(type) {
+		case *luaTable:
+			m.traverseTable(x)
+		case *closure:
+			m.bytes += sizeClosure + int64(len(x.upvals))*sizeUpvalue
+			for _, uv := range x.upvals {
+				if uv != nil {
+					m.markValue(*uv.val)
+				}
+			}
+		case *luaState:
+			m.traverseThread(x)
+		case *userdata:
+			m.bytes += sizeUserdata
+			if x.metatable != nil {
+				m.markValue(x.metatable)
+			}
+			m.markValue(x.uservalue)
+		}
+	}
+}
+
+func (m *gcMarker) traverseTable(t *luaTable) {
+	m.bytes += tableSize(t)
+	if t.metatable != nil {
+		m.markValue(t.metatable)
+	}
+	weakKey, weakValue := t.weakMode()
+	switch {
+	case weakKey && weakValue:
+		m.allWeak = append(m.allWeak, t)
+	case weakKey:
+		m.ephemerons = append(m.ephemerons, t)
+		m.traverseEphemeron(t)
+	case weakValue:
+		m.weakValues = append(m.weakValues, t)
+		for k := range t._map {
+			m.markValue(k)
+		}
+	default:
+		for _, v := range t.arr {
+			m.markValue(v)
+		}
+		for k, v := range t._map {
+			m.markValue(k)
+			m.markValue(v)
+		}
+	}
+}
+
+// 键可达时才标记值, 返回是否标记了新的对象
+func (m *gcMarker) traverseEphemeron(t *luaTable) bool {
+	marked := false
+	for _, v := range t.arr {
+		if m.isCleared(v) {
+			m.markValue(v)
+			marked = true
+		}
+	}
+	for k, v := range t._map {
+		if !m.isCleared(k) && m.isCleared(v) {
+			m.markValue(v)
+			marked = true
+		}
+	}
+	return marked
+}
+
+func (m *gcMarker) convergeEphemerons() {
+	for changed := true; changed; {
+		changed = false
+		for _, t := range m.ephemerons {
+			if m.traverseEphemeron(t) {
+				m.propagateAll()
+				changed = true
+			}
+		}
+	}
+}
+
+func (m *gcMarker) traverseThread(t *luaState) {
+	m.bytes += sizeThread
+	for stack := t.stack; stack != nil; stack = stack.prev {
+		m.bytes += int64(len(stack.slots)) * sizeTValue
+		dead, regs := stack.deadRegisters(stack != t.stack)
+		for i := 0; i < stack.top && i < len(stack.slots); i++ {
+			if i < dead || i >= regs {
+				m.markValue(stack.slots[i])
+			}
+		}
+		for _, v := range stack.varargs {
+			m.markValue(v)
+		}
+		if stack.closure != nil {
+			m.markValue(stack.closure)
+		}
+	}
+	if t.coCaller != nil {
+		m.markValue(t.coCaller)
+	}
+}
+
+/*
+** 正在执行 CALL 的 Lua 栈帧里, 被调函数所在的寄存器和它之后的寄存器
+** 都是已经死掉的临时值 (参数已经复制到了新的栈帧), 和 C Lua 一样不标记它们.
+** 返回死寄存器的范围 [dead, regs), 其他情况下范围为空
+ */
+func (stack *luaStack) deadRegisters(calling bool) (dead, regs int) {
+	c := stack.closure
+	if !calling || c == nil || c.proto == nil || stack.pc == 0 {
+		return 0, 0
+	}
+	regs = int(c.proto.MaxStackSize)
+	inst := c.proto.Code[stack.pc-1]
+	if c.proto.Version == binchunk.LUAC_VERSION_54 {
+		i := lua54.Instruction(inst)
+		if op := i.Opcode(); op == lua54.OP_CALL || op == lua54.OP_TAILCALL {
+			a, _, _, _ := i.ABCk()
+			return a, regs
+		}
+	} else {
+		i := vm.Instruction(inst)
+		if op := i.Opcode(); op == vm.OP_CALL || op == vm.OP_TAILCALL {
+			a, _, _ := i.ABC()
+			return a, regs
+		}
+	}
+	return 0, 0
+}
+
+func (m *gcMarker) clearByValues(tables []*luaTable) {
+	for _, t := range tables {
+		removed := map[luaValue]bool{}
+		for i, v := range t.arr {
+			if m.isCleared(v) {
+				t.arr[i] = nil
+				removed[int64(i+1)] = true
+			}
+		}
+		if n := len(t.arr); n > 0 && t.arr[n-1] == nil {
+			t._shrinkArray()
+		}
+		for k, v := range t._map {
+			if m.isCleared(v) {
+				delete(t._map, k)
+				removed[k] = true
+			}
+		}
+		t.unlinkKeys(removed)
+	}
+}
+
+func (m *gcMarker) clearByKeys(tables []*luaTable) {
+	for _, t := range tables {
+		removed := map[luaValue]bool{}
+		for k := range t._map {
+			if m.isCleared(k) {
+				delete(t._map, k)
+				removed[k] = true
+			}
+		}
+		t.unlinkKeys(removed)
+	}
+}
+
+func tableSize(t *luaTable) int64 {
+	return sizeTable + int64(cap(t.arr))*sizeTValue + int64(len(t._map))*sizeNode
+}
+
+func (lt *luaTable) weakMode() (weakKey, weakValue bool) {
+	if lt.metatable == nil {
+		return false, false
+	}
+	if mode, ok := lt.metatable.get("__mode").(string); ok {
+		return strings.IndexByte(mode, 'k') >= 0, strings.IndexByte(mode, 'v') >= 0
+	}
+	return false, false
+}
+
+/*
+** 把一次回收中移除的键从遍历链中摘掉, 整个链只走一遍.
+** 被移除的键自己的 next 仍然有效, 正在遍历到它的 next 可以继续
+ */
+func (lt *luaTable) unlinkKeys(removed map[luaValue]bool) {
+	if lt.keys == nil || len(removed) == 0 {
+		return
+	}
+	var order []luaValue
+	for k, ok := lt.keys[nil]; ok; k, ok = lt.keys[k] {
+		order = append(order, k)
+	}
+	// 链上每个键之后第一个没有被移除的键
+	nextLive := make(map[luaValue]luaValue, len(order))
+	var live luaValue
+	hasLive := false
+	for i := len(order) - 1; i >= 0; i-- {
+		if hasLive {
+			nextLive[order[i]] = live
+		}
+		if !removed[order[i]] {
+			live, hasLive = order[i], true
+		}
+	}
+	for prev, k := range lt.keys {
+		if !removed[k] {
+			continue
+		}
+		if next, ok := nextLive[k]; ok {
+			lt.keys[prev] = next
+		} else {
+			delete(lt.keys, prev)
+		}
+	}
+}
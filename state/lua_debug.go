@@ -0,0 +1,85 @@
+package state
+
+import "strings"
+
+const luaIDSize = 60 // 函数源的描述信息的最大长度
+
+// 与 luaO_chunkid 相同
+func chunkID(source string) string {
+	const rets, pre, pos = "...", "[string \"", "\"]"
+	l := len(source)
+	switch {
+	case strings.HasPrefix(source, "="): /* 'literal' source */
+		if l <= luaIDSize { /* small enough? */
+			return source[1:]
+		}
+		return source[1:luaIDSize] /* truncate it */
+	case strings.HasPrefix(source, "@"): /* file name */
+		if l <= luaIDSize { /* small enough? */
+			return source[1:]
+		}
+		/* add '...' before rest of name */
+		return rets + source[l-(luaIDSize-len(rets)-1):]
+	default: /* string; format as [string "source"] */
+		bufflen := luaIDSize - len(pre+rets+pos) - 1
+		nl := strings.IndexByte(source, '\n')
+		if l < bufflen && nl < 0 { /* small one-line source? */
+			return pre + source + pos
+		}
+		if nl >= 0 {
+			l = nl /* stop at first newline */
+		}
+		if l > bufflen {
+			l = bufflen
+		}
+		return pre + source[:l] + rets + pos
+	}
+}
+
+// 第 level 层调用的栈帧, 0 表示当前正在运行的函数
+func (ls *luaState) getFrame(level int) *luaStack {
+	stack := ls.stack
+	for ; level > 0 && stack != nil; level-- {
+		stack = stack.prev
+	}
+	if stack == nil || stack.closure == nil {
+		return nil
+	}
+	return stack
+}
+
+func (ls *luaState) PushStackFunction(level int) bool {
+	if stack := ls.getFrame(level); stack != nil {
+		ls.stack.push(stack.closure)
+		return true
+	}
+	return false
+}
+
+// 当前执行到的行号, Go 函数返回 -1
+func currentLine(stack *luaStack) int {
+	proto := stack.closure.proto
+	if proto == nil || stack.pc < 1 || stack.pc > len(proto.LineInfo) {
+		return -1
+	}
+	return int(proto.LineInfo[stack.pc-1])
+}
+
+// 第 n 个活跃的局部变量在寄存器 n 里, 与 luaF_getlocalname 相同
+func (stack *luaStack) localName(slot int) string {
+	if c := stack.closure; c != nil && c.proto != nil {
+		pc := stack.pc - 1
+		for _, locVar := range c.proto.LocVars {
+			if int(locVar.StartPC) > pc {
+				break
+			}
+			if pc < int(locVar.EndPC) {
+				if slot == 0 {
+					return locVar.VarName
+				}
+				slot--
+			}
+		}
+	}
+	return "?"
+}
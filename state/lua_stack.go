@@ -11,6 +11,7 @@ type luaStack struct {
 	pc      int
 	state   *luaState
 	openuvs map[int]*upvalue
+	tbcs    []int // 待关闭变量所在的寄存器, 按声明顺序
 }
 
 func newLuaStack(size int, state *luaState) *luaStack {
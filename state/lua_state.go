@@ -1,6 +1,9 @@
 package state
 
-import "luago/api"
+import (
+	"fmt"
+	"luago/api"
+)
 
 type luaState struct {
 	registry *luaTable // 注册表
@@ -8,10 +11,26 @@ type luaState struct {
 	coStatus int
 	coCaller *luaState
 	coChan   chan int
+	coKilled bool
+	coErr    luaValue      // 协程出错结束时的错误对象
+	nYields  int           // 让出的次数, 用来判断调用期间是否让出过
+	restored []*luaStack   // 从快照恢复的挂起的 Lua 帧, 由外到内, 第一次 Resume 时继续执行
+	nPCalls  int           // 正在执行的 PCall 的数量, 为 0 时宿主直接调用的函数不受保护
+	err      *api.LuaError // ErrorReturn 模式下 Call 出的错, 由 Err 返回
+	g        *globalState
 }
 
 func New() *luaState {
-	ls := &luaState{}
+	return NewWithVersion(api.LUA_VERSION_NUM)
+}
+
+// 创建兼容指定语言版本的状态, 标准库按版本注册函数
+func NewWithVersion(version int) *luaState {
+	if version != api.LUA_VERSION_51 && version != api.LUA_VERSION_53 {
+		panic(fmt.Sprintf("unsupported Lua version %d", version))
+	}
+	ls := &luaState{g: newGlobalState()}
+	ls.g.version = version
 	registry := newLuaTable(8, 0)
 	registry.put(api.LUA_RIDX_MAINTHREAD, ls)
 	registry.put(api.LUA_RIDX_GLOBALS, newLuaTable(0, 20))
@@ -20,6 +39,28 @@ func New() *luaState {
 	return ls
 }
 
+// 关闭状态, 执行所有对象的终结器.
+// 可以在终结器里调用 (比如 os.exit(code, true)), 这时剩下的终结器直接在这里执行
+func (ls *luaState) Close() {
+	g := ls.g
+	if g.closed {
+		return
+	}
+	mainThread := ls.registry.get(api.LUA_RIDX_MAINTHREAD).(*luaState)
+	defer func(busy bool) { g.gcBusy = busy }(g.gcBusy)
+	g.gcBusy = true
+	for len(g.finobj) > 0 || len(g.tobefnz) > 0 {
+		for i := len(g.finobj) - 1; i >= 0; i-- {
+			g.tobefnz = append(g.tobefnz, g.finobj[i])
+		}
+		g.finobj = nil
+		g.finset = map[luaValue]bool{}
+		mainThread.runFinalizers()
+	}
+	g.closed = true
+	g.trigger.dead.Store(true)
+}
+
 func (ls *luaState) isMainThread() bool {
 	return ls.registry.get(api.LUA_RIDX_MAINTHREAD) == ls
 }
@@ -45,10 +45,10 @@ func _floatToInteger(key luaValue) luaValue {
 
 func (lt *luaTable) put(key, val luaValue) {
 	if key == nil {
-		panic("table index is nil!")
+		panic("table index is nil")
 	}
 	if f, ok := key.(float64); ok && math.IsNaN(f) {
-		panic("table index is NaN!")
+		panic("table index is NaN")
 	}
 	key = _floatToInteger(key)
 
@@ -85,6 +85,8 @@ func (lt *luaTable) _shrinkArray() {
 	for i := len(lt.arr) - 1; i >= 0; i-- {
 		if lt.arr[i] == nil {
 			lt.arr = lt.arr[0:i]
+		} else {
+			break
 		}
 	}
 }
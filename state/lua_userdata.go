@@ -0,0 +1,17 @@
+package state
+
+// 完全用户数据, data 可以是任意的 Go 值
+type userdata struct {
+	metatable *luaTable
+	uservalue luaValue
+	data      interface{}
+}
+
+func newUserdata(data interface{}) *userdata {
+	return &userdata{data: data}
+}
+
+// 轻量用户数据, 只是一个 Go 值, 按值比较, 没有独立的元表
+type lightUserdata struct {
+	p interface{}
+}
@@ -26,6 +26,10 @@ func typeOf(val luaValue) api.LuaType {
 		return api.LUA_TFUNCTION
 	case *luaState:
 		return api.LUA_TTHREAD
+	case *userdata:
+		return api.LUA_TUSERDATA
+	case lightUserdata:
+		return api.LUA_TLIGHTUSERDATA
 	default:
 		panic("todo!")
 	}
@@ -44,6 +48,20 @@ func convertToFloat(val luaValue) (float64, bool) {
 	}
 }
 
+// 数字转换成字符串; 5.1 没有整数类型, 都按 LUAI_NUMFFORMAT "%.14g" 格式化
+func (ls *luaState) numberToString(val luaValue) string {
+	if ls.g.version == api.LUA_VERSION_51 { // 5.1 只有浮点数
+		f, _ := convertToFloat(val)
+		return number.FormatFloat(f)
+	}
+	switch x := val.(type) {
+	case int64:
+		return number.IntegerToString(x)
+	default:
+		return number.FloatToString(x.(float64))
+	}
+}
+
 func convertToInteger(val luaValue) (int64, bool) {
 	switch x := val.(type) {
 	case int64:
@@ -70,6 +88,12 @@ func _stringToInteger(s string) (int64, bool) {
 func setMetatable(val luaValue, mt *luaTable, ls *luaState) {
 	if t, ok := val.(*luaTable); ok {
 		t.metatable = mt
+		ls.checkFinalizer(t, mt)
+		return
+	}
+	if u, ok := val.(*userdata); ok {
+		u.metatable = mt
+		ls.checkFinalizer(u, mt)
 		return
 	}
 
@@ -81,6 +105,9 @@ func getMetatable(val luaValue, ls *luaState) *luaTable {
 	if t, ok := val.(*luaTable); ok {
 		return t.metatable
 	}
+	if u, ok := val.(*userdata); ok {
+		return u.metatable
+	}
 
 	key := fmt.Sprintf("_MT%d", typeOf(val))
 	if mt := ls.registry.get(key); mt != nil {
@@ -0,0 +1,294 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"luago/api"
+	"luago/compiler"
+	"luago/stdlib"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+var ErrPoolClosed = errors.New("luago: pool is closed")
+
+type PoolOptions struct {
+	Size       int                         // 最多同时存在的状态数, 0 表示 runtime.NumCPU()
+	Prealloc   int                         // 创建池时预先创建的状态数
+	Version    int                         // 兼容的语言版本, 0 表示 api.LUA_VERSION_NUM
+	Libs       map[string]api.GoFunction   // 要打开的库, nil 表示 OpenLibs
+	Preload    []string                    // 创建状态后 require 的模块
+	Init       func(ls api.LuaState) error // 最后执行的初始化, 比如注册 Go 函数
+	ProtoCache *compiler.ProtoCache        // 所有状态共享的编译缓存, nil 时池自己创建一个
+}
+
+/*
+** 给多个 goroutine 使用的状态池. 每个状态同时只交给一个 goroutine;
+** 归还时恢复到初始化完成时的样子: 栈被清空, 初始化时可达的表和
+** upvalue 恢复原来的内容, 之后新建的全局变量和模块都被删除,
+** 之后创建的挂起的协程被关闭. 用户数据里的 Go 数据不会恢复, 只有
+** math.random 的随机数源例外: 初始化时用固定的种子设置过的恢复到初始化完成时的状态,
+** 否则重新取种子, 和新建的状态一样. 宿主自己的随机数源要实现
+** encoding.BinaryMarshaler 和 encoding.BinaryUnmarshaler 才能恢复
+ */
+type Pool struct {
+	opts PoolOptions
+	idle chan *luaState
+	sem  chan struct{} // 每个存在的状态占一个位置
+
+	mu     sync.Mutex
+	closed bool
+	base   map[*luaState]*baseline
+}
+
+func NewPool(opts PoolOptions) (*Pool, error) {
+	if opts.Size <= 0 {
+		opts.Size = runtime.NumCPU()
+	}
+	if opts.Version == 0 {
+		opts.Version = api.LUA_VERSION_NUM
+	}
+	if opts.ProtoCache == nil {
+		opts.ProtoCache = compiler.NewProtoCache("")
+	}
+	p := &Pool{
+		opts: opts,
+		idle: make(chan *luaState, opts.Size),
+		sem:  make(chan struct{}, opts.Size),
+		base: map[*luaState]*baseline{},
+	}
+	for i := 0; i < opts.Prealloc && i < opts.Size; i++ {
+		p.sem <- struct{}{}
+		ls, err := p.newState()
+		if err != nil {
+			<-p.sem
+			p.Close()
+			return nil, err
+		}
+		p.idle <- ls
+	}
+	return p, nil
+}
+
+// 取出一个空闲的状态, 没有空闲的并且数量已满时等待, 直到 ctx 结束
+func (p *Pool) Get(ctx context.Context) (api.LuaState, error) {
+	for {
+		if p.isClosed() {
+			return nil, ErrPoolClosed
+		}
+		select {
+		case ls := <-p.idle:
+			return ls, nil
+		default:
+		}
+		select {
+		case ls := <-p.idle:
+			return ls, nil
+		case p.sem <- struct{}{}:
+			ls, err := p.newState()
+			if err != nil {
+				<-p.sem
+				return nil, err
+			}
+			return ls, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// 归还状态; 状态已经关闭或者无法恢复时丢弃它, 腾出一个位置
+func (p *Pool) Put(s api.LuaState) {
+	ls := s.(*luaState)
+	p.mu.Lock()
+	b, ok := p.base[ls]
+	p.mu.Unlock()
+	if !ok {
+		panic("luago: state does not belong to this pool")
+	}
+	if p.isClosed() || !p.reset(ls, b) {
+		p.discard(ls)
+		return
+	}
+	p.idle <- ls
+}
+
+// 关闭池和所有空闲的状态, 之后归还的状态直接关闭
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	for {
+		select {
+		case ls := <-p.idle:
+			p.discard(ls)
+		default:
+			return
+		}
+	}
+}
+
+func (p *Pool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func (p *Pool) discard(ls *luaState) {
+	p.mu.Lock()
+	delete(p.base, ls)
+	p.mu.Unlock()
+	ls.Close()
+	<-p.sem
+}
+
+func (p *Pool) newState() (ls *luaState, err error) {
+	ls = NewWithVersion(p.opts.Version)
+	ls.SetProtoCache(p.opts.ProtoCache)
+	if p.opts.Libs == nil {
+		ls.OpenLibs()
+	} else {
+		names := make([]string, 0, len(p.opts.Libs))
+		for name := range p.opts.Libs {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { // 基础库最先打开
+			return names[i] == "_G" || names[j] != "_G" && names[i] < names[j]
+		})
+		for _, name := range names {
+			ls.RequireF(name, p.opts.Libs[name], true)
+			ls.Pop(1)
+		}
+	}
+	for _, name := range p.opts.Preload {
+		ls.GetGlobal("require")
+		ls.PushString(name)
+		if ls.PCall(1, 0, 0) != api.LUA_OK {
+			err = errors.New(ls.ToString(-1))
+			ls.Close()
+			return nil, err
+		}
+	}
+	if p.opts.Init != nil {
+		if err = p.opts.Init(ls); err != nil {
+			ls.Close()
+			return nil, err
+		}
+	}
+	ls.SetTop(0)
+	p.mu.Lock()
+	p.base[ls] = newBaseline(ls)
+	p.mu.Unlock()
+	return ls, nil
+}
+
+func (p *Pool) reset(ls *luaState, b *baseline) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	if ls.g.closed || ls.stack.prev != nil { // 还在调用中或者已经关闭
+		return false
+	}
+	ls.SetTop(0)
+	ls.err = nil
+	ls.g.interrupt.Store(nil)
+	for co := range ls.g.coroutines {
+		if !b.coroutines[co] && co.coStatus == api.LUA_YIELD {
+			co.CloseThread(ls) // 否则它的 goroutine 一直阻塞
+		}
+	}
+	b.restore()
+	if !stdlib.RandomSeeded(ls) || stdlib.RestoreRandom(ls, b.random) != nil {
+		stdlib.ReseedRandom(ls)
+	}
+	return true
+}
+
+// 初始化完成时可达的表和 upvalue 的内容
+type baseline struct {
+	tables     map[*luaTable]tableContent
+	upvals     map[*upvalue]luaValue
+	coroutines map[*luaState]bool
+	random     []byte // 随机数源的状态
+}
+
+type tableContent struct {
+	metatable *luaTable
+	arr       []luaValue
+	_map      map[luaValue]luaValue
+}
+
+func newBaseline(ls *luaState) *baseline {
+	b := &baseline{
+		tables:     map[*luaTable]tableContent{},
+		upvals:     map[*upvalue]luaValue{},
+		coroutines: map[*luaState]bool{},
+		random:     stdlib.SaveRandom(ls),
+	}
+	for co := range ls.g.coroutines {
+		b.coroutines[co] = true
+	}
+	b.mark(ls.registry)
+	return b
+}
+
+func (b *baseline) mark(val luaValue) {
+	switch x := val.(type) {
+	case *luaTable:
+		if _, ok := b.tables[x]; ok {
+			return
+		}
+		content := tableContent{
+			metatable: x.metatable,
+			arr:       append([]luaValue(nil), x.arr...),
+			_map:      make(map[luaValue]luaValue, len(x._map)),
+		}
+		for k, v := range x._map {
+			content._map[k] = v
+		}
+		b.tables[x] = content
+		if x.metatable != nil {
+			b.mark(x.metatable)
+		}
+		for _, v := range x.arr {
+			b.mark(v)
+		}
+		for k, v := range x._map {
+			b.mark(k)
+			b.mark(v)
+		}
+	case *userdata:
+		if x.metatable != nil {
+			b.mark(x.metatable)
+		}
+		b.mark(x.uservalue)
+	case *closure:
+		for _, uv := range x.upvals {
+			if uv == nil {
+				continue
+			}
+			if _, ok := b.upvals[uv]; !ok {
+				b.upvals[uv] = *uv.val
+				b.mark(*uv.val)
+			}
+		}
+	}
+}
+
+func (b *baseline) restore() {
+	for t, content := range b.tables {
+		t.metatable = content.metatable
+		t.arr = append(t.arr[:0], content.arr...)
+		t._map = make(map[luaValue]luaValue, len(content._map))
+		for k, v := range content._map {
+			t._map[k] = v
+		}
+		t.keys = nil
+	}
+	for uv, v := range b.upvals {
+		*uv.val = v
+	}
+}
@@ -0,0 +1,49 @@
+package state
+
+import (
+	"luago/api"
+)
+
+/*
+** 在注册表里固定一个值, 让 Go 代码在多次调用之间持有 Lua 的函数或表.
+** 引用属于状态而不是线程, 可以压入同一个状态的任何线程;
+** 和状态一样只能在使用状态的 goroutine 里操作
+ */
+type Ref struct {
+	g   *globalState
+	ref int
+}
+
+// 引用 idx 处的值, 值不出栈; 不再需要时调用 Release
+func NewRef(l api.LuaState, idx int) *Ref {
+	ls := l.(*luaState)
+	ls.PushValue(idx)
+	return &Ref{g: ls.g, ref: ls.Ref(api.LUA_REGISTRYINDEX)}
+}
+
+// 把引用的值压入 l 的栈顶, l 必须属于创建引用的状态
+func (r *Ref) Push(l api.LuaState) {
+	ls := l.(*luaState)
+	if ls.g != r.g {
+		panic("luago: reference belongs to a different state")
+	}
+	if r.ref == api.LUA_NOREF {
+		panic("luago: reference has been released")
+	}
+	ls.RawGetI(api.LUA_REGISTRYINDEX, int64(r.ref))
+}
+
+// 释放引用, 之后值可以被回收; 多次调用没有影响
+func (r *Ref) Release(l api.LuaState) {
+	ls := l.(*luaState)
+	if ls.g != r.g {
+		panic("luago: reference belongs to a different state")
+	}
+	ls.Unref(api.LUA_REGISTRYINDEX, r.ref)
+	r.ref = api.LUA_NOREF
+}
+
+// 注册表中的索引, LUA_REFNIL 表示引用的是 nil, 释放后是 LUA_NOREF
+func (r *Ref) Index() int {
+	return r.ref
+}
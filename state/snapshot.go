@@ -0,0 +1,930 @@
+package state
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"luago/api"
+	"luago/binchunk"
+	"luago/stdlib"
+	"luago/vm"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+/*
+** 快照: 把注册表 (包括全局表) 能到达的所有值写成字节流, 再在另一个状态里重建.
+** 表的共享引用和环, Lua 闭包 (原型和 upvalue), 挂起的协程都会保存.
+** Go 函数按名字保存: 库表里的函数用 "模块名.字段名", 其他的用 RegisterGoFunction
+** 注册的名字 (按函数值区分, 同一个函数字面量创建的不同闭包要分别注册).
+** 一个函数的所有名字都会保存 (比如 pr = print 之后的 "_G.pr" 和 "_G.print"),
+** 恢复时使用目标状态里第一个存在的名字; 两边的状态必须打开同样的库.
+** 注册表里字符串键下的用户数据 (宿主设置的钩子) 不保存, 恢复时保留目标状态自己的,
+** 但 math.random 的随机数源的状态会保存和恢复; 其他用户数据的 data
+** 用 encoding/gob 编码, 接口类型要先 gob.Register
+ */
+
+const _SNAPSHOT_SIGNATURE = "\x1bLuaSnap"
+const _SNAPSHOT_FORMAT = 2
+
+var _goFuncNames = struct {
+	sync.RWMutex
+	m map[string]api.GoFunction
+}{m: map[string]api.GoFunction{}}
+
+// 给不在库表里的 Go 函数起一个名字, 快照按这个名字保存和查找它
+func RegisterGoFunction(name string, f api.GoFunction) {
+	_goFuncNames.Lock()
+	_goFuncNames.m[name] = f
+	_goFuncNames.Unlock()
+}
+
+func init() {
+	for name, f := range stdlib.HiddenFuncs {
+		RegisterGoFunction(name, f)
+	}
+}
+
+/* kinds of saved values */
+const (
+	_SV_NIL = iota
+	_SV_FALSE
+	_SV_TRUE
+	_SV_INT
+	_SV_FLOAT
+	_SV_STRING
+	_SV_REF
+)
+
+/* kinds of saved objects */
+const (
+	_SO_TABLE = iota
+	_SO_LUACLOSURE
+	_SO_GOCLOSURE
+	_SO_USERDATA
+	_SO_THREAD
+	_SO_MAINTHREAD
+)
+
+type snapValue struct {
+	Kind byte
+	I    int64
+	F    float64
+	S    string
+	Ref  int // 对象编号
+}
+
+type snapObject struct {
+	Kind      byte
+	Meta      int // 元表的编号加一, 0 表示没有
+	Arr       []snapValue
+	Keys      []snapValue
+	Vals      []snapValue
+	Proto     int      // Lua 闭包的原型编号
+	GoNames   []string // Go 闭包的所有名字, 注册的名字和库里的字段在前
+	Upvals    []int    // upvalue 编号, -1 表示没有
+	HasData   bool
+	Data      []byte // 用户数据的 data
+	UserValue snapValue
+	Thread    *snapThread
+}
+
+type snapThread struct {
+	Status int
+	Err    snapValue
+	Frames []snapFrame // 第一个是基础栈, 后面是挂起时的 Lua 帧, 由外到内
+}
+
+type snapFrame struct {
+	Size    int
+	Slots   []snapValue // slots[:top]
+	Closure int         // 基础栈是 -1
+	Varargs []snapValue
+	PC      int
+	OpenUvs map[int]int // 寄存器 -> upvalue 编号
+	Tbcs    []int
+}
+
+type snapUpvalue struct {
+	Open   bool
+	Val    snapValue // 已经关闭的 upvalue 的值
+	Thread int       // 打开的 upvalue 所在的协程, 帧和寄存器
+	Frame  int
+	Slot   int
+}
+
+type snapData struct {
+	Version  int // 兼容的语言版本
+	Protos   [][]byte
+	Objects  []snapObject
+	Upvals   []snapUpvalue
+	Registry int
+	Random   []byte // math.random 的随机数源的状态, 见 stdlib.SaveRandom
+}
+
+type udBox struct {
+	V interface{}
+}
+
+type snapshotter struct {
+	ls      *luaState
+	names   map[uintptr][]string
+	objs    map[luaValue]int
+	list    []luaValue
+	paths   []string
+	frames  map[*luaState][]*luaStack
+	protos  map[*binchunk.Prototype]int
+	uvs     map[*upvalue]int
+	uvList  []*upvalue
+	openUvs map[*upvalue]snapUpvalue
+	data    snapData
+}
+
+// 保存状态, 状态不能正在执行 Lua 代码
+func (ls *luaState) Snapshot() (data []byte, err error) {
+	if !ls.isMainThread() || ls.stack.prev != nil {
+		return nil, fmt.Errorf("snapshot: state is running")
+	}
+	s := &snapshotter{
+		ls:      ls,
+		objs:    map[luaValue]int{},
+		frames:  map[*luaState][]*luaStack{},
+		protos:  map[*binchunk.Prototype]int{},
+		uvs:     map[*upvalue]int{},
+		openUvs: map[*upvalue]snapUpvalue{},
+	}
+	_, s.names = goFunctions(ls)
+	if err = s.visit(ls.registry, "registry"); err != nil {
+		return nil, err
+	}
+	s.data.Version = ls.g.version
+	s.data.Registry = s.objs[ls.registry]
+	s.data.Random = stdlib.SaveRandom(ls)
+	for i, obj := range s.list {
+		o, err := s.object(obj, s.paths[i])
+		if err != nil {
+			return nil, err
+		}
+		s.data.Objects = append(s.data.Objects, o)
+	}
+	for _, uv := range s.uvList {
+		if su, ok := s.openUvs[uv]; ok {
+			s.data.Upvals = append(s.data.Upvals, su)
+		} else {
+			s.data.Upvals = append(s.data.Upvals, snapUpvalue{Val: s.value(*uv.val)})
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(_SNAPSHOT_SIGNATURE)
+	buf.WriteByte(_SNAPSHOT_FORMAT)
+	if err = gob.NewEncoder(&buf).Encode(&s.data); err != nil {
+		return nil, fmt.Errorf("snapshot: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// 注册表里字符串键下的用户数据和轻量用户数据键 (RawSetP) 下的值是宿主的钩子, 不保存
+func isHostHook(k, v luaValue) bool {
+	if _, ok := k.(lightUserdata); ok {
+		return true
+	}
+	_, isStr := k.(string)
+	_, isUd := v.(*userdata)
+	return isStr && isUd
+}
+
+// 第一遍: 给每个对象编号, 记下它的路径, 用于错误信息
+func (s *snapshotter) visit(val luaValue, path string) error {
+	switch val.(type) {
+	case *luaTable, *closure, *userdata, *luaState:
+	case lightUserdata:
+		return fmt.Errorf("snapshot: cannot save light userdata at %s", path)
+	default:
+		return nil
+	}
+	if _, ok := s.objs[val]; ok {
+		return nil
+	}
+	s.objs[val] = len(s.list)
+	s.list = append(s.list, val)
+	s.paths = append(s.paths, path)
+
+	switch x := val.(type) {
+	case *luaTable:
+		if x.metatable != nil {
+			if err := s.visit(x.metatable, path+".<metatable>"); err != nil {
+				return err
+			}
+		}
+		for i, v := range x.arr {
+			if err := s.visit(v, fmt.Sprintf("%s[%d]", path, i+1)); err != nil {
+				return err
+			}
+		}
+		for k, v := range x._map {
+			if x == s.ls.registry && isHostHook(k, v) {
+				continue
+			}
+			if err := s.visit(k, path+".<key>"); err != nil {
+				return err
+			}
+			if err := s.visit(v, path+"."+keyString(k)); err != nil {
+				return err
+			}
+		}
+	case *closure:
+		if x.proto != nil {
+			if x.proto.Version == binchunk.LUAC_VERSION_54 {
+				return fmt.Errorf("snapshot: cannot save 5.4 function at %s", path)
+			}
+			if _, ok := s.protos[x.proto]; !ok {
+				s.protos[x.proto] = len(s.data.Protos)
+				s.data.Protos = append(s.data.Protos, binchunk.Dump(x.proto, false))
+			}
+		} else if _, ok := s.names[funcPointer(x.goFunc)]; !ok {
+			return fmt.Errorf("snapshot: cannot save unnamed Go function at %s", path)
+		}
+		for i, uv := range x.upvals {
+			if uv == nil {
+				continue
+			}
+			if _, ok := s.uvs[uv]; !ok {
+				s.uvs[uv] = len(s.uvList)
+				s.uvList = append(s.uvList, uv)
+				if err := s.visit(*uv.val, fmt.Sprintf("%s.<upvalue %d>", path, i+1)); err != nil {
+					return err
+				}
+			}
+		}
+	case *userdata:
+		if x.metatable != nil {
+			if err := s.visit(x.metatable, path+".<metatable>"); err != nil {
+				return err
+			}
+		}
+		return s.visit(x.uservalue, path+".<uservalue>")
+	case *luaState:
+		if x == s.ls {
+			return nil
+		}
+		frames, err := s.threadFrames(x, path)
+		if err != nil {
+			return err
+		}
+		s.frames[x] = frames
+		if err := s.visit(x.coErr, path+".<error>"); err != nil {
+			return err
+		}
+		for fi, frame := range frames {
+			fpath := fmt.Sprintf("%s.<frame %d>", path, fi)
+			if frame.closure != nil {
+				if err := s.visit(frame.closure, fpath); err != nil {
+					return err
+				}
+			}
+			for i, v := range frame.slots[:frame.top] {
+				if err := s.visit(v, fmt.Sprintf("%s[%d]", fpath, i+1)); err != nil {
+					return err
+				}
+			}
+			for _, v := range frame.varargs {
+				if err := s.visit(v, fpath+".<vararg>"); err != nil {
+					return err
+				}
+			}
+			for slot, uv := range frame.openuvs {
+				s.openUvs[uv] = snapUpvalue{Open: true, Thread: s.objs[x], Frame: fi, Slot: slot}
+			}
+		}
+	}
+	return nil
+}
+
+/*
+** Frames of a coroutine, base stack first. A suspended coroutine can only
+** be saved when it yielded through 'coroutine.yield' and every Lua frame
+** is stopped at a call, because the Go call stack is rebuilt from them.
+ */
+func (s *snapshotter) threadFrames(t *luaState, path string) ([]*luaStack, error) {
+	var frames []*luaStack
+	for frame := t.stack; frame != nil; frame = frame.prev {
+		if frame.prev != nil && frame.prev.prev == nil && isResumeRestored(frame.closure) {
+			continue /* restored before: the helper is created again on resume */
+		}
+		frames = append([]*luaStack{frame}, frames...)
+	}
+	if t.restored != nil {
+		return append(frames, t.restored...), nil
+	}
+	if len(frames) == 1 {
+		return frames, nil
+	}
+	if t.coStatus != api.LUA_YIELD {
+		return nil, fmt.Errorf("snapshot: cannot save running coroutine at %s", path)
+	}
+	yield := frames[len(frames)-1]
+	if yield.closure == nil || yield.closure.proto != nil ||
+		!slices.Contains(s.names[funcPointer(yield.closure.goFunc)], "coroutine.yield") {
+		return nil, fmt.Errorf("snapshot: coroutine at %s is not suspended by coroutine.yield", path)
+	}
+	frames = frames[:len(frames)-1]
+	for _, frame := range frames[1:] {
+		c := frame.closure
+		if c == nil || c.proto == nil {
+			return nil, fmt.Errorf("snapshot: coroutine at %s is suspended inside a Go function", path)
+		}
+		if _, ok := vm.CallResults(vm.Instruction(c.proto.Code[frame.pc-1])); !ok {
+			return nil, fmt.Errorf("snapshot: coroutine at %s is suspended inside a metamethod", path)
+		}
+	}
+	return frames, nil
+}
+
+// 第二遍: 所有对象都已编号, 写出对象的内容
+func (s *snapshotter) object(val luaValue, path string) (o snapObject, err error) {
+	switch x := val.(type) {
+	case *luaTable:
+		o.Kind = _SO_TABLE
+		o.Meta = s.meta(x.metatable)
+		for _, v := range x.arr {
+			o.Arr = append(o.Arr, s.value(v))
+		}
+		for k, v := range x._map {
+			if x == s.ls.registry && isHostHook(k, v) {
+				continue
+			}
+			o.Keys = append(o.Keys, s.value(k))
+			o.Vals = append(o.Vals, s.value(v))
+		}
+	case *closure:
+		if x.proto != nil {
+			o.Kind = _SO_LUACLOSURE
+			o.Proto = s.protos[x.proto]
+		} else {
+			o.Kind = _SO_GOCLOSURE
+			o.GoNames = s.names[funcPointer(x.goFunc)]
+		}
+		for _, uv := range x.upvals {
+			if uv == nil {
+				o.Upvals = append(o.Upvals, -1)
+			} else {
+				o.Upvals = append(o.Upvals, s.uvs[uv])
+			}
+		}
+	case *userdata:
+		o.Kind = _SO_USERDATA
+		o.Meta = s.meta(x.metatable)
+		o.UserValue = s.value(x.uservalue)
+		if x.data != nil {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(&udBox{x.data}); err != nil {
+				return o, fmt.Errorf("snapshot: cannot save userdata at %s: %v", path, err)
+			}
+			o.HasData, o.Data = true, buf.Bytes()
+		}
+	case *luaState:
+		if x == s.ls {
+			o.Kind = _SO_MAINTHREAD
+			return
+		}
+		o.Kind = _SO_THREAD
+		o.Thread = s.thread(x)
+	}
+	return
+}
+
+func (s *snapshotter) thread(t *luaState) *snapThread {
+	st := &snapThread{Status: t.coStatus, Err: s.value(t.coErr)}
+	for _, frame := range s.frames[t] {
+		sf := snapFrame{
+			Size:    len(frame.slots),
+			Closure: -1,
+			PC:      frame.pc,
+			Tbcs:    frame.tbcs,
+		}
+		if frame.closure != nil {
+			sf.Closure = s.objs[frame.closure]
+		}
+		for _, v := range frame.slots[:frame.top] {
+			sf.Slots = append(sf.Slots, s.value(v))
+		}
+		for _, v := range frame.varargs {
+			sf.Varargs = append(sf.Varargs, s.value(v))
+		}
+		if len(frame.openuvs) > 0 {
+			sf.OpenUvs = map[int]int{}
+			for slot, uv := range frame.openuvs {
+				sf.OpenUvs[slot] = s.uvs[uv]
+			}
+		}
+		st.Frames = append(st.Frames, sf)
+	}
+	return st
+}
+
+func (s *snapshotter) meta(mt *luaTable) int {
+	if mt == nil {
+		return 0
+	}
+	return s.objs[mt] + 1
+}
+
+func (s *snapshotter) value(val luaValue) snapValue {
+	switch x := val.(type) {
+	case nil:
+		return snapValue{Kind: _SV_NIL}
+	case bool:
+		if x {
+			return snapValue{Kind: _SV_TRUE}
+		}
+		return snapValue{Kind: _SV_FALSE}
+	case int64:
+		return snapValue{Kind: _SV_INT, I: x}
+	case float64:
+		return snapValue{Kind: _SV_FLOAT, F: x}
+	case string:
+		return snapValue{Kind: _SV_STRING, S: x}
+	default:
+		return snapValue{Kind: _SV_REF, Ref: s.objs[val]}
+	}
+}
+
+/*
+** Replace the contents of this state with a snapshot. The state must have
+** opened the same libraries as the saved one. Nothing is changed when an
+** error is returned.
+ */
+func (ls *luaState) Restore(data []byte) error {
+	if !ls.isMainThread() || ls.stack.prev != nil {
+		return fmt.Errorf("snapshot: state is running")
+	}
+	if len(data) <= len(_SNAPSHOT_SIGNATURE) ||
+		string(data[:len(_SNAPSHOT_SIGNATURE)]) != _SNAPSHOT_SIGNATURE {
+		return fmt.Errorf("snapshot: not a snapshot")
+	}
+	if data[len(_SNAPSHOT_SIGNATURE)] != _SNAPSHOT_FORMAT {
+		return fmt.Errorf("snapshot: format mismatch")
+	}
+	var d snapData
+	r := bytes.NewReader(data[len(_SNAPSHOT_SIGNATURE)+1:])
+	if err := gob.NewDecoder(r).Decode(&d); err != nil {
+		return fmt.Errorf("snapshot: %v", err)
+	}
+	if d.Version != ls.g.version {
+		return fmt.Errorf("snapshot: saved by Lua version %d, state is %d", d.Version, ls.g.version)
+	}
+
+	rs := &restorer{ls: ls, d: &d}
+	if err := rs.create(); err != nil {
+		return err
+	}
+	if d.Random != nil { /* a failed UnmarshalBinary leaves the generator as it was */
+		if err := stdlib.RestoreRandom(ls, d.Random); err != nil {
+			return fmt.Errorf("snapshot: %v", err)
+		}
+	}
+	rs.fill()
+	return nil
+}
+
+type restorer struct {
+	ls     *luaState
+	d      *snapData
+	objs   []luaValue
+	uvs    []*upvalue
+	frames map[int][]*luaStack
+}
+
+// 先创建所有对象, 出错时目标状态还没有被修改
+func (rs *restorer) create() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("snapshot: %v", r)
+		}
+	}()
+	byName, _ := goFunctions(rs.ls)
+	protos := make([]*binchunk.Prototype, len(rs.d.Protos))
+	for i, chunk := range rs.d.Protos {
+		protos[i] = binchunk.Undump(chunk)
+	}
+	if rs.d.Registry < 0 || rs.d.Registry >= len(rs.d.Objects) {
+		return fmt.Errorf("snapshot: corrupted")
+	}
+
+	rs.objs = make([]luaValue, len(rs.d.Objects))
+	for i, o := range rs.d.Objects {
+		switch o.Kind {
+		case _SO_TABLE:
+			if i == rs.d.Registry {
+				rs.objs[i] = rs.ls.registry
+			} else {
+				rs.objs[i] = newLuaTable(len(o.Arr), len(o.Keys))
+			}
+		case _SO_LUACLOSURE:
+			rs.objs[i] = newLuaClosure(protos[o.Proto])
+		case _SO_GOCLOSURE:
+			var f api.GoFunction
+			for _, name := range o.GoNames {
+				if f = byName[name]; f != nil {
+					break
+				}
+			}
+			if f == nil {
+				return fmt.Errorf("snapshot: unknown Go function '%s'", strings.Join(o.GoNames, "', '"))
+			}
+			rs.objs[i] = newGoClosure(f, len(o.Upvals))
+		case _SO_USERDATA:
+			u := newUserdata(nil)
+			if o.HasData {
+				var box udBox
+				if err := gob.NewDecoder(bytes.NewReader(o.Data)).Decode(&box); err != nil {
+					return fmt.Errorf("snapshot: cannot restore userdata: %v", err)
+				}
+				u.data = box.V
+			}
+			rs.objs[i] = u
+		case _SO_THREAD:
+			rs.objs[i] = &luaState{registry: rs.ls.registry, g: rs.ls.g}
+		case _SO_MAINTHREAD:
+			rs.objs[i] = rs.ls
+		default:
+			return fmt.Errorf("snapshot: corrupted")
+		}
+	}
+	return rs.check()
+}
+
+// 检查 fill 用到的所有编号和类型, 通过之后 fill 不会出错
+func (rs *restorer) check() error {
+	d := rs.d
+	corrupted := fmt.Errorf("snapshot: corrupted")
+	if d.Objects[d.Registry].Kind != _SO_TABLE {
+		return corrupted
+	}
+	var threadSize int64
+	for i, o := range d.Objects {
+		ok := true
+		switch x := rs.objs[i].(type) {
+		case *luaTable:
+			ok = rs.validMeta(o.Meta) && len(o.Keys) == len(o.Vals)
+			for _, v := range o.Arr {
+				ok = ok && rs.validValue(v)
+			}
+			for j, k := range o.Keys {
+				ok = ok && rs.validKey(k) && rs.validValue(o.Vals[j])
+			}
+		case *closure:
+			ok = len(o.Upvals) <= len(x.upvals)
+			for _, id := range o.Upvals {
+				ok = ok && id >= -1 && id < len(d.Upvals)
+			}
+		case *userdata:
+			ok = rs.validMeta(o.Meta) && rs.validValue(o.UserValue)
+		case *luaState:
+			if x != rs.ls {
+				ok = rs.validThread(o.Thread)
+				if ok {
+					threadSize += sizeThread + int64(o.Thread.Frames[0].Size)*sizeTValue
+				}
+			}
+		}
+		if !ok {
+			return corrupted
+		}
+	}
+	for _, su := range d.Upvals {
+		if !su.Open {
+			if !rs.validValue(su.Val) {
+				return corrupted
+			}
+			continue
+		}
+		if su.Thread < 0 || su.Thread >= len(d.Objects) || d.Objects[su.Thread].Kind != _SO_THREAD {
+			return corrupted
+		}
+		frames := d.Objects[su.Thread].Thread.Frames
+		if su.Frame < 0 || su.Frame >= len(frames) || su.Slot < 0 || su.Slot >= frames[su.Frame].Size {
+			return corrupted
+		}
+	}
+	if !rs.ls.g.tryAllocate(threadSize) {
+		return fmt.Errorf("snapshot: not enough memory")
+	}
+	return nil
+}
+
+func (rs *restorer) validThread(st *snapThread) bool {
+	if st == nil || len(st.Frames) == 0 || !rs.validValue(st.Err) {
+		return false
+	}
+	for fi, sf := range st.Frames {
+		if sf.Size < len(sf.Slots) || sf.Size > api.LUAI_MAXSTACK {
+			return false
+		}
+		for _, v := range sf.Slots {
+			if !rs.validValue(v) {
+				return false
+			}
+		}
+		for _, v := range sf.Varargs {
+			if !rs.validValue(v) {
+				return false
+			}
+		}
+		for _, slot := range sf.Tbcs {
+			if slot < 0 || slot >= sf.Size {
+				return false
+			}
+		}
+		for slot, id := range sf.OpenUvs {
+			if slot < 0 || slot >= sf.Size || id < 0 || id >= len(rs.d.Upvals) {
+				return false
+			}
+		}
+		var c *closure
+		if sf.Closure != -1 {
+			var ok bool
+			if c, ok = rs.ref(sf.Closure).(*closure); !ok {
+				return false
+			}
+		}
+		if fi > 0 && st.Status == api.LUA_YIELD { /* resumed by 'resumeRestored' */
+			if c == nil || c.proto == nil || sf.PC < 1 || sf.PC > len(c.proto.Code) {
+				return false
+			}
+			if _, ok := vm.CallResults(vm.Instruction(c.proto.Code[sf.PC-1])); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (rs *restorer) ref(id int) luaValue {
+	if id < 0 || id >= len(rs.objs) {
+		return nil
+	}
+	return rs.objs[id]
+}
+
+func (rs *restorer) validValue(sv snapValue) bool {
+	switch sv.Kind {
+	case _SV_NIL, _SV_FALSE, _SV_TRUE, _SV_INT, _SV_FLOAT, _SV_STRING:
+		return true
+	case _SV_REF:
+		return rs.ref(sv.Ref) != nil
+	}
+	return false
+}
+
+// 表的键不能是 nil 和 NaN
+func (rs *restorer) validKey(sv snapValue) bool {
+	return sv.Kind != _SV_NIL && !(sv.Kind == _SV_FLOAT && math.IsNaN(sv.F)) && rs.validValue(sv)
+}
+
+func (rs *restorer) validMeta(meta int) bool {
+	if meta == 0 {
+		return true
+	}
+	_, ok := rs.ref(meta - 1).(*luaTable)
+	return ok
+}
+
+func (rs *restorer) fill() {
+	ls := rs.ls
+	/* threads first: open upvalues point into their frames */
+	rs.frames = map[int][]*luaStack{}
+	for i, o := range rs.d.Objects {
+		if o.Kind == _SO_THREAD {
+			rs.fillThread(rs.objs[i].(*luaState), i, o.Thread)
+		}
+	}
+	rs.uvs = make([]*upvalue, len(rs.d.Upvals))
+	for i, su := range rs.d.Upvals {
+		if su.Open {
+			frame := rs.frames[su.Thread][su.Frame]
+			rs.uvs[i] = &upvalue{&frame.slots[su.Slot]}
+		} else {
+			val := rs.value(su.Val)
+			rs.uvs[i] = &upvalue{&val}
+		}
+	}
+	for obj, frames := range rs.frames {
+		for fi, frame := range frames {
+			for slot, id := range rs.d.Objects[obj].Thread.Frames[fi].OpenUvs {
+				if frame.openuvs == nil {
+					frame.openuvs = map[int]*upvalue{}
+				}
+				frame.openuvs[slot] = rs.uvs[id]
+			}
+		}
+	}
+
+	/* keep the host hooks of this state */
+	hooks := map[luaValue]luaValue{}
+	for k, v := range ls.registry._map {
+		if isHostHook(k, v) {
+			hooks[k] = v
+		}
+	}
+	ls.registry.arr, ls.registry._map, ls.registry.keys = nil, nil, nil
+
+	for i, o := range rs.d.Objects {
+		switch x := rs.objs[i].(type) {
+		case *luaTable:
+			for j, v := range o.Arr {
+				x.put(int64(j+1), rs.value(v))
+			}
+			for j, k := range o.Keys {
+				x.put(rs.value(k), rs.value(o.Vals[j]))
+			}
+			if o.Meta != 0 {
+				setMetatable(x, rs.objs[o.Meta-1].(*luaTable), ls)
+			}
+		case *closure:
+			for j, id := range o.Upvals {
+				if id >= 0 {
+					x.upvals[j] = rs.uvs[id]
+				}
+			}
+		case *userdata:
+			x.uservalue = rs.value(o.UserValue)
+			if o.Meta != 0 {
+				setMetatable(x, rs.objs[o.Meta-1].(*luaTable), ls)
+			}
+		}
+	}
+
+	for k, v := range hooks {
+		ls.registry.put(k, v)
+	}
+	ls.registry.put(api.LUA_RIDX_MAINTHREAD, ls)
+}
+
+func (rs *restorer) fillThread(t *luaState, id int, st *snapThread) {
+	var frames []*luaStack
+	for _, sf := range st.Frames {
+		frame := newLuaStack(sf.Size, t)
+		for i, v := range sf.Slots {
+			frame.slots[i] = rs.value(v)
+		}
+		frame.top = len(sf.Slots)
+		if sf.Closure >= 0 {
+			frame.closure = rs.objs[sf.Closure].(*closure)
+		}
+		for _, v := range sf.Varargs {
+			frame.varargs = append(frame.varargs, rs.value(v))
+		}
+		frame.pc = sf.PC
+		frame.tbcs = sf.Tbcs
+		frames = append(frames, frame)
+	}
+	rs.frames[id] = frames
+	t.pushLuaStack(frames[0])
+	t.coStatus = st.Status
+	t.coErr = rs.value(st.Err)
+	if st.Status == api.LUA_YIELD {
+		t.restored = frames[1:]
+	}
+}
+
+func (rs *restorer) value(sv snapValue) luaValue {
+	switch sv.Kind {
+	case _SV_FALSE:
+		return false
+	case _SV_TRUE:
+		return true
+	case _SV_INT:
+		return sv.I
+	case _SV_FLOAT:
+		return sv.F
+	case _SV_STRING:
+		return sv.S
+	case _SV_REF:
+		return rs.objs[sv.Ref]
+	}
+	return nil
+}
+
+/*
+** Continue a coroutine restored from a snapshot. It runs as a Go function
+** at the bottom of the coroutine; the saved Lua frames are pushed above it
+** and each one finishes the call it was suspended in, as 'callLuaClosure'
+** would have done, then keeps running.
+ */
+func resumeRestored(L api.LuaState) int {
+	ls := L.(*luaState)
+	frames := ls.restored
+	ls.restored = nil
+	caller := ls.stack
+	results := caller.popN(caller.top) /* values passed to 'resume' */
+	for _, frame := range frames {
+		ls.pushLuaStack(frame)
+	}
+	for ls.stack != caller {
+		frame := ls.stack
+		inst := vm.Instruction(frame.closure.proto.Code[frame.pc-1])
+		nResults, _ := vm.CallResults(inst)
+		frame.check(len(results))
+		frame.pushN(results, nResults)
+		vm.FinishCall(inst, ls)
+		ls.runLuaClosure()
+		ls.popLuaStack()
+		results = frame.popN(frame.top - int(frame.closure.proto.MaxStackSize))
+	}
+	caller.check(len(results))
+	caller.pushN(results, -1)
+	return len(results)
+}
+
+func isResumeRestored(c *closure) bool {
+	return c != nil && c.goFunc != nil && funcPointer(c.goFunc) == funcPointer(resumeRestored)
+}
+
+// 按名字查找 Go 函数: 先是注册的名字, 然后是已加载模块里的函数, 全局变量 (_G) 最后.
+// 同一个函数可以有多个名字, names 按这个顺序列出
+func goFunctions(ls *luaState) (byName map[string]api.GoFunction, names map[uintptr][]string) {
+	byName = map[string]api.GoFunction{}
+	names = map[uintptr][]string{}
+	add := func(name string, f api.GoFunction) {
+		if _, ok := byName[name]; ok {
+			return
+		}
+		byName[name] = f
+		names[funcPointer(f)] = append(names[funcPointer(f)], name)
+	}
+
+	_goFuncNames.RLock()
+	for _, name := range sortedNames(_goFuncNames.m) {
+		add(name, _goFuncNames.m[name])
+	}
+	_goFuncNames.RUnlock()
+
+	loaded, _ := ls.registry.get(stdlib.LUA_LOADED_TABLE).(*luaTable)
+	if loaded == nil {
+		return
+	}
+	modules := map[*luaTable]bool{} /* named by their own module name */
+	for _, v := range loaded._map {
+		if t, ok := v.(*luaTable); ok {
+			modules[t] = true
+		}
+	}
+	var walk func(t *luaTable, prefix string, depth int)
+	walk = func(t *luaTable, prefix string, depth int) {
+		fields := map[string]luaValue{}
+		for k, v := range t._map {
+			if s, ok := k.(string); ok {
+				fields[s] = v
+			}
+		}
+		for i, v := range t.arr {
+			fields[fmt.Sprint(i+1)] = v
+		}
+		keys := sortedNames(fields)
+		if t == loaded { /* globals may alias library functions */
+			sort.SliceStable(keys, func(i, j int) bool { return keys[j] == "_G" && keys[i] != "_G" })
+		}
+		for _, key := range keys {
+			switch x := fields[key].(type) {
+			case *closure:
+				if x.goFunc != nil {
+					add(prefix+key, x.goFunc)
+				}
+			case *luaTable:
+				if depth > 0 && (t == loaded || !modules[x]) {
+					walk(x, prefix+key+".", depth-1)
+				}
+			}
+		}
+	}
+	walk(loaded, "", 2)
+	return
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Go 函数的标识是函数值指向的闭包对象, 而不是代码地址:
+// 同一个函数字面量创建的不同闭包 (比如 stdlib.LibOpener 的返回值) 代码地址相同, 闭包对象不同
+func funcPointer(f api.GoFunction) uintptr {
+	return uintptr(*(*unsafe.Pointer)(unsafe.Pointer(&f)))
+}
+
+func keyString(k luaValue) string {
+	if s, ok := k.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("[%v]", k)
+}
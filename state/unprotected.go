@@ -0,0 +1,137 @@
+package state
+
+import (
+	"fmt"
+	"luago/api"
+)
+
+// 宿主直接调用的函数出错时的处理方式
+const (
+	ErrorPanic  = iota // 调用 AtPanic 设置的函数, 然后以 *api.LuaError 为值 panic
+	ErrorReturn        // Call 出错时弹出函数和参数, 补足 nil 作为结果后返回, 错误由 Err 取得
+)
+
+// 设置不受保护的错误的处理函数, 返回原来的; nil 表示没有处理函数.
+// 处理函数以错误对象为栈顶被调用, 返回后错误继续以 *api.LuaError 传给宿主
+func (ls *luaState) AtPanic(panicf api.GoFunction) api.GoFunction {
+	old := ls.g.panicf
+	ls.g.panicf = panicf
+	return old
+}
+
+// ErrorReturn 只影响 Call 和 CallK, 其他 API 直接出错仍然 panic
+func (ls *luaState) SetErrorMode(mode int) {
+	ls.g.errorMode = mode
+}
+
+// 返回并清除这个线程上一次在 ErrorReturn 模式下 Call 出的错, 没有时返回 nil
+func (ls *luaState) Err() error {
+	err := ls.err
+	ls.err = nil
+	if err == nil {
+		return nil
+	}
+	return err
+}
+
+// 宿主直接调用 API, 不在任何函数和 PCall 里
+func (ls *luaState) unprotected() bool {
+	return ls.stack.prev == nil && ls.nPCalls == 0
+}
+
+/*
+** 弹出出错的栈帧, 让状态还可以继续使用. 在 Call 之外的 API
+** 出错时由 defer 调用
+ */
+func (ls *luaState) unprotectedError(base *luaStack) {
+	if r := recover(); r != nil {
+		ls.throw(ls.newLuaError(base, r))
+	}
+}
+
+// top 是调用前函数所在的位置
+func (ls *luaState) unprotectedCall(base *luaStack, top, nResults int) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	err := ls.newLuaError(base, r)
+	if ls.g.errorMode != ErrorReturn {
+		ls.throw(err)
+	}
+	ls.SetTop(top)
+	for i := 0; i < nResults; i++ {
+		ls.stack.push(nil)
+	}
+	ls.err = err
+}
+
+func (ls *luaState) throw(err *api.LuaError) {
+	if panicf := ls.g.panicf; panicf != nil {
+		ls.stack.push(err.Value)
+		panicf(ls)
+		ls.stack.pop()
+	}
+	panic(err)
+}
+
+// 调用栈要在弹出栈帧之前记下
+func (ls *luaState) newLuaError(base *luaStack, r interface{}) *api.LuaError {
+	if err, ok := r.(*api.LuaError); ok {
+		return err
+	}
+	if _, ok := r.(memoryError); ok {
+		r = "not enough memory"
+	}
+	tb := ls.traceback("", 0)
+	val := ls.unwind(base, errorValue(r))
+	return &api.LuaError{Value: val, Message: ls.errorMessage(val), Traceback: tb}
+}
+
+// 和 lua.c 的 msghandler 一样转换错误对象
+func (ls *luaState) errorMessage(val luaValue) string {
+	switch x := val.(type) {
+	case string:
+		return x
+	case int64, float64:
+		return ls.numberToString(x)
+	case error:
+		return x.Error()
+	}
+	if mf := getMetafield(val, "__tostring", ls); mf != nil {
+		ls.stack.push(mf)
+		ls.stack.push(val)
+		if ls.PCall(1, 1, 0) == api.LUA_OK {
+			s, ok := ls.ToStringX(-1)
+			ls.Pop(1)
+			if ok {
+				return s
+			}
+		} else {
+			ls.Pop(1)
+		}
+	}
+	return fmt.Sprintf("(error object is a %s value)", ls.objTypeName(val))
+}
+
+// 和 luaG_runerror 一样, 当前函数是 Lua 函数时在消息前加上位置
+func (ls *luaState) runError(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if c := ls.stack.closure; c != nil && c.proto != nil {
+		if line := currentLine(ls.stack); line > 0 {
+			msg = fmt.Sprintf("%s:%d: %s", chunkID(c.proto.Source), line, msg)
+		}
+	}
+	panic(msg)
+}
+
+// 和 luaT_objtypename 一样, 表和用户数据优先使用元表的 __name
+func (ls *luaState) objTypeName(val luaValue) string {
+	switch val.(type) {
+	case *luaTable, *userdata:
+		if name, ok := getMetafield(val, "__name", ls).(string); ok {
+			return name
+		}
+	}
+	return ls.TypeName(typeOf(val))
+}
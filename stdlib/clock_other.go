@@ -0,0 +1,8 @@
+//go:build !unix
+
+package stdlib
+
+// 没有 getrusage 时退化为进程启动以来经过的时间
+func _cpuTime() float64 {
+	return _wallClock()
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package stdlib
+
+import "syscall"
+
+// 进程使用的 CPU 时间, 相当于 clock()/CLOCKS_PER_SEC
+func _cpuTime() float64 {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return _wallClock()
+	}
+	return _timevalSeconds(ru.Utime) + _timevalSeconds(ru.Stime)
+}
+
+func _timevalSeconds(tv syscall.Timeval) float64 {
+	sec, usec := tv.Unix()
+	return float64(sec) + float64(usec)/1e9
+}
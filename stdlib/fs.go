@@ -0,0 +1,116 @@
+package stdlib
+
+import "errors"
+import "io"
+import "io/fs"
+import "os"
+import "path"
+import "path/filepath"
+import "strings"
+import "luago/api"
+
+// 注册表里保存宿主挂上来的文件系统
+const LUA_FILESYSTEMS = "_FILESYSTEMS"
+
+// require, loadfile, dofile 查找文件时依次使用的文件系统
+// Name 只用在错误信息里, 可以为空
+type FileSystem struct {
+	Name string
+	FS   fs.FS
+}
+
+// 直接访问操作系统的文件系统, 路径不做任何转换
+var OSFileSystem = FileSystem{FS: osFS{}}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+// 替换整个查找顺序, OSFileSystem 可以放在任意位置, 不放就完全不访问磁盘
+func SetFileSystems(ls api.LuaState, fss ...FileSystem) {
+	list := append([]FileSystem(nil), fss...)
+	ls.NewUserdata(&list)
+	ls.SetField(api.LUA_REGISTRYINDEX, LUA_FILESYSTEMS)
+}
+
+// 追加到查找顺序的末尾, 默认的顺序里只有 OSFileSystem
+func AddFS(ls api.LuaState, name string, fsys fs.FS) {
+	list := _fileSystems(ls)
+	*list = append(*list, FileSystem{Name: name, FS: fsys})
+}
+
+func _fileSystems(ls api.LuaState) *[]FileSystem {
+	ls.GetField(api.LUA_REGISTRYINDEX, LUA_FILESYSTEMS)
+	list, ok := ls.ToUserdata(-1).(*[]FileSystem)
+	ls.Pop(1)
+	if !ok {
+		SetFileSystems(ls, OSFileSystem)
+		return _fileSystems(ls)
+	}
+	return list
+}
+
+// 以只读方式打开文件, 返回第一个能打开它的文件系统里的文件
+func OpenFile(ls api.LuaState, filename string) (fs.File, error) {
+	for _, fsys := range *_fileSystems(ls) {
+		if f, err := _open(fsys, filename); err == nil {
+			return f, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: filename, Err: fs.ErrNotExist}
+}
+
+func ReadFile(ls api.LuaState, filename string) ([]byte, error) {
+	f, err := OpenFile(ls, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func _open(fsys FileSystem, filename string) (fs.File, error) {
+	if _, ok := fsys.FS.(osFS); ok {
+		return fsys.FS.Open(filename)
+	}
+	name, ok := _fsPath(filename)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: filename, Err: fs.ErrInvalid}
+	}
+	f, err := fsys.FS.Open(name)
+	if err == nil {
+		if st, err2 := f.Stat(); err2 == nil && st.IsDir() {
+			f.Close()
+			return nil, &fs.PathError{Op: "open", Path: filename, Err: errors.New("is a directory")}
+		}
+	}
+	return f, err
+}
+
+// fs.FS 只接受 "a/b/c" 形式的路径, 把 "./a/b.lua" 之类的去掉前缀
+func _fsPath(filename string) (string, bool) {
+	name := path.Clean(filepath.ToSlash(filename))
+	name = strings.TrimLeft(name, "/")
+	return name, name != "" && name != "." && fs.ValidPath(name)
+}
+
+// 和 readable 一样, 能打开就算找到了
+func _readable(fsys FileSystem, filename string) bool {
+	f, err := _open(fsys, filename)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+func _statFile(ls api.LuaState, filename string) (fs.FileInfo, error) {
+	f, err := OpenFile(ls, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
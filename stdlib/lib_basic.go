@@ -8,32 +8,41 @@ import (
 )
 
 var baseFuncs = map[string]api.GoFunction{
-	"print":        basePrint,
-	"assert":       baseAssert,
-	"error":        baseError,
-	"select":       baseSelect,
-	"ipairs":       baseIPairs,
-	"pairs":        basePairs,
-	"next":         baseNext,
-	"load":         baseLoad,
-	"loadfile":     baseLoadFile,
-	"dofile":       baseDoFile,
-	"pcall":        basePCall,
-	"xpcall":       baseXPCall,
-	"getmetatable": baseGetMetatable,
-	"setmetatable": baseSetMetatable,
-	"rawequal":     baseRawEqual,
-	"rawlen":       baseRawLen,
-	"rawget":       baseRawGet,
-	"rawset":       baseRawSet,
-	"type":         baseType,
-	"tostring":     baseToString,
-	"tonumber":     baseToNumber,
+	"print":          basePrint,
+	"assert":         baseAssert,
+	"error":          baseError,
+	"select":         baseSelect,
+	"ipairs":         baseIPairs,
+	"pairs":          basePairs,
+	"next":           baseNext,
+	"load":           baseLoad,
+	"loadfile":       baseLoadFile,
+	"dofile":         baseDoFile,
+	"pcall":          basePCall,
+	"xpcall":         baseXPCall,
+	"getmetatable":   baseGetMetatable,
+	"setmetatable":   baseSetMetatable,
+	"rawequal":       baseRawEqual,
+	"rawlen":         baseRawLen,
+	"rawget":         baseRawGet,
+	"rawset":         baseRawSet,
+	"type":           baseType,
+	"tostring":       baseToString,
+	"tonumber":       baseToNumber,
+	"collectgarbage": baseCollectGarbage,
 
 	"_G":       nil,
 	"_VERSION": nil,
 }
 
+// 5.1 兼容模式下才有的函数
+var base51Funcs = map[string]api.GoFunction{
+	"getfenv":    baseGetFenv,
+	"setfenv":    baseSetFenv,
+	"unpack":     tabUnpack,
+	"loadstring": baseLoadString,
+}
+
 func basePrint(ls api.LuaState) int {
 	n := ls.GetTop() /* number of arguments */
 	ls.GetGlobal("tostring")
@@ -71,9 +80,9 @@ func baseError(ls api.LuaState) int {
 	level := int(ls.OptInteger(2, 1))
 	ls.SetTop(1)
 	if ls.Type(1) == api.LUA_TSTRING && level > 0 {
-		// ls.Where(level) /* add extra information */
-		// ls.PushValue(1)
-		// ls.Concat(2)
+		ls.Where(level) /* add extra information */
+		ls.PushValue(1)
+		ls.Concat(2)
 	}
 	return ls.Error()
 }
@@ -169,7 +178,7 @@ func loadAux(ls api.LuaState, status, envIdx int) int {
 
 func baseLoadFile(ls api.LuaState) int {
 	fname := ls.OptString(1, "")
-	mode := ls.OptString(1, "bt")
+	mode := ls.OptString(2, "bt")
 	env := 0 /* 'env' index or 0 if no 'env' */
 	if !ls.IsNone(3) {
 		env = 3
@@ -188,16 +197,43 @@ func baseDoFile(ls api.LuaState) int {
 	return ls.GetTop() - 1
 }
 
+/*
+** Continuation function for 'pcall' and 'xpcall'. Both functions
+** already pushed a 'true' before doing the call, so in case of success
+** 'finishPCall' only has to return everything in the stack minus
+** 'extra' values (where 'extra' is exactly the number of items to be
+** ignored).
+ */
+func finishPCall(ls api.LuaState, status int, extra api.KContext) int {
+	if status != api.LUA_OK && status != api.LUA_YIELD { /* error? */
+		ls.PushBoolean(false) /* first result (false) */
+		ls.PushValue(-2)      /* error message */
+		return 2              /* return false, msg */
+	}
+	return ls.GetTop() - extra /* return all results */
+}
+
 func basePCall(ls api.LuaState) int {
-	nArgs := ls.GetTop() - 1
-	status := ls.PCall(nArgs, -1, 0)
-	ls.PushBoolean(status == api.LUA_OK)
-	ls.Insert(1)
-	return ls.GetTop()
+	ls.CheckAny(1)
+	ls.PushBoolean(true) /* first result if no errors */
+	ls.Insert(1)         /* put it in place */
+	status := ls.PCallK(ls.GetTop()-2, api.LUA_MULTRET, 0, 0, finishPCall)
+	return finishPCall(ls, status, 0)
 }
 
+/*
+** Do a protected call with error handling. After 'lua_rotate', the
+** stack will have <f, err, true, f, [args...]>; so, the function passes
+** 2 to 'finishpcall' to skip the 2 first values when returning results.
+ */
 func baseXPCall(ls api.LuaState) int {
-	panic("todo!")
+	n := ls.GetTop()
+	ls.CheckType(2, api.LUA_TFUNCTION) /* check error function */
+	ls.PushBoolean(true)               /* first result */
+	ls.PushValue(1)                    /* function */
+	ls.Rotate(3, 2)                    /* move them below function's arguments */
+	status := ls.PCallK(n-2, api.LUA_MULTRET, 2, 2, finishPCall)
+	return finishPCall(ls, status, 2)
 }
 
 func baseGetMetatable(ls api.LuaState) int {
@@ -277,7 +313,7 @@ func baseToNumber(ls api.LuaState) int {
 			return 1
 		} else {
 			if s, ok := ls.ToStringX(1); ok {
-				if ls.StringToNumber(s) {
+				if ls.StringToNumber(s) == len(s)+1 {
 					return 1 /* successful conversion to number */
 				} /* else not a number */
 			}
@@ -296,12 +332,120 @@ func baseToNumber(ls api.LuaState) int {
 	return 1
 }
 
+func baseCollectGarbage(ls api.LuaState) int {
+	opts := []string{"stop", "restart", "collect",
+		"count", "step", "setpause", "setstepmul",
+		"isrunning", "generational", "incremental"}
+	optsNum := []int{api.LUA_GCSTOP, api.LUA_GCRESTART, api.LUA_GCCOLLECT,
+		api.LUA_GCCOUNT, api.LUA_GCSTEP, api.LUA_GCSETPAUSE, api.LUA_GCSETSTEPMUL,
+		api.LUA_GCISRUNNING, api.LUA_GCGEN, api.LUA_GCINC}
+	o := optsNum[ls.CheckOption(1, "collect", opts)]
+	switch o {
+	case api.LUA_GCCOUNT:
+		k := ls.GC(o)
+		b := ls.GC(api.LUA_GCCOUNTB)
+		ls.PushNumber(float64(k) + float64(b)/1024)
+		return 1
+	case api.LUA_GCSTEP:
+		step := int(ls.OptInteger(2, 0))
+		ls.PushBoolean(ls.GC(o, step) != 0)
+		return 1
+	case api.LUA_GCSETPAUSE, api.LUA_GCSETSTEPMUL:
+		p := int(ls.OptInteger(2, 0))
+		ls.PushInteger(int64(ls.GC(o, p)))
+		return 1
+	case api.LUA_GCISRUNNING:
+		ls.PushBoolean(ls.GC(o) != 0)
+		return 1
+	case api.LUA_GCGEN:
+		minorMul := int(ls.OptInteger(2, 0))
+		majorMul := int(ls.OptInteger(3, 0))
+		return pushGCMode(ls, ls.GC(o, minorMul, majorMul))
+	case api.LUA_GCINC:
+		pause := int(ls.OptInteger(2, 0))
+		stepMul := int(ls.OptInteger(3, 0))
+		stepSize := int(ls.OptInteger(4, 0))
+		return pushGCMode(ls, ls.GC(o, pause, stepMul, stepSize))
+	default:
+		ls.PushInteger(int64(ls.GC(o)))
+		return 1
+	}
+}
+
+func pushGCMode(ls api.LuaState, oldMode int) int {
+	if oldMode == api.LUA_GCGEN {
+		ls.PushString("generational")
+	} else {
+		ls.PushString("incremental")
+	}
+	return 1
+}
+
 func OpenBaseLib(ls api.LuaState) int {
 	ls.PushGlobalTable()
 	ls.SetFuncs(baseFuncs, 0)
 	ls.PushValue(-1)
 	ls.SetField(-2, "_G")
-	ls.PushString("Lua 5.3")
+	if ls.Version() == api.LUA_VERSION_51 {
+		ls.SetFuncs(base51Funcs, 0)
+		ls.PushString("Lua 5.1")
+	} else {
+		ls.PushString("Lua 5.3")
+	}
 	ls.SetField(-2, "_VERSION")
 	return 1
 }
+
+// 5.1: getfenv ([f])
+func baseGetFenv(ls api.LuaState) int {
+	getFunc(ls, true)
+	if ls.IsGoFunction(-1) { /* is a Go function? */
+		ls.PushGlobalTable() /* return the thread's global env. */
+	} else {
+		ls.GetFenv(-1)
+	}
+	return 1
+}
+
+// 5.1: setfenv (f, table)
+func baseSetFenv(ls api.LuaState) int {
+	ls.CheckType(2, api.LUA_TTABLE)
+	getFunc(ls, false)
+	ls.PushValue(2)
+	if n, ok := ls.ToNumberX(1); ok && n == 0 {
+		/* change environment of current thread */
+		ls.PushThread()
+		ls.Insert(-2)
+		if !ls.SetFenv(-2) {
+			return ls.Error2("'setfenv' cannot change environment of the current thread")
+		}
+		return 0
+	} else if ls.IsGoFunction(-2) || !ls.SetFenv(-2) {
+		return ls.Error2("'setfenv' cannot change environment of given object")
+	}
+	return 1
+}
+
+func getFunc(ls api.LuaState, opt bool) {
+	if ls.IsFunction(1) {
+		ls.PushValue(1)
+		return
+	}
+	var level int64
+	if opt {
+		level = ls.OptInteger(1, 1)
+	} else {
+		level = ls.CheckInteger(1)
+	}
+	ls.ArgCheck(level >= 0, 1, "level must be non-negative")
+	if !ls.PushStackFunction(int(level)) {
+		ls.ArgError(1, "invalid level")
+	}
+}
+
+// 5.1: loadstring (string [, chunkname])
+func baseLoadString(ls api.LuaState) int {
+	ls.CheckString(1)
+	ls.SetTop(2) /* no 'mode' and 'env' */
+	return baseLoad(ls)
+}
@@ -10,8 +10,18 @@ var coFuncs = map[string]api.GoFunction{
 	"isyieldable": coYieldable, // coroutine.isyieldable ()
 	"running":     coRunning,   // coroutine.running ()
 	"wrap":        coWrap,      // coroutine.wrap (f)
+	"close":       coClose,     // coroutine.close (co)
 }
 
+const (
+	_COS_RUN   = 0
+	_COS_DEAD  = 1
+	_COS_YIELD = 2
+	_COS_NORM  = 3
+)
+
+var statName = []string{"running", "dead", "suspended", "normal"}
+
 func coCreate(ls api.LuaState) int {
 	ls.CheckType(1, api.LUA_TFUNCTION)
 	ls2 := ls.NewThread()
@@ -69,25 +79,46 @@ func coYield(ls api.LuaState) int {
 func coStatus(ls api.LuaState) int {
 	co := ls.ToThread(1)
 	ls.ArgCheck(co != nil, 1, "thread expected")
+	ls.PushString(statName[_auxStatus(ls, co)])
+	return 1
+}
+
+func _auxStatus(ls, co api.LuaState) int {
 	if ls == co {
-		ls.PushString("running")
-	} else {
-		switch co.Status() {
-		case api.LUA_YIELD:
-			ls.PushString("suspended")
-		case api.LUA_OK:
-			if co.GetStack() {
-				ls.PushString("normal")
-			} else if co.GetTop() == 0 {
-				ls.PushString("dead")
-			} else {
-				ls.PushString("suspended")
-			}
-		default:
-			ls.PushString("dead")
+		return _COS_RUN
+	}
+	switch co.Status() {
+	case api.LUA_YIELD:
+		return _COS_YIELD
+	case api.LUA_OK:
+		if co.GetStack() { /* does it have frames? */
+			return _COS_NORM /* it is running */
+		} else if co.GetTop() == 0 {
+			return _COS_DEAD
+		} else {
+			return _COS_YIELD /* initial state */
 		}
+	default: /* some error occurred */
+		return _COS_DEAD
+	}
+}
+
+func coClose(ls api.LuaState) int {
+	co := ls.ToThread(1)
+	ls.ArgCheck(co != nil, 1, "thread expected")
+	switch status := _auxStatus(ls, co); status {
+	case _COS_DEAD, _COS_YIELD:
+		if co.CloseThread(ls) == api.LUA_OK {
+			ls.PushBoolean(true)
+			return 1
+		} else {
+			ls.PushBoolean(false)
+			co.XMove(ls, 1) /* move error message */
+			return 2
+		}
+	default: /* normal or running coroutine */
+		return ls.Error2("cannot close a %s coroutine", statName[status])
 	}
-	return 1
 }
 
 func OpenCoroutineLib(ls api.LuaState) int {
@@ -107,5 +138,25 @@ func coRunning(ls api.LuaState) int {
 }
 
 func coWrap(ls api.LuaState) int {
-	panic("todo: coWrap!")
+	coCreate(ls)
+	ls.PushGoClosure(_auxWrap, 1)
+	return 1
+}
+
+func _auxWrap(ls api.LuaState) int {
+	co := ls.ToThread(api.LuaUpvalueIndex(1))
+	if r := _auxResume(ls, co, ls.GetTop()); r >= 0 {
+		return r
+	}
+	/* error */
+	if stat := co.Status(); stat != api.LUA_OK && stat != api.LUA_YIELD { /* error in the coroutine? */
+		co.CloseThread(ls) /* the error object is already on the caller */
+		co.SetTop(0)
+	}
+	if ls.Type(-1) == api.LUA_TSTRING { /* error object is a string? */
+		ls.Where(1) /* get extra info, if available */
+		ls.Insert(-2)
+		ls.Concat(2)
+	}
+	return ls.Error() /* propagate error */
 }
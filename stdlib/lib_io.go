@@ -0,0 +1,423 @@
+package stdlib
+
+import "bufio"
+import "fmt"
+import "io"
+import "io/fs"
+import "strings"
+import "luago/api"
+
+// 文件句柄的元表在注册表里的名字
+const LUA_FILEHANDLE = "FILE*"
+
+/* maximum length of a numeral */
+const _MAXLENNUM = 200
+
+// 只读的 io 库, 文件都通过宿主挂上的文件系统打开 (见 OpenFile);
+// 没有标准输入输出, 也不能写文件
+var ioLib = map[string]api.GoFunction{
+	"close": fClose,
+	"lines": ioLines,
+	"open":  ioOpen,
+	"type":  ioType,
+}
+
+/*
+** methods for file handles
+ */
+var fileMethods = map[string]api.GoFunction{
+	"close": fClose,
+	"lines": fLines,
+	"read":  fRead,
+	"seek":  fSeek,
+}
+
+var fileMeta = map[string]api.GoFunction{
+	"__tostring": fToString,
+}
+
+type luaFile struct {
+	f      fs.File
+	r      *bufio.Reader
+	closed bool
+}
+
+func OpenIOLib(ls api.LuaState) int {
+	ls.NewLib(ioLib) /* new module */
+	createMeta(ls)
+	return 1
+}
+
+func createMeta(ls api.LuaState) {
+	ls.NewMetatable(LUA_FILEHANDLE) /* create metatable for file handles */
+	ls.SetFuncs(fileMeta, 0)        /* add metamethods to new metatable */
+	ls.NewLibTable(fileMethods)     /* create method table */
+	ls.SetFuncs(fileMethods, 0)     /* add file methods to method table */
+	ls.SetField(-2, "__index")      /* metatable.__index = method table */
+	ls.Pop(1)                       /* pop metatable */
+}
+
+func ioType(ls api.LuaState) int {
+	ls.CheckAny(1)
+	if p, ok := ls.TestUdata(1, LUA_FILEHANDLE).(*luaFile); !ok {
+		ls.PushNil() /* not a file */
+	} else if p.closed {
+		ls.PushString("closed file")
+	} else {
+		ls.PushString("file")
+	}
+	return 1
+}
+
+func fToString(ls api.LuaState) int {
+	p := ls.CheckUdata(1, LUA_FILEHANDLE).(*luaFile)
+	if p.closed {
+		ls.PushString("file (closed)")
+	} else {
+		ls.PushString(fmt.Sprintf("file (%p)", p))
+	}
+	return 1
+}
+
+func _toFile(ls api.LuaState) *luaFile {
+	p := ls.CheckUdata(1, LUA_FILEHANDLE).(*luaFile)
+	if p.closed {
+		ls.Error2("attempt to use a closed file")
+	}
+	return p
+}
+
+func fClose(ls api.LuaState) int {
+	p := _toFile(ls)
+	p.closed = true
+	return _fileResult(ls, p.f.Close(), "")
+}
+
+// lua-5.3.6/src/liolib.c#luaL_fileresult()
+func _fileResult(ls api.LuaState, err error, filename string) int {
+	if err == nil {
+		ls.PushBoolean(true)
+		return 1
+	}
+	ls.PushNil()
+	if filename != "" {
+		ls.PushString(filename + ": " + _errorText(err))
+	} else {
+		ls.PushString(_errorText(err))
+	}
+	return 2
+}
+
+// 去掉 fs.PathError 里重复的操作和路径
+func _errorText(err error) string {
+	if pe, ok := err.(*fs.PathError); ok {
+		return pe.Err.Error()
+	}
+	return err.Error()
+}
+
+// 只接受 "r" 和 "rb", 其他合法的模式需要写文件
+func ioOpen(ls api.LuaState) int {
+	filename := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	if !_checkMode(mode) {
+		return ls.ArgError(2, "invalid mode")
+	}
+	if mode[0] != 'r' || strings.Contains(mode, "+") {
+		return _fileResult(ls, fs.ErrPermission, filename)
+	}
+	return _openFile(ls, filename)
+}
+
+/*
+** Check whether 'mode' matches '[rwa]%+?b*'.
+ */
+func _checkMode(mode string) bool {
+	if mode == "" || strings.IndexByte("rwa", mode[0]) < 0 {
+		return false
+	}
+	mode = mode[1:]
+	if strings.HasPrefix(mode, "+") {
+		mode = mode[1:]
+	}
+	return strings.Trim(mode, "b") == ""
+}
+
+func _openFile(ls api.LuaState, filename string) int {
+	f, err := OpenFile(ls, filename)
+	if err != nil {
+		return _fileResult(ls, err, filename)
+	}
+	ls.NewUserdata(&luaFile{f: f, r: bufio.NewReader(f)})
+	ls.GetField(api.LUA_REGISTRYINDEX, LUA_FILEHANDLE)
+	ls.SetMetatable(-2)
+	return 1
+}
+
+/* maximum number of arguments to 'f:lines'/'io.lines' (it + 3 must fit in a stack slot) */
+const _MAXARGLINE = 250
+
+/*
+** Auxiliary function to create the iteration function for 'lines'.
+** The iteration function is a closure over '_ioReadline', with
+** the following upvalues:
+** 1) The file being read (first value in the stack)
+** 2) the number of arguments to read
+** 3) a boolean, true iff file has to be closed when finished ('toclose')
+** *) a variable number of format arguments (rest of the stack)
+ */
+func _auxLines(ls api.LuaState, toClose bool) {
+	n := ls.GetTop() - 1 /* number of arguments to read */
+	ls.ArgCheck(n <= _MAXARGLINE, _MAXARGLINE+2, "too many arguments")
+	ls.PushInteger(int64(n)) /* number of arguments to read */
+	ls.PushBoolean(toClose)  /* close/not close file when finished */
+	ls.Rotate(2, 2)          /* move 'n' and 'toclose' to their positions */
+	ls.PushGoClosure(_ioReadline, 3+n)
+}
+
+func fLines(ls api.LuaState) int {
+	_toFile(ls) /* check that it's a valid file handle */
+	_auxLines(ls, false)
+	return 1
+}
+
+// 必须给出文件名, 没有默认输入
+func ioLines(ls api.LuaState) int {
+	filename := ls.CheckString(1)
+	if _openFile(ls, filename) != 1 {
+		return ls.Error2("%s", ls.ToString(-1))
+	}
+	ls.Replace(1) /* put file at index 1 */
+	_auxLines(ls, true)
+	return 1
+}
+
+/*
+** Read a number: first reads a valid prefix of a numeral into a buffer.
+** Then it calls 'StringToNumber' to check whether the format is correct
+** and to convert it to a Lua number
+ */
+type _rn struct {
+	r    *bufio.Reader
+	c    int /* current character (look ahead) */
+	buff []byte
+}
+
+func (rn *_rn) next() bool {
+	if len(rn.buff) >= _MAXLENNUM { /* buffer overflow? */
+		rn.buff = rn.buff[:0] /* invalidate result */
+		return false          /* fail */
+	}
+	rn.buff = append(rn.buff, byte(rn.c)) /* save current char */
+	rn.c = _getc(rn.r)                    /* read next one */
+	return true
+}
+
+/*
+** Accept current char if it is in 'set' (of size 2)
+ */
+func (rn *_rn) test2(set string) bool {
+	if rn.c >= 0 && (rn.c == int(set[0]) || rn.c == int(set[1])) {
+		return rn.next()
+	}
+	return false
+}
+
+/*
+** Read a sequence of (hex)digits
+ */
+func (rn *_rn) readDigits(hex bool) int {
+	count := 0
+	for rn.c >= 0 && _isDigit(byte(rn.c), hex) && rn.next() {
+		count++
+	}
+	return count
+}
+
+func _readNumber(ls api.LuaState, r *bufio.Reader) bool {
+	rn := &_rn{r: r}
+	count, hex := 0, false
+	expo := "eE"
+	for rn.c = _getc(r); rn.c >= 0 && strings.IndexByte(" \t\n\v\f\r", byte(rn.c)) >= 0; rn.c = _getc(r) { /* skip spaces */
+	}
+	rn.test2("-+")      /* optional signal */
+	if rn.test2("00") { /* leading zero? */
+		if rn.test2("xX") {
+			hex = true /* numeral is hexadecimal */
+		} else {
+			count = 1 /* count initial '0' as a valid digit */
+		}
+	}
+	if hex {
+		expo = "pP"
+	}
+	count += rn.readDigits(hex) /* integral part */
+	if rn.test2("..") {         /* decimal point? */
+		count += rn.readDigits(hex) /* fractional part */
+	}
+	if count > 0 && rn.test2(expo) { /* exponent mark? */
+		rn.test2("-+")       /* exponent signal */
+		rn.readDigits(false) /* exponent digits */
+	}
+	if rn.c >= 0 {
+		r.UnreadByte() /* unread look-ahead char */
+	}
+	if ls.StringToNumber(string(rn.buff)) != 0 {
+		return true /* ok */
+	}
+	/* invalid format */
+	ls.PushNil() /* "result" to be removed */
+	return false /* read fails */
+}
+
+func _isDigit(c byte, hex bool) bool {
+	return '0' <= c && c <= '9' ||
+		hex && ('a' <= c && c <= 'f' || 'A' <= c && c <= 'F')
+}
+
+func _getc(r *bufio.Reader) int {
+	c, err := r.ReadByte()
+	if err != nil {
+		return -1
+	}
+	return int(c)
+}
+
+func _testEOF(ls api.LuaState, r *bufio.Reader) bool {
+	_, err := r.Peek(1)
+	ls.PushString("")
+	return err == nil
+}
+
+func _readLine(ls api.LuaState, r *bufio.Reader, chop bool) bool {
+	line, err := r.ReadString('\n')
+	if chop && strings.HasSuffix(line, "\n") {
+		line = line[:len(line)-1] /* chop '\n' */
+	}
+	ls.PushString(line)
+	/* return ok if read something (either a newline or something else) */
+	return err == nil || line != ""
+}
+
+func _readAll(ls api.LuaState, r *bufio.Reader) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		ls.Error2("%s", err.Error())
+	}
+	ls.PushString(string(b))
+}
+
+func _readChars(ls api.LuaState, r *bufio.Reader, n int64) bool {
+	b := make([]byte, n)
+	nr, _ := io.ReadFull(r, b)
+	ls.PushString(string(b[:nr]))
+	return nr > 0 /* true iff read something */
+}
+
+// lua-5.3.6/src/liolib.c#g_read()
+func _gRead(ls api.LuaState, r *bufio.Reader, first int) int {
+	nargs := ls.GetTop() - 1
+	success := true
+	n := first
+	if nargs == 0 { /* no arguments? */
+		success = _readLine(ls, r, true)
+		n = first + 1 /* to return 1 result */
+	} else { /* ensure stack space for all results and for auxlib's buffer */
+		ls.CheckStack2(nargs+api.LUA_MINSTACK, "too many arguments")
+		for ; nargs > 0 && success; n, nargs = n+1, nargs-1 {
+			if ls.Type(n) == api.LUA_TNUMBER {
+				l := ls.CheckInteger(n)
+				if l == 0 {
+					success = _testEOF(ls, r)
+				} else {
+					success = _readChars(ls, r, l)
+				}
+				continue
+			}
+			p := ls.CheckString(n)
+			p = strings.TrimPrefix(p, "*") /* skip optional '*' (for compatibility) */
+			if p == "" {
+				return ls.ArgError(n, "invalid format")
+			}
+			switch p[0] {
+			case 'n': /* number */
+				success = _readNumber(ls, r)
+			case 'l': /* line */
+				success = _readLine(ls, r, true)
+			case 'L': /* line with end-of-line */
+				success = _readLine(ls, r, false)
+			case 'a': /* file */
+				_readAll(ls, r) /* read entire file */
+				success = true  /* always success */
+			default:
+				return ls.ArgError(n, "invalid format")
+			}
+		}
+	}
+	if !success {
+		ls.Pop(1)    /* remove last result */
+		ls.PushNil() /* push nil instead */
+	}
+	return n - first
+}
+
+func fRead(ls api.LuaState) int {
+	return _gRead(ls, _toFile(ls).r, 2)
+}
+
+/*
+** Iteration function for 'lines'.
+ */
+func _ioReadline(ls api.LuaState) int {
+	p := ls.ToUserdata(api.LuaUpvalueIndex(1)).(*luaFile)
+	n := int(ls.ToInteger(api.LuaUpvalueIndex(2)))
+	if p.closed { /* file is already closed? */
+		return ls.Error2("file is already closed")
+	}
+	ls.SetTop(1)
+	ls.CheckStack2(n, "too many arguments")
+	for i := 1; i <= n; i++ { /* push arguments to 'g_read' */
+		ls.PushValue(api.LuaUpvalueIndex(3 + i))
+	}
+	n = _gRead(ls, p.r, 2) /* 'n' is number of results */
+	if ls.ToBoolean(-n) {  /* read at least one value? */
+		return n /* return them */
+	}
+	/* first result is nil: EOF or error */
+	if n > 1 { /* is there error information? */
+		/* 2nd result is error message */
+		return ls.Error2("%s", ls.ToString(-n+1))
+	}
+	if ls.ToBoolean(api.LuaUpvalueIndex(3)) { /* generator created file? */
+		ls.SetTop(0)
+		ls.PushValue(api.LuaUpvalueIndex(1))
+		p.closed = true
+		p.f.Close()
+	}
+	return 0
+}
+
+// 只有文件系统返回的文件实现了 io.Seeker 时才能移动
+func fSeek(ls api.LuaState) int {
+	p := _toFile(ls)
+	whence := map[string]int{"set": io.SeekStart, "cur": io.SeekCurrent, "end": io.SeekEnd}
+	op, ok := whence[ls.OptString(2, "cur")]
+	if !ok {
+		return ls.ArgError(2, "invalid option '"+ls.ToString(2)+"'")
+	}
+	offset := ls.OptInteger(3, 0)
+	s, ok := p.f.(io.Seeker)
+	if !ok {
+		return _fileResult(ls, fmt.Errorf("file is not seekable"), "")
+	}
+	if op == io.SeekCurrent {
+		offset -= int64(p.r.Buffered()) /* position of the reader, not the file */
+	}
+	pos, err := s.Seek(offset, op)
+	if err != nil {
+		return _fileResult(ls, err, "")
+	}
+	p.r.Reset(p.f)
+	ls.PushInteger(pos)
+	return 1
+}
@@ -4,7 +4,6 @@ import (
 	"luago/api"
 	"luago/number"
 	"math"
-	"math/rand"
 )
 
 var mathLib = map[string]api.GoFunction{
@@ -33,6 +32,11 @@ var mathLib = map[string]api.GoFunction{
 	"type":       mathType,       //
 }
 
+// 5.1 兼容模式下才有的函数
+var math51Lib = map[string]api.GoFunction{
+	"pow": mathPow, // 5.3 用 x^y
+}
+
 func OpenMathLib(ls api.LuaState) int {
 	ls.NewLib(mathLib)
 	ls.PushNumber(math.Pi)
@@ -43,18 +47,35 @@ func OpenMathLib(ls api.LuaState) int {
 	ls.SetField(-2, "maxinteger")
 	ls.PushInteger(math.MinInt64)
 	ls.SetField(-2, "mininteger")
+	if ls.Version() == api.LUA_VERSION_51 {
+		ls.SetFuncs(math51Lib, 0)
+	}
+	_randomSource(ls) /* each state gets its own generator */
+	return 1
+}
+
+func mathPow(ls api.LuaState) int {
+	x := ls.CheckNumber(1)
+	y := ls.CheckNumber(2)
+	ls.PushNumber(math.Pow(x, y))
 	return 1
 }
 
 func mathRandom(ls api.LuaState) int {
 	var low, up int64
+	src := _randomSource(ls)
+	rv := src.Uint64()
 	switch ls.GetTop() { /* check number of arguments */
 	case 0: /* no arguments */
-		ls.PushNumber(rand.Float64()) /* Number between 0 and 1 */
+		ls.PushNumber(_randFloat(rv)) /* Number between 0 and 1 */
 		return 1
 	case 1: /* only upper limit */
 		low = 1
 		up = ls.CheckInteger(1)
+		if up == 0 { /* single 0 as argument? */
+			ls.PushInteger(int64(rv)) /* full random integer */
+			return 1
+		}
 	case 2: /* lower and upper limits */
 		low = ls.CheckInteger(1)
 		up = ls.CheckInteger(2)
@@ -64,20 +85,29 @@ func mathRandom(ls api.LuaState) int {
 
 	/* random integer in the interval [low, up] */
 	ls.ArgCheck(low <= up, 1, "interval is empty")
-	ls.ArgCheck(low >= 0 || up <= math.MaxInt64+low, 1,
-		"interval too large")
-	if up-low == math.MaxInt64 {
-		ls.PushInteger(low + rand.Int63())
-	} else {
-		ls.PushInteger(low + rand.Int63n(up-low+1))
-	}
+	p := _project(rv, uint64(up)-uint64(low), src)
+	ls.PushInteger(int64(p + uint64(low)))
 	return 1
 }
 
+// 返回实际使用的两个种子
 func mathRandomSeed(ls api.LuaState) int {
-	x := ls.CheckNumber(1)
-	rand.Seed(int64(x))
-	return 0
+	var n1, n2 uint64
+	if ls.IsNone(1) {
+		n1, n2 = _randSeed()
+	} else {
+		if ls.IsInteger(1) {
+			n1 = uint64(ls.ToInteger(1))
+		} else {
+			n1 = uint64(int64(ls.CheckNumber(1)))
+		}
+		n2 = uint64(ls.OptInteger(2, 0))
+	}
+	_randomSource(ls).Seed(n1, n2)
+	_setSeeded(ls, !ls.IsNone(1))
+	ls.PushInteger(int64(n1))
+	ls.PushInteger(int64(n2))
+	return 2
 }
 
 func mathMax(ls api.LuaState) int {
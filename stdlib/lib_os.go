@@ -1,12 +1,49 @@
 package stdlib
 
-//#include <time.h>
-import "C"
-
 import "os"
+import "os/exec"
+import "runtime"
+import "strconv"
+import "syscall"
 import "time"
 import "luago/api"
 
+// 注册表里保存宿主替换的命令执行器和退出处理函数
+const LUA_COMMANDRUNNER = "_COMMAND_RUNNER"
+const LUA_EXITHANDLER = "_EXIT_HANDLER"
+
+// 执行 os.execute 的命令, what 是 "exit" 或 "signal"
+// 沙箱可以用 SetCommandRunner 换成自己的实现, 设为 nil 表示没有 shell
+type CommandRunner func(cmd string) (what string, code int, err error)
+
+// os.exit 最后调用它退出进程, 嵌入方可以拦截
+type ExitHandler func(code int)
+
+// 退出处理函数返回之后, os.exit 用它打断 Lua 代码, pcall 不能捕获.
+// 宿主直接调用的 PCall 返回它的消息; 之后要继续使用状态, 先调用 Interrupt(nil)
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return "exit with status " + strconv.Itoa(e.Code)
+}
+
+func SetCommandRunner(ls api.LuaState, runner CommandRunner) {
+	ls.NewUserdata(runner)
+	ls.SetField(api.LUA_REGISTRYINDEX, LUA_COMMANDRUNNER)
+}
+
+func SetExitHandler(ls api.LuaState, handler ExitHandler) {
+	ls.NewUserdata(handler)
+	ls.SetField(api.LUA_REGISTRYINDEX, LUA_EXITHANDLER)
+}
+
+var _startTime = time.Now()
+
+/* maximum value for date fields (to avoid arithmetic overflows with 'int') */
+const _MAXDATEFIELD = (1<<31 - 1) / 2
+
 var sysLib = map[string]api.GoFunction{
 	"clock":     osClock,
 	"difftime":  osDiffTime,
@@ -27,11 +64,14 @@ func OpenOSLib(ls api.LuaState) int {
 }
 
 func osClock(ls api.LuaState) int {
-	c := float64(C.clock()) / float64(C.CLOCKS_PER_SEC)
-	ls.PushNumber(c)
+	ls.PushNumber(_cpuTime())
 	return 1
 }
 
+func _wallClock() float64 {
+	return time.Since(_startTime).Seconds()
+}
+
 func osDiffTime(ls api.LuaState) int {
 	t2 := ls.CheckInteger(1)
 	t1 := ls.CheckInteger(2)
@@ -45,21 +85,33 @@ func osTime(ls api.LuaState) int {
 		ls.PushInteger(t)
 	} else {
 		ls.CheckType(1, api.LUA_TTABLE)
+		ls.SetTop(1) /* make sure table is at the top */
 		sec := _getField(ls, "sec", 0)
 		min := _getField(ls, "min", 0)
 		hour := _getField(ls, "hour", 12)
 		day := _getField(ls, "day", -1)
 		month := _getField(ls, "month", -1)
 		year := _getField(ls, "year", -1)
-		// todo: isdst
+		isdst := _getBoolField(ls, "isdst")
+		/* time.Date normalizes out-of-range fields like mktime */
 		t := time.Date(year, time.Month(month), day,
-			hour, min, sec, 0, time.Local).Unix()
-		ls.PushInteger(t)
+			hour, min, sec, 0, time.Local)
+		if isdst >= 0 && (isdst > 0) != t.IsDST() {
+			/* wall clock given in the other offset: DST is one hour ahead */
+			if isdst > 0 {
+				t = t.Add(-time.Hour)
+			} else {
+				t = t.Add(time.Hour)
+			}
+		}
+		_setAllFields(ls, t) /* update fields with normalized values */
+		ls.PushInteger(t.Unix())
 	}
 	return 1
 }
 
-// lua-5.3.4/src/loslib.c#getfield()
+// lua-5.3.6/src/loslib.c#getfield()
+// time.Date 直接接受年份和月份, 不需要 C 里 struct tm 的 delta
 func _getField(ls api.LuaState, key string, dft int64) int {
 	t := ls.GetField(-1, key) /* get field and its type */
 	res, isNum := ls.ToIntegerX(-1)
@@ -70,44 +122,66 @@ func _getField(ls api.LuaState, key string, dft int64) int {
 			return ls.Error2("field '%s' missing in date table", key)
 		}
 		res = dft
+	} else {
+		if !(-_MAXDATEFIELD <= res && res <= _MAXDATEFIELD) {
+			return ls.Error2("field '%s' is out-of-bound", key)
+		}
 	}
 	ls.Pop(1)
 	return int(res)
 }
 
+// -1 表示没有设置, 由 mktime 自己决定
+func _getBoolField(ls api.LuaState, key string) int {
+	res := -1
+	if ls.GetField(-1, key) != api.LUA_TNIL {
+		if ls.ToBoolean(-1) {
+			res = 1
+		} else {
+			res = 0
+		}
+	}
+	ls.Pop(1)
+	return res
+}
+
 func osDate(ls api.LuaState) int {
 	format := ls.OptString(1, "%c")
-	var t time.Time
-	if ls.IsInteger(2) {
-		t = time.Unix(ls.ToInteger(2), 0)
-	} else {
-		t = time.Now()
+	t := time.Now()
+	if !ls.IsNoneOrNil(2) {
+		t = time.Unix(ls.CheckInteger(2), 0)
 	}
 
 	if format != "" && format[0] == '!' { /* UTC? */
 		format = format[1:] /* skip '!' */
-		t = t.In(time.UTC)
+		t = t.In(_gmt)
+	} else {
+		t = t.In(time.Local)
 	}
 
 	if format == "*t" {
 		ls.CreateTable(0, 9) /* 9 = number of fields */
-		_setField(ls, "sec", t.Second())
-		_setField(ls, "min", t.Minute())
-		_setField(ls, "hour", t.Hour())
-		_setField(ls, "day", t.Day())
-		_setField(ls, "month", int(t.Month()))
-		_setField(ls, "year", t.Year())
-		_setField(ls, "wday", int(t.Weekday())+1)
-		_setField(ls, "yday", t.YearDay())
-	} else if format == "%c" {
-		ls.PushString(t.Format(time.ANSIC))
+		_setAllFields(ls, t)
 	} else {
-		ls.PushString(format) // TODO
+		ls.PushString(_strftime(ls, format, t))
 	}
 
 	return 1
 }
 
+func _setAllFields(ls api.LuaState, t time.Time) {
+	_setField(ls, "sec", t.Second())
+	_setField(ls, "min", t.Minute())
+	_setField(ls, "hour", t.Hour())
+	_setField(ls, "day", t.Day())
+	_setField(ls, "month", int(t.Month()))
+	_setField(ls, "year", t.Year())
+	_setField(ls, "wday", int(t.Weekday())+1)
+	_setField(ls, "yday", t.YearDay())
+	ls.PushBoolean(t.IsDST())
+	ls.SetField(-2, "isdst")
+}
+
 func _setField(ls api.LuaState, key string, value int) {
 	ls.PushInteger(int64(value))
 	ls.SetField(-2, key)
@@ -139,7 +213,13 @@ func osRename(ls api.LuaState) int {
 }
 
 func osTmpName(ls api.LuaState) int {
-	panic("todo: osTmpName!")
+	f, err := os.CreateTemp("", "lua_")
+	if err != nil {
+		return ls.Error2("unable to generate a unique filename")
+	}
+	f.Close()
+	ls.PushString(f.Name())
+	return 1
 }
 
 func osGetEnv(ls api.LuaState) int {
@@ -153,26 +233,105 @@ func osGetEnv(ls api.LuaState) int {
 }
 
 func osExecute(ls api.LuaState) int {
-	panic("todo: osExecute!")
+	runner := _commandRunner(ls)
+	if ls.IsNoneOrNil(1) {
+		ls.PushBoolean(runner != nil) /* true if there is a shell */
+		return 1
+	}
+	cmd := ls.CheckString(1)
+	if runner == nil {
+		ls.PushNil()
+		ls.PushString("no shell available")
+		return 2
+	}
+
+	what, code, err := runner(cmd)
+	if err != nil {
+		ls.PushNil()
+		ls.PushString(err.Error())
+		return 2
+	}
+	if what == "exit" && code == 0 {
+		ls.PushBoolean(true)
+	} else {
+		ls.PushNil()
+	}
+	ls.PushString(what)
+	ls.PushInteger(int64(code))
+	return 3
+}
+
+func _commandRunner(ls api.LuaState) CommandRunner {
+	ls.GetField(api.LUA_REGISTRYINDEX, LUA_COMMANDRUNNER)
+	runner, ok := ls.ToUserdata(-1).(CommandRunner)
+	ls.Pop(1)
+	if !ok {
+		return _runShell
+	}
+	return runner
+}
+
+// 默认用系统的 shell 执行, 相当于 system()
+func _runShell(cmd string) (what string, code int, err error) {
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", cmd)
+	} else {
+		c = exec.Command("/bin/sh", "-c", cmd)
+	}
+	c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = c.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return "", 0, err
+		}
+	}
+	if ws, ok := c.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return "signal", int(ws.Signal()), nil
+	}
+	return "exit", c.ProcessState.ExitCode(), nil
 }
 
 func osExit(ls api.LuaState) int {
+	var code int
 	if ls.IsBoolean(1) {
 		if ls.ToBoolean(1) {
-			os.Exit(0)
+			code = 0 /* EXIT_SUCCESS */
 		} else {
-			os.Exit(1) // todo
+			code = 1 /* EXIT_FAILURE */
 		}
 	} else {
-		code := ls.OptInteger(1, 1)
-		os.Exit(int(code))
+		code = int(ls.OptInteger(1, 0))
+	}
+
+	ls.GetField(api.LUA_REGISTRYINDEX, LUA_EXITHANDLER)
+	handler, ok := ls.ToUserdata(-1).(ExitHandler)
+	ls.Pop(1)
+	if !ok {
+		handler = os.Exit
 	}
 	if ls.ToBoolean(2) {
-		//ls.Close()
+		ls.Close() /* run pending finalizers */
+	}
+	handler(code)
+	/* the handler returned: stop the script instead of going on */
+	err := &ExitError{code}
+	if in, ok := ls.(interface{ Interrupt(err error) }); ok {
+		in.Interrupt(err)
+		return 0
 	}
-	return 0
+	return ls.Error2("%s", err.Error())
 }
 
+var _localeCategories = []string{"all", "collate", "ctype", "monetary", "numeric", "time"}
+
+// 只支持 C 语言环境, 设置其它语言环境时返回 nil
 func osSetLocale(ls api.LuaState) int {
-	panic("todo: osSetLocale!")
+	ls.CheckOption(2, "all", _localeCategories)
+	switch l := ls.OptString(1, "C"); l {
+	case "C", "POSIX", "": /* "" is the native locale, which is "C" here */
+		ls.PushString("C")
+	default:
+		ls.PushNil()
+	}
+	return 1
 }
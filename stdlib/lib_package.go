@@ -17,12 +17,18 @@ const (
 	LUA_IGMARK    = "-"
 )
 
+const LUA_PATH_DEFAULT = "./?.lua;./?/init.lua"
+
+// 宿主设置的 package.path, 没有时使用环境变量或者 LUA_PATH_DEFAULT
+const LUA_PATH_KEY = "_PATH"
+
 var llFuncs = map[string]api.GoFunction{
 	"require": pkgRequire,
 }
 
 var pkgFuncs = map[string]api.GoFunction{
 	"searchpath": pkgSearchPath,
+	"reload":     pkgReload,
 	/* placeholders */
 	"preload":   nil,
 	"cpath":     nil,
@@ -31,12 +37,25 @@ var pkgFuncs = map[string]api.GoFunction{
 	"loaded":    nil,
 }
 
+// 5.1 兼容模式下才有的函数
+var ll51Funcs = map[string]api.GoFunction{
+	"module": llModule,
+}
+
+var pkg51Funcs = map[string]api.GoFunction{
+	"seeall": pkgSeeAll,
+}
+
 func OpenPackageLib(ls api.LuaState) int {
 	ls.NewLib(pkgFuncs) /* create 'package' table */
 	createSearchersTable(ls)
+	if ls.Version() == api.LUA_VERSION_51 {
+		ls.SetFuncs(pkg51Funcs, 0)
+		ls.GetField(-1, "searchers")
+		ls.SetField(-2, "loaders") /* 5.1 name of 'searchers' */
+	}
 	/* set paths */
-	ls.PushString("./?.lua;./?/init.lua")
-	ls.SetField(-2, "path")
+	_setPath(ls)
 	/* store config information */
 	ls.PushString(LUA_DIRSEP + "\n" + LUA_PATH_SEP + "\n" +
 		LUA_PATH_MARK + "\n" + LUA_EXEC_DIR + "\n" + LUA_IGMARK + "\n")
@@ -50,13 +69,17 @@ func OpenPackageLib(ls api.LuaState) int {
 	ls.PushGlobalTable()
 	ls.PushValue(-2)        /* set 'package' as upvalue for next lib */
 	ls.SetFuncs(llFuncs, 1) /* open lib into global table */
-	ls.Pop(1)               /* pop global table */
-	return 1                /* return 'package' table */
+	if ls.Version() == api.LUA_VERSION_51 {
+		ls.SetFuncs(ll51Funcs, 0)
+	}
+	ls.Pop(1) /* pop global table */
+	return 1  /* return 'package' table */
 }
 
 func createSearchersTable(ls api.LuaState) {
 	searchers := []api.GoFunction{
 		preloadSearcher,
+		goSearcher,
 		luaSearcher,
 	}
 	/* create 'searchers' table */
@@ -87,13 +110,15 @@ func luaSearcher(ls api.LuaState) int {
 		ls.Error2("'package.path' must be a string")
 	}
 
-	filename, errMsg := _searchPath(name, path, ".", LUA_DIRSEP)
-	if errMsg != "" {
+	filename, errMsg := _searchPath(ls, name, path, ".", LUA_DIRSEP)
+	if filename == "" {
 		ls.PushString(errMsg)
 		return 1
 	}
 
 	if ls.LoadFile(filename) == api.LUA_OK { /* module loaded successfully? */
+		/* remember the file for 'package.reload' */
+		_recordModuleFile(ls, name, filename)
 		ls.PushString(filename) /* will be 2nd argument to module */
 		return 2                /* return open function and file name */
 	} else {
@@ -102,28 +127,81 @@ func luaSearcher(ls api.LuaState) int {
 	}
 }
 
-func _searchPath(name, path, sep, dirSep string) (filename, errMsg string) {
+// 按模板的顺序查找, 每个模板再按文件系统的顺序查找
+func _searchPath(ls api.LuaState, name, path, sep, dirSep string) (filename, errMsg string) {
 	if sep != "" {
 		name = strings.Replace(name, sep, dirSep, -1)
 	}
 
+	fss := *_fileSystems(ls)
 	for _, filename := range strings.Split(path, LUA_PATH_SEP) {
+		if filename == "" {
+			continue
+		}
 		filename = strings.Replace(filename, LUA_PATH_MARK, name, -1)
-		if _, err := os.Stat(filename); !os.IsNotExist(err) {
-			return filename, ""
+		for _, fsys := range fss {
+			if _readable(fsys, filename) {
+				return filename, ""
+			}
+			errMsg += "\n\tno file '" + filename + "'"
+			if fsys.Name != "" {
+				errMsg += " in " + fsys.Name
+			}
 		}
-		errMsg += "\n\tno file '" + filename + "'"
+	}
+	if len(fss) == 0 {
+		errMsg += "\n\tno file system to search"
 	}
 
 	return "", errMsg
 }
 
+// 修改 package.path, 在 OpenLibs 之前调用时作为它的初始值
+func SetPath(ls api.LuaState, path string) {
+	ls.PushString(path)
+	ls.SetField(api.LUA_REGISTRYINDEX, LUA_PATH_KEY)
+	if ls.GetField(api.LUA_REGISTRYINDEX, LUA_LOADED_TABLE) == api.LUA_TTABLE {
+		if ls.GetField(-1, "package") == api.LUA_TTABLE { /* already opened? */
+			ls.PushString(path)
+			ls.SetField(-2, "path")
+		}
+		ls.Pop(1)
+	}
+	ls.Pop(1)
+}
+
+/*
+** like 'setpath' in loadlib.c: the host path, or LUA_PATH_5_3 / LUA_PATH
+** from the environment, where ";;" is replaced by the default path
+ */
+func _setPath(ls api.LuaState) {
+	if ls.GetField(api.LUA_REGISTRYINDEX, LUA_PATH_KEY) == api.LUA_TSTRING {
+		ls.SetField(-2, "path")
+		return
+	}
+	ls.Pop(1)
+	path, ok := os.LookupEnv("LUA_PATH_5_3")
+	if !ok {
+		path, ok = os.LookupEnv("LUA_PATH")
+	}
+	if !ok {
+		path = LUA_PATH_DEFAULT
+	} else {
+		/* replace ";;" by ";AUXMARK;" and then AUXMARK by default path */
+		path = strings.Replace(path, LUA_PATH_SEP+LUA_PATH_SEP,
+			LUA_PATH_SEP+"\x01"+LUA_PATH_SEP, -1)
+		path = strings.Replace(path, "\x01", LUA_PATH_DEFAULT, -1)
+	}
+	ls.PushString(path)
+	ls.SetField(-2, "path")
+}
+
 func pkgSearchPath(ls api.LuaState) int {
 	name := ls.CheckString(1)
 	path := ls.CheckString(2)
 	sep := ls.OptString(3, ".")
 	rep := ls.OptString(4, LUA_DIRSEP)
-	if filename, errMsg := _searchPath(name, path, sep, rep); errMsg == "" {
+	if filename, errMsg := _searchPath(ls, name, path, sep, rep); filename != "" {
 		ls.PushString(filename)
 		return 1
 	} else {
@@ -186,3 +264,93 @@ func _findLoader(ls api.LuaState, name string) {
 		}
 	}
 }
+
+// 5.1: module (name [, ...])
+func llModule(ls api.LuaState) int {
+	modname := ls.CheckString(1)
+	loaded := ls.GetTop() + 1 /* index of _LOADED table */
+	ls.GetField(api.LUA_REGISTRYINDEX, LUA_LOADED_TABLE)
+	ls.GetField(loaded, modname)       /* get _LOADED[modname] */
+	if ls.Type(-1) != api.LUA_TTABLE { /* not found? */
+		ls.Pop(1) /* remove previous result */
+		/* try global variable (and create one if it does not exist) */
+		ls.PushGlobalTable()
+		if !_findTable(ls, modname) {
+			return ls.Error2("name conflict for module '%s'", modname)
+		}
+		ls.PushValue(-1)
+		ls.SetField(loaded, modname) /* _LOADED[modname] = new table */
+	}
+	/* check whether table already has a _NAME field */
+	if ls.GetField(-1, "_NAME") != api.LUA_TNIL { /* is table an initialized module? */
+		ls.Pop(1)
+	} else { /* no; initialize it */
+		ls.Pop(1)
+		_modInit(ls, modname)
+	}
+	ls.PushValue(-1)
+	_setFenv(ls)
+	_doOptions(ls, loaded-1)
+	return 0
+}
+
+func _modInit(ls api.LuaState, modname string) {
+	ls.PushValue(-1)
+	ls.SetField(-2, "_M") /* module._M = module */
+	ls.PushString(modname)
+	ls.SetField(-2, "_NAME")
+	/* set _PACKAGE as package name (full module name minus last part) */
+	ls.PushString(modname[:strings.LastIndexByte(modname, '.')+1])
+	ls.SetField(-2, "_PACKAGE")
+}
+
+func _setFenv(ls api.LuaState) {
+	if !ls.PushStackFunction(1) || /* get calling function */
+		ls.IsGoFunction(-1) {
+		ls.Error2("'module' not called from a Lua function")
+	}
+	ls.PushValue(-2)
+	ls.SetFenv(-2)
+	ls.Pop(1)
+}
+
+func _doOptions(ls api.LuaState, n int) {
+	for i := 2; i <= n; i++ {
+		ls.PushValue(i)  /* get option (a function) */
+		ls.PushValue(-2) /* module */
+		ls.Call(1, 0)
+	}
+}
+
+/* same as luaL_findtable: leaves table t.fname on the stack, creating missing tables */
+func _findTable(ls api.LuaState, fname string) bool {
+	for _, name := range strings.Split(fname, ".") {
+		ls.PushString(name)
+		ls.RawGet(-2)
+		if ls.IsNil(-1) { /* no such field? */
+			ls.Pop(1) /* remove this nil */
+			ls.NewTable()
+			ls.PushString(name)
+			ls.PushValue(-2)
+			ls.RawSet(-4) /* set new table into field */
+		} else if ls.Type(-1) != api.LUA_TTABLE { /* field has a non-table value? */
+			ls.Pop(2) /* remove table and value */
+			return false
+		}
+		ls.Remove(-2) /* remove previous table */
+	}
+	return true
+}
+
+// 5.1: package.seeall (module)
+func pkgSeeAll(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TTABLE)
+	if !ls.GetMetatable(1) {
+		ls.CreateTable(0, 1) /* create new metatable */
+		ls.PushValue(-1)
+		ls.SetMetatable(1)
+	}
+	ls.PushGlobalTable()
+	ls.SetField(-2, "__index") /* mt.__index = _G */
+	return 0
+}
@@ -1,6 +1,8 @@
 package stdlib
 
 import "fmt"
+import "math"
+import "strconv"
 import "strings"
 import "luago/api"
 
@@ -163,12 +165,15 @@ func strChar(ls api.LuaState) int {
 
 
 func strDump(ls api.LuaState) int {
-	// strip := ls.ToBoolean(2)
-	// ls.CheckType(1, LUA_TFUNCTION)
-	// ls.SetTop(1)
-	// ls.PushString(string(ls.Dump(strip)))
-	// return 1
-	panic("todo: strDump!")
+	var b strings.Builder
+	strip := ls.ToBoolean(2)
+	ls.CheckType(1, api.LUA_TFUNCTION)
+	ls.SetTop(1)
+	if ls.Dump(&b, strip) != 0 {
+		return ls.Error2("unable to dump given function")
+	}
+	ls.PushString(b.String())
+	return 1
 }
 
 func strPackSize(ls api.LuaState) int {
@@ -189,51 +194,263 @@ func strUnpack(ls api.LuaState) int {
 	panic("todo: strUnpack!")
 }
 
+/* valid flags in a format specification */
+const _FMT_FLAGS = "-+ #0"
+
 func strFormat(ls api.LuaState) int {
-	fmtStr := ls.CheckString(1)
-	if len(fmtStr) <= 1 || strings.IndexByte(fmtStr, '%') < 0 {
-		ls.PushString(fmtStr)
-		return 1
+	top := ls.GetTop()
+	strfrmt := ls.CheckString(1)
+	arg := 1
+
+	var b strings.Builder
+	for i := 0; i < len(strfrmt); {
+		if strfrmt[i] != '%' {
+			b.WriteByte(strfrmt[i])
+			i++
+		} else if i++; i < len(strfrmt) && strfrmt[i] == '%' {
+			b.WriteByte('%') /* %% */
+			i++
+		} else { /* format item */
+			arg++
+			if arg > top {
+				ls.ArgError(arg, "no value")
+			}
+			form := _scanFormat(ls, strfrmt[i:])
+			i += len(form)
+			conv := ""
+			if i < len(strfrmt) {
+				conv = strfrmt[i : i+1]
+				i++
+			}
+			b.WriteString(_fmtArg(ls, arg, form, conv))
+		}
+	}
+
+	ls.PushString(b.String())
+	return 1
+}
+
+// 返回 '%' 之后的 flags width precision 部分, 宽度和精度最多两位数字
+func _scanFormat(ls api.LuaState, strfrmt string) string {
+	p := 0
+	for p < len(strfrmt) && strings.IndexByte(_FMT_FLAGS, strfrmt[p]) >= 0 {
+		p++ /* skip flags */
+	}
+	if p > len(_FMT_FLAGS) {
+		ls.Error2("invalid format (repeated flags)")
+	}
+	isDigit := func(i int) bool {
+		return i < len(strfrmt) && strfrmt[i] >= '0' && strfrmt[i] <= '9'
+	}
+	if isDigit(p) {
+		p++ /* skip width */
+	}
+	if isDigit(p) {
+		p++ /* (2 digits at most) */
+	}
+	if p < len(strfrmt) && strfrmt[p] == '.' {
+		p++
+		if isDigit(p) {
+			p++ /* skip precision */
+		}
+		if isDigit(p) {
+			p++ /* (2 digits at most) */
+		}
+	}
+	if isDigit(p) {
+		ls.Error2("invalid format (width or precision too long)")
+	}
+	return strfrmt[:p]
+}
+
+func _fmtArg(ls api.LuaState, arg int, form, conv string) string {
+	switch conv {
+	case "c":
+		flags, width, _ := _parseForm(form)
+		return _pad(string([]byte{byte(_fmtInteger(ls, arg))}), flags, width)
+	case "d", "i":
+		return fmt.Sprintf("%"+form+"d", _fmtInteger(ls, arg))
+	case "u":
+		return fmt.Sprintf("%"+_unsignedForm(form)+"d", uint64(_fmtInteger(ls, arg)))
+	case "o", "x", "X":
+		return fmt.Sprintf("%"+_unsignedForm(form)+conv, uint64(_fmtInteger(ls, arg)))
+	case "a", "A", "e", "E", "f", "F", "g", "G":
+		return _fmtFloat(ls.CheckNumber(arg), form, conv)
+	case "q":
+		return _addLiteral(ls, arg)
+	case "s":
+		s := ls.ToString2(arg)
+		ls.Pop(1)
+		if form == "" { /* no modifiers? */
+			return s /* keep entire string */
+		}
+		ls.ArgCheck(strings.IndexByte(s, 0) < 0, arg, "string contains zeros")
+		if strings.IndexByte(form, '.') < 0 && len(s) >= 100 {
+			/* no precision and string is too long to be formatted */
+			return s /* keep entire string */
+		}
+		flags, width, prec := _parseForm(form)
+		if prec >= 0 && prec < len(s) {
+			s = s[:prec]
+		}
+		return _pad(s, flags, width)
+	default: /* also treat cases 'pnLlh' */
+		ls.Error2("invalid option '%%%s' to 'format'", conv)
+		return ""
+	}
+}
+
+// C 的无符号转换忽略 '+' 和 ' ', Go 的 fmt 却会照样输出符号
+func _unsignedForm(form string) string {
+	flags, _, _ := _parseForm(form)
+	return strings.NewReplacer("+", "", " ", "").Replace(flags) + form[len(flags):]
+}
+
+// 拆开 flags width precision, 没有精度时 prec 为 -1
+func _parseForm(form string) (flags string, width, prec int) {
+	i := 0
+	for i < len(form) && strings.IndexByte(_FMT_FLAGS, form[i]) >= 0 {
+		i++
 	}
+	flags = form[:i]
+	j := strings.IndexByte(form, '.')
+	if j < 0 {
+		width, _ = strconv.Atoi(form[i:])
+		return flags, width, -1
+	}
+	width, _ = strconv.Atoi(form[i:j])
+	prec, _ = strconv.Atoi(form[j+1:])
+	return
+}
+
+// 按字节数补空格, Go 的 %s 宽度按字符计算
+func _pad(s, flags string, width int) string {
+	if n := width - len(s); n > 0 {
+		if strings.IndexByte(flags, '-') >= 0 {
+			return s + strings.Repeat(" ", n)
+		}
+		return strings.Repeat(" ", n) + s
+	}
+	return s
+}
+
+// 浮点数格式化 无穷和 NaN 写成 C 的样子, %g 默认精度是 6
+func _fmtFloat(f float64, form, conv string) string {
+	flags, width, prec := _parseForm(form)
+	upper := conv == "A" || conv == "E" || conv == "F" || conv == "G"
+
+	var sign, s string
+	if f < 0 || f == 0 && math.Signbit(f) {
+		sign = "-"
+	} else if strings.IndexByte(flags, '+') >= 0 {
+		sign = "+"
+	} else if strings.IndexByte(flags, ' ') >= 0 {
+		sign = " "
+	}
+
+	switch {
+	case math.IsInf(f, 0):
+		s = sign + "inf"
+	case math.IsNaN(f):
+		s = "nan"
+	case conv == "a" || conv == "A":
+		s = sign + _hexFloat(math.Abs(f), prec)
+		if n := width - len(s); n > 0 && strings.IndexByte(flags, '0') >= 0 &&
+			strings.IndexByte(flags, '-') < 0 { /* zeros after "0x" */
+			s = s[:len(sign)+2] + strings.Repeat("0", n) + s[len(sign)+2:]
+		}
+	default:
+		if prec < 0 && (conv == "g" || conv == "G") {
+			form += ".6"
+		}
+		return fmt.Sprintf("%"+form+conv, f)
+	}
+
+	if upper {
+		s = strings.ToUpper(s)
+	}
+	return _pad(s, flags, width)
+}
 
-	argIdx := 1
-	arr := parseFmtStr(fmtStr)
-	for i, s := range arr {
-		if s[0] == '%' {
-			if s == "%%" {
-				arr[i] = "%"
+// C 的 %a: 指数部分不补零, 例如 0x1.8p+1
+func _hexFloat(f float64, prec int) string {
+	s := strconv.FormatFloat(f, 'x', prec, 64)
+	if i := strings.LastIndexByte(s, 'p') + 2; len(s)-i == 2 && s[i] == '0' {
+		s = s[:i] + s[i+1:]
+	}
+	return s
+}
+
+// %q: 输出能被 Lua 读回的字面量
+func _addLiteral(ls api.LuaState, arg int) string {
+	switch ls.Type(arg) {
+	case api.LUA_TSTRING:
+		return _addQuoted(ls.ToString(arg))
+	case api.LUA_TNUMBER:
+		if !ls.IsInteger(arg) { /* float? */
+			return _quoteFloat(ls.ToNumber(arg))
+		}
+		n := ls.ToInteger(arg)
+		if n == math.MinInt64 { /* corner case? */
+			return fmt.Sprintf("0x%x", uint64(n)) /* use hexa */
+		}
+		return strconv.FormatInt(n, 10)
+	case api.LUA_TNIL, api.LUA_TBOOLEAN:
+		s := ls.ToString2(arg)
+		ls.Pop(1)
+		return s
+	default:
+		ls.ArgError(arg, "value has no literal form")
+		return ""
+	}
+}
+
+func _addQuoted(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' || c == '\\' || c == '\n' {
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		} else if c < 0x20 || c == 0x7F { /* control character */
+			if i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9' {
+				fmt.Fprintf(&b, "\\%03d", c)
 			} else {
-				argIdx += 1
-				arr[i] = _fmtArg(s, ls, argIdx)
+				fmt.Fprintf(&b, "\\%d", c)
 			}
+		} else {
+			b.WriteByte(c)
 		}
 	}
-
-	ls.PushString(strings.Join(arr, ""))
-	return 1
+	b.WriteByte('"')
+	return b.String()
 }
 
-func _fmtArg(tag string, ls api.LuaState, argIdx int) string {
-	switch tag[len(tag)-1] { // specifier
-	case 'c': // character
-		return string([]byte{byte(ls.ToInteger(argIdx))})
-	case 'i':
-		tag = tag[:len(tag)-1] + "d" // %i -> %d
-		return fmt.Sprintf(tag, ls.ToInteger(argIdx))
-	case 'd', 'o': // integer, octal
-		return fmt.Sprintf(tag, ls.ToInteger(argIdx))
-	case 'u': // unsigned integer
-		tag = tag[:len(tag)-1] + "d" // %u -> %d
-		return fmt.Sprintf(tag, uint(ls.ToInteger(argIdx)))
-	case 'x', 'X': // hex integer
-		return fmt.Sprintf(tag, uint(ls.ToInteger(argIdx)))
-	case 'f': // float
-		return fmt.Sprintf(tag, ls.ToNumber(argIdx))
-	case 's', 'q': // string
-		return fmt.Sprintf(tag, ls.ToString2(argIdx))
+// 整数值的浮点数写成 "n.0", 其它的用十六进制保证读回来完全一样
+func _quoteFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "1e9999"
+	case math.IsInf(f, -1):
+		return "-1e9999"
+	case math.IsNaN(f):
+		return "(0/0)"
+	case f == math.Trunc(f) && math.Abs(f) < 1<<63 && !(f == 0 && math.Signbit(f)):
+		return strconv.FormatInt(int64(f), 10) + ".0"
+	case f < 0:
+		return "-" + _hexFloat(-f, -1)
 	default:
-		panic("todo! tag=" + tag)
+		return _hexFloat(f, -1)
+	}
+}
+
+// 5.1 没有整数类型, 直接截断浮点数
+func _fmtInteger(ls api.LuaState, argIdx int) int64 {
+	if ls.Version() == api.LUA_VERSION_51 {
+		return int64(ls.CheckNumber(argIdx))
 	}
+	return ls.CheckInteger(argIdx)
 }
 
 func strFind(ls api.LuaState) int {
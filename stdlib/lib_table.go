@@ -1,7 +1,8 @@
 package stdlib
 
-import "sort"
+import "math"
 import "strings"
+import "time"
 import "luago/api"
 
 const MAX_LEN = 1000000 // TODO
@@ -27,8 +28,23 @@ var tabFuncs = map[string]api.GoFunction{
 	"unpack": tabUnpack,
 }
 
+// 5.1 兼容模式下才有的函数
+var tab51Funcs = map[string]api.GoFunction{
+	"getn": tabGetN,
+}
+
 func OpenTableLib(ls api.LuaState) int {
 	ls.NewLib(tabFuncs)
+	if ls.Version() == api.LUA_VERSION_51 {
+		ls.SetFuncs(tab51Funcs, 0)
+	}
+	return 1
+}
+
+// 5.1: table.getn (table)
+func tabGetN(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TTABLE)
+	ls.PushInteger(int64(ls.RawLen(1)))
 	return 1
 }
 
@@ -138,9 +154,9 @@ func _checkTab(ls api.LuaState, arg, what int) {
 	if ls.Type(arg) != api.LUA_TTABLE { /* is it not a table? */
 		n := 1                     /* number of elements to pop */
 		if ls.GetMetatable(arg) && /* must have metatable */
-			(what&TAB_R != 0 || _checkField(ls, "__index", &n)) &&
-			(what&TAB_W != 0 || _checkField(ls, "__newindex", &n)) &&
-			(what&TAB_L != 0 || _checkField(ls, "__len", &n)) {
+			(what&TAB_R == 0 || _checkField(ls, "__index", &n)) &&
+			(what&TAB_W == 0 || _checkField(ls, "__newindex", &n)) &&
+			(what&TAB_L == 0 || _checkField(ls, "__len", &n)) {
 			ls.Pop(n) /* pop metatable and tested metamethods */
 		} else {
 			ls.CheckType(arg, api.LUA_TTABLE) /* force an error */
@@ -187,44 +203,192 @@ func tabUnpack(ls api.LuaState) int {
 	return n
 }
 
-func tabSort(ls api.LuaState) int {
-	w := wrapper{ls}
-	ls.ArgCheck(w.Len() < MAX_LEN, 1, "array too big")
-	sort.Sort(w)
-	return 0
+/*
+** {======================================================
+** Quicksort
+** (based on 'Algorithms in MODULA-3', Robert Sedgewick;
+**  Addison-Wesley, 1993.)
+** =======================================================
+ */
+
+/*
+** Produce a "random" 'unsigned int' to randomize pivot choice. This
+** macro is used only when 'sort' detects a big imbalance in the result
+** of a partition. (If you don't want/need this "randomness", ~0 is a
+** good choice.)
+ */
+func _randomizePivot() uint {
+	return uint(time.Now().UnixNano())
 }
 
-type wrapper struct {
-	ls api.LuaState
+/* arrays larger than 'RANLIMIT' may use randomized pivots */
+const RANLIMIT = 100
+
+func _set2(ls api.LuaState, i, j int64) {
+	ls.SetI(1, i)
+	ls.SetI(1, j)
 }
 
-func (w wrapper) Len() int {
-	return int(w.ls.Len2(1))
+/*
+** Return true iff value at stack index 'a' is less than the value at
+** index 'b' (according to the order of the sort).
+ */
+func _sortComp(ls api.LuaState, a, b int) bool {
+	if ls.IsNil(2) { /* no function? */
+		return ls.Compare(a, b, api.LUA_OPLT) /* a < b */
+	}
+	ls.PushValue(2)     /* push function */
+	ls.PushValue(a - 1) /* -1 to compensate function */
+	ls.PushValue(b - 2) /* -2 to compensate function and 'a' */
+	ls.Call(2, 1)       /* call function */
+	res := ls.ToBoolean(-1)
+	ls.Pop(1) /* pop result */
+	return res
 }
 
-func (w wrapper) Less(i, j int) bool {
-	ls := w.ls
-	if ls.IsFunction(2) { // cmp is given
-		ls.PushValue(2)
-		ls.GetI(1, int64(i+1))
-		ls.GetI(1, int64(j+1))
-		ls.Call(2, 1)
-		b := ls.ToBoolean(-1)
-		ls.Pop(1)
-		return b
-	} else { // cmp is missing
-		ls.GetI(1, int64(i+1))
-		ls.GetI(1, int64(j+1))
-		b := ls.Compare(-2, -1, api.LUA_OPLT)
-		ls.Pop(2)
-		return b
+/*
+** Does the partition: Pivot P is at the top of the stack.
+** precondition: a[lo] <= P == a[up-1] <= a[up],
+** so it only needs to do the partition from lo + 1 to up - 2.
+** Pos-condition: a[lo .. i - 1] <= a[i] == P <= a[i + 1 .. up]
+** returns 'i'.
+ */
+func _partition(ls api.LuaState, lo, up int64) int64 {
+	i := lo     /* will be incremented before first use */
+	j := up - 1 /* will be decremented before first use */
+	/* loop invariant: a[lo .. i] <= P <= a[j .. up], a[up - 1] == P */
+	for {
+		/* next loop: repeat ++i while a[i] < P */
+		for {
+			i++
+			ls.GetI(1, i)
+			if !_sortComp(ls, -1, -2) {
+				break
+			}
+			if i == up-1 { /* a[i] < P  but a[up - 1] == P  ?? */
+				ls.Error2("invalid order function for sorting")
+			}
+			ls.Pop(1) /* remove a[i] */
+		}
+		/* after the loop, a[i] >= P and a[lo .. i - 1] < P */
+		/* next loop: repeat --j while P < a[j] */
+		for {
+			j--
+			ls.GetI(1, j)
+			if !_sortComp(ls, -3, -1) {
+				break
+			}
+			if j < i { /* j < i  but  a[j] > P ?? */
+				ls.Error2("invalid order function for sorting")
+			}
+			ls.Pop(1) /* remove a[j] */
+		}
+		/* after the loop, a[j] <= P and a[j + 1 .. up] >= P */
+		if j < i { /* no elements to be exchanged? */
+			ls.Pop(1) /* pop a[j] */
+			/* swap pivot (a[up - 1]) with a[i] to satisfy pos. of pivot */
+			_set2(ls, up-1, i)
+			return i
+		}
+		/* otherwise, swap a[i] - a[j] to restore invariant and repeat */
+		_set2(ls, i, j)
 	}
 }
 
-func (w wrapper) Swap(i, j int) {
-	ls := w.ls
-	ls.GetI(1, int64(i+1))
-	ls.GetI(1, int64(j+1))
-	ls.SetI(1, int64(i+1))
-	ls.SetI(1, int64(j+1))
+/*
+** Choose an element in the middle (2nd-3th quarters) of [lo,up]
+** "randomized" by 'rnd'
+ */
+func _choosePivot(lo, up int64, rnd uint) int64 {
+	r4 := (up - lo) / 4 /* range/4 */
+	return int64(rnd%uint(r4*2)) + (lo + r4)
 }
+
+/*
+** QuickSort algorithm (recursive function)
+ */
+func _auxSort(ls api.LuaState, lo, up int64, rnd uint) {
+	for lo < up { /* loop for tail recursion */
+		var p int64 /* Pivot index */
+		var n int64 /* to be used later */
+		/* sort elements 'lo', 'p', and 'up' */
+		ls.GetI(1, lo)
+		ls.GetI(1, up)
+		if _sortComp(ls, -1, -2) { /* a[up] < a[lo]? */
+			_set2(ls, lo, up) /* swap a[lo] - a[up] */
+		} else {
+			ls.Pop(2) /* remove both values */
+		}
+		if up-lo == 1 { /* only 2 elements? */
+			break /* already sorted */
+		}
+		if up-lo < RANLIMIT || rnd == 0 { /* small interval or no randomize? */
+			p = (lo + up) / 2 /* use middle point */
+		} else { /* for larger intervals, it is expensive to solve worst cases */
+			p = _choosePivot(lo, up, rnd)
+		}
+		ls.GetI(1, p)
+		ls.GetI(1, lo)
+		if _sortComp(ls, -2, -1) { /* a[p] < a[lo]? */
+			_set2(ls, p, lo) /* swap a[p] - a[lo] */
+		} else {
+			ls.Pop(1) /* remove second element */
+			ls.GetI(1, up)
+			if _sortComp(ls, -1, -2) { /* a[up] < a[p]? */
+				_set2(ls, p, up) /* swap up - p */
+			} else {
+				ls.Pop(2) /* clean stack */
+			}
+		}
+		if up-lo == 2 { /* only 3 elements? */
+			break /* already sorted */
+		}
+		ls.GetI(1, p)      /* get median (Pivot) */
+		ls.PushValue(-1)   /* push Pivot */
+		ls.GetI(1, up-1)   /* push a[up - 1] */
+		_set2(ls, p, up-1) /* a[p] = a[up - 1]; a[up - 1] = a[p] */
+		p = _partition(ls, lo, up)
+		/* a[lo .. p - 1] <= a[p] == P <= a[p + 1 .. up] */
+		if p-lo < up-p { /* lower interval is shorter? */
+			_auxSort(ls, lo, p-1, rnd) /* call recursively for lower interval */
+			n = p - lo                 /* size of smaller interval */
+			lo = p + 1                 /* tail call for [p + 1 .. up] (upper interval) */
+		} else {
+			_auxSort(ls, p+1, up, rnd) /* call recursively for upper interval */
+			n = up - p                 /* size of smaller interval */
+			up = p - 1                 /* tail call for [lo .. p - 1]  (lower interval) */
+		}
+		if (up-lo)/128 > n { /* partition too imbalanced? */
+			rnd = _randomizePivot() /* try a new randomization */
+		}
+	} /* tail call auxsort(L, lo, up, rnd) */
+}
+
+func tabSort(ls api.LuaState) int {
+	n := _auxGetN(ls, 1, TAB_RW)
+	if n > 1 { /* non-trivial interval? */
+		ls.ArgCheck(n < math.MaxInt32, 1, "array too big")
+		if !ls.IsNoneOrNil(2) { /* is there a 2nd argument? */
+			ls.CheckType(2, api.LUA_TFUNCTION) /* must be a function */
+		}
+		ls.SetTop(2) /* make sure there are two arguments */
+		_auxSort(ls, 1, n, 0)
+	}
+	return 0
+}
+
+// 给宿主用的 table.sort, 排序 idx 处的表
+// less 为 nil 时用 < 比较, 否则按 Lua 比较函数的约定调用它
+func SortTable(ls api.LuaState, idx int, less api.GoFunction) {
+	idx = ls.AbsIndex(idx)
+	ls.PushGoFunction(tabSort)
+	ls.PushValue(idx)
+	if less != nil {
+		ls.PushGoFunction(less)
+	} else {
+		ls.PushNil()
+	}
+	ls.Call(2, 0)
+}
+
+/* }====================================================== */
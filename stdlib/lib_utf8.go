@@ -1,12 +1,14 @@
 package stdlib
 
-import "unicode/utf8"
 import "luago/api"
 
-
-const UTF8PATT = "[\x00-\x7F\xC2-\xF4][\x80-\xBF]*"
+/* pattern to match a single UTF-8 character */
+const UTF8PATT = "[\x00-\x7F\xC2-\xFD][\x80-\xBF]*"
 
 const MAX_UNICODE = 0x10FFFF
+const MAX_UTF = 0x7FFFFFFF
+
+const MSG_INVALID = "invalid UTF-8 code"
 
 var utf8Lib = map[string]api.GoFunction{
 	"len":       utfLen,
@@ -25,26 +27,81 @@ func OpenUTF8Lib(ls api.LuaState) int {
 	return 1
 }
 
+/*
+** Decode one UTF-8 sequence, returning its length in bytes (0 if the
+** sequence is invalid). Sequences of up to six bytes (code points up to
+** 0x7FFFFFFF) are accepted; 'strict' also rejects surrogates and code
+** points above 0x10FFFF.
+ */
+func _utf8Decode(s string, strict bool) (code rune, size int) {
+	limits := [...]uint32{^uint32(0), 0x80, 0x800, 0x10000, 0x200000, 0x4000000}
+	c := uint32(s[0])
+	var res uint32 /* final result */
+	count := 0     /* to count number of continuation bytes */
+	if c < 0x80 {  /* ascii? */
+		res = c
+	} else {
+		for ; c&0x40 != 0; c <<= 1 { /* while it needs continuation bytes... */
+			count++
+			if count >= len(s) || !_isCont(s[count]) { /* not a continuation byte? */
+				return 0, 0 /* invalid byte sequence */
+			}
+			res = res<<6 | uint32(s[count]&0x3F) /* add lower 6 bits from cont. byte */
+		}
+		if count > 5 {
+			return 0, 0
+		}
+		res |= (c & 0x7F) << (count * 5) /* add first byte */
+		if res > MAX_UTF || res < limits[count] {
+			return 0, 0 /* invalid byte sequence */
+		}
+	}
+	if strict {
+		/* check for invalid code points; too large or surrogates */
+		if res > MAX_UNICODE || 0xD800 <= res && res <= 0xDFFF {
+			return 0, 0
+		}
+	}
+	return rune(res), count + 1 /* +1 to include first byte */
+}
+
+/*
+** utf8len(s [, i [, j [, lax]]]) --> number of characters that
+** start in the range [i,j], or nil + current position if 's' is not
+** well formed in that interval
+ */
 func utfLen(ls api.LuaState) int {
+	var n int64 /* counter for the number of characters */
 	s := ls.CheckString(1)
 	sLen := len(s)
-	i := posRelat(ls.OptInteger(2, 1), sLen)
-	j := posRelat(ls.OptInteger(3, -1), sLen)
-	ls.ArgCheck(1 <= i && i <= sLen+1, 2,
-		"initial position out of string")
-	ls.ArgCheck(j <= sLen, 3,
-		"final position out of string")
-
-	if i > j {
-		ls.PushInteger(0)
-	} else {
-		n := utf8.RuneCountInString(s[i-1 : j])
-		ls.PushInteger(int64(n))
-	}
+	posi := posRelat(ls.OptInteger(2, 1), sLen)
+	posj := posRelat(ls.OptInteger(3, -1), sLen)
+	lax := ls.ToBoolean(4)
+	ls.ArgCheck(1 <= posi && posi-1 <= sLen, 2,
+		"initial position out of bounds")
+	posi--
+	posj--
+	ls.ArgCheck(posj < sLen, 3,
+		"final position out of bounds")
 
+	for posi <= posj {
+		_, size := _utf8Decode(s[posi:], !lax)
+		if size == 0 { /* conversion error? */
+			ls.PushNil()                    /* return fail ... */
+			ls.PushInteger(int64(posi + 1)) /* ... and current position */
+			return 2
+		}
+		posi += size
+		n++
+	}
+	ls.PushInteger(n)
 	return 1
 }
 
+/*
+** offset(s, n, [i])  -> index where n-th character counting from
+**   position 'i' starts; 0 means character at 'i'.
+ */
 func utfByteOffset(ls api.LuaState) int {
 	s := ls.CheckString(1)
 	sLen := len(s)
@@ -54,23 +111,23 @@ func utfByteOffset(ls api.LuaState) int {
 		i = sLen + 1
 	}
 	i = posRelat(ls.OptInteger(3, int64(i)), sLen)
-	ls.ArgCheck(1 <= i && i <= sLen+1, 3, "position out of range")
+	ls.ArgCheck(1 <= i && i <= sLen+1, 3, "position out of bounds")
 	i--
 
 	if n == 0 {
 		/* find beginning of current byte sequence */
-		for i > 0 && _isCont(s[i]) {
+		for i > 0 && _isContAt(s, i) {
 			i--
 		}
 	} else {
-		if i < sLen && _isCont(s[i]) {
-			ls.Error2("initial position is a continuation byte")
+		if _isContAt(s, i) {
+			return ls.Error2("initial position is a continuation byte")
 		}
 		if n < 0 {
 			for n < 0 && i > 0 { /* move back */
 				for { /* find beginning of previous character */
 					i--
-					if !(i > 0 && _isCont(s[i])) {
+					if !(i > 0 && _isContAt(s, i)) {
 						break
 					}
 				}
@@ -81,7 +138,7 @@ func utfByteOffset(ls api.LuaState) int {
 			for n > 0 && i < sLen {
 				for { /* find beginning of next character */
 					i++
-					if i >= sLen || !_isCont(s[i]) {
+					if !_isContAt(s, i) {
 						break /* (cannot pass final '\0') */
 					}
 				}
@@ -97,97 +154,125 @@ func utfByteOffset(ls api.LuaState) int {
 	return 1
 }
 
+/*
+** codepoint(s, [i, [j [, lax]]]) -> returns codepoints for all
+** characters that start in the range [i,j]
+ */
 func utfCodePoint(ls api.LuaState) int {
 	s := ls.CheckString(1)
 	sLen := len(s)
-	i := posRelat(ls.OptInteger(2, 1), sLen)
-	j := posRelat(ls.OptInteger(3, int64(i)), sLen)
+	posi := posRelat(ls.OptInteger(2, 1), sLen)
+	pose := posRelat(ls.OptInteger(3, int64(posi)), sLen)
+	lax := ls.ToBoolean(4)
 
-	ls.ArgCheck(i >= 1, 2, "out of range")
-	ls.ArgCheck(int(j) <= sLen, 3, "out of range")
-	if i > j {
+	ls.ArgCheck(posi >= 1, 2, "out of bounds")
+	ls.ArgCheck(pose <= sLen, 3, "out of bounds")
+	if posi > pose {
 		return 0 /* empty interval; return no values */
 	}
-	if j-i >= api.LUA_MAXINTEGER { /* (lua_Integer -> int) overflow? */
+	if pose-posi >= api.LUA_MAXINTEGER { /* (lua_Integer -> int) overflow? */
 		return ls.Error2("string slice too long")
 	}
-	n := j - i + 1
+	n := pose - posi + 1 /* upper bound for number of returns */
 	ls.CheckStack2(n, "string slice too long")
 
-	n = 0
-	s = s[i-1:]
-	for i <= j {
-		code, size := utf8.DecodeRuneInString(s)
-		if code == utf8.RuneError {
-			return ls.Error2("invalid UTF-8 code")
+	n = 0 /* count the number of returns */
+	for i := posi - 1; i < pose; {
+		code, size := _utf8Decode(s[i:], !lax)
+		if size == 0 {
+			return ls.Error2(MSG_INVALID)
 		}
 		ls.PushInteger(int64(code))
 		n++
 		i += size
-		s = s[size:]
 	}
 	return n
 }
 
 func utfChar(ls api.LuaState) int {
 	n := ls.GetTop() /* number of arguments */
-	codePoints := make([]rune, n)
+	buf := make([]byte, 0, n)
 
 	for i := 1; i <= n; i++ {
-		cp := ls.CheckInteger(i)
-		ls.ArgCheck(0 <= cp && cp <= MAX_UNICODE, i, "value out of range")
-		codePoints[i-1] = rune(cp)
+		cp := uint64(ls.CheckInteger(i))
+		ls.ArgCheck(cp <= MAX_UTF, i, "value out of range")
+		buf = _utf8Esc(buf, uint32(cp))
 	}
 
-	ls.PushString(_encodeUtf8(codePoints))
+	ls.PushString(string(buf))
 	return 1
 }
 
-func _encodeUtf8(codePoints []rune) string {
-	buf := make([]byte, 6)
-	str := make([]byte, 0, len(codePoints))
-
-	for _, cp := range codePoints {
-		n := utf8.EncodeRune(buf, cp)
-		str = append(str, buf[0:n]...)
+// 和 luaO_utf8esc 一样, 可以编码到 0x7FFFFFFF
+func _utf8Esc(buf []byte, x uint32) []byte {
+	if x < 0x80 { /* ascii? */
+		return append(buf, byte(x))
 	}
-
-	return string(str)
+	var tmp [6]byte
+	n := len(tmp)
+	mfb := uint32(0x3f) /* maximum that fits in first byte */
+	for {               /* add continuation bytes */
+		n--
+		tmp[n] = byte(0x80 | x&0x3f)
+		x >>= 6   /* remove added bits */
+		mfb >>= 1 /* now there is one less bit available in first byte */
+		if x <= mfb {
+			break /* still needs continuation byte? */
+		}
+	}
+	n--
+	tmp[n] = byte(^mfb<<1 | x) /* add first byte */
+	return append(buf, tmp[n:]...)
 }
 
 func utfIterCodes(ls api.LuaState) int {
-	ls.CheckString(1)
-	ls.PushGoFunction(_iterAux)
+	lax := ls.ToBoolean(2)
+	s := ls.CheckString(1)
+	ls.ArgCheck(!_isContAt(s, 0), 1, MSG_INVALID)
+	if lax {
+		ls.PushGoFunction(_iterAuxLax)
+	} else {
+		ls.PushGoFunction(_iterAuxStrict)
+	}
 	ls.PushValue(1)
 	ls.PushInteger(0)
 	return 3
 }
 
-func _iterAux(ls api.LuaState) int {
+func _iterAux(ls api.LuaState, strict bool) int {
 	s := ls.CheckString(1)
-	sLen := int64(len(s))
-	n := ls.ToInteger(2) - 1
-	if n < 0 { /* first iteration? */
-		n = 0 /* start from here */
-	} else if n < sLen {
-		n++ /* skip current byte */
-		for n < sLen && _isCont(s[n]) {
-			n++
-		} /* and its continuations */
-	}
-	if n >= sLen {
-		return 0 /* no more codepoints */
-	} else {
-		code, _ := utf8.DecodeRuneInString(s[n:])
-		if code == utf8.RuneError {
-			return ls.Error2("invalid UTF-8 code")
+	sLen := uint64(len(s))
+	n := uint64(ls.ToInteger(2))
+	if n < sLen {
+		for _isContAt(s, int(n)) {
+			n++ /* go to next character */
 		}
-		ls.PushInteger(n + 1)
-		ls.PushInteger(int64(code))
-		return 2
 	}
+	if n >= sLen { /* (also handles original 'n' being negative) */
+		return 0 /* no more codepoints */
+	}
+	code, size := _utf8Decode(s[n:], strict)
+	if size == 0 || _isContAt(s, int(n)+size) {
+		return ls.Error2(MSG_INVALID)
+	}
+	ls.PushInteger(int64(n + 1))
+	ls.PushInteger(int64(code))
+	return 2
+}
+
+func _iterAuxStrict(ls api.LuaState) int {
+	return _iterAux(ls, true)
+}
+
+func _iterAuxLax(ls api.LuaState) int {
+	return _iterAux(ls, false)
 }
 
 func _isCont(b byte) bool {
 	return b&0xC0 == 0x80
 }
+
+// 越界时相当于 C 字符串末尾的 '\0', 不是后续字节
+func _isContAt(s string, i int) bool {
+	return i < len(s) && _isCont(s[i])
+}
@@ -0,0 +1,81 @@
+package stdlib
+
+import "sync"
+import "luago/api"
+
+// 注册表里保存只对这个状态可见的 Go 模块
+const LUA_GOMODULES_TABLE = "_GOMODULES"
+
+// 进程内所有状态共享的 Go 模块, 一般在 init() 里注册
+var _goModules = struct {
+	sync.RWMutex
+	m map[string]api.GoFunction
+}{m: map[string]api.GoFunction{}}
+
+// 注册一个所有状态都能 require 的 Go 模块, openf 和 RequireF 的一样:
+// 收到模块名, 返回模块的值. 同名重复注册会 panic, 和 database/sql.Register 一样
+func RegisterModule(name string, openf api.GoFunction) {
+	if openf == nil {
+		panic("luago: RegisterModule openf is nil")
+	}
+	_goModules.Lock()
+	defer _goModules.Unlock()
+	if _, dup := _goModules.m[name]; dup {
+		panic("luago: RegisterModule called twice for module " + name)
+	}
+	_goModules.m[name] = openf
+}
+
+// 注册一个由函数表组成的模块
+func RegisterLib(name string, l api.FuncReg) {
+	RegisterModule(name, LibOpener(l))
+}
+
+// 返回一个创建新表并填入 l 的 open 函数
+func LibOpener(l api.FuncReg) api.GoFunction {
+	return func(ls api.LuaState) int {
+		ls.NewLib(l)
+		return 1
+	}
+}
+
+// 只对这个状态可见, 优先于进程内注册的同名模块, openf 为 nil 时取消注册
+func RegisterStateModule(ls api.LuaState, name string, openf api.GoFunction) {
+	ls.GetSubTable(api.LUA_REGISTRYINDEX, LUA_GOMODULES_TABLE)
+	if openf == nil {
+		ls.PushNil()
+	} else {
+		ls.PushGoFunction(openf)
+	}
+	ls.SetField(-2, name)
+	ls.Pop(1)
+}
+
+func _findGoModule(ls api.LuaState, name string) bool {
+	ls.GetSubTable(api.LUA_REGISTRYINDEX, LUA_GOMODULES_TABLE)
+	if ls.GetField(-1, name) != api.LUA_TNIL {
+		ls.Remove(-2)
+		return true
+	}
+	ls.Pop(2)
+
+	_goModules.RLock()
+	openf := _goModules.m[name]
+	_goModules.RUnlock()
+	if openf == nil {
+		return false
+	}
+	ls.PushGoFunction(openf)
+	return true
+}
+
+// 在 preload 之后查找用 Go 注册的模块, 第二个返回值是 ":go:" 加模块名
+func goSearcher(ls api.LuaState) int {
+	name := ls.CheckString(1)
+	if !_findGoModule(ls, name) {
+		ls.PushString("\n\tno Go module '" + name + "'")
+		return 1
+	}
+	ls.PushString(":go:" + name)
+	return 2
+}
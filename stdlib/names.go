@@ -0,0 +1,19 @@
+package stdlib
+
+import "luago/api"
+
+// 不能通过库表找到的 Go 函数, 快照按这里的名字保存它们 (见 state.Snapshot)
+var HiddenFuncs = map[string]api.GoFunction{
+	"ipairs.aux":                iPairsAux,
+	"coroutine.wrap.aux":        _auxWrap,
+	"utf8.codes.strict":         _iterAuxStrict,
+	"utf8.codes.lax":            _iterAuxLax,
+	"package.searchers.preload": preloadSearcher,
+	"package.searchers.go":      goSearcher,
+	"package.searchers.lua":     luaSearcher,
+	"io.lines.aux":              _ioReadline,
+	"io.file.lines":             fLines,
+	"io.file.read":              fRead,
+	"io.file.seek":              fSeek,
+	"io.file.__tostring":        fToString,
+}
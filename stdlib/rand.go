@@ -0,0 +1,170 @@
+package stdlib
+
+import "encoding"
+import "encoding/binary"
+import "errors"
+import "math/bits"
+import "sync/atomic"
+import "time"
+import "luago/api"
+
+// 注册表里保存 math.random 使用的随机数源
+const LUA_RANDOMSOURCE = "_RANDOM_SOURCE"
+
+// 注册表里的标记: 随机数源用固定的种子设置过 (math.randomseed(n) 或 SetRandomSource)
+const LUA_RANDOMSEEDED = "_RANDOM_SEEDED"
+
+// math.random 的随机数源, 每个状态各自一个
+// 宿主可以用 SetRandomSource 换成固定种子的源以便重放
+type RandomSource interface {
+	Uint64() uint64
+	Seed(n1, n2 uint64)
+}
+
+func SetRandomSource(ls api.LuaState, src RandomSource) {
+	ls.NewUserdata(src)
+	ls.SetField(api.LUA_REGISTRYINDEX, LUA_RANDOMSOURCE)
+	_setSeeded(ls, true)
+}
+
+func _setSeeded(ls api.LuaState, seeded bool) {
+	ls.PushBoolean(seeded)
+	ls.SetField(api.LUA_REGISTRYINDEX, LUA_RANDOMSEEDED)
+}
+
+// 取出当前状态的随机数源, 没有时用时间作种子创建一个
+func _randomSource(ls api.LuaState) RandomSource {
+	ls.GetField(api.LUA_REGISTRYINDEX, LUA_RANDOMSOURCE)
+	src, ok := ls.ToUserdata(-1).(RandomSource)
+	ls.Pop(1)
+	if !ok {
+		src = NewXoshiro256(_randSeed())
+		ls.NewUserdata(src)
+		ls.SetField(api.LUA_REGISTRYINDEX, LUA_RANDOMSOURCE)
+	}
+	return src
+}
+
+// 随机数源是否用固定的种子设置过, 这时它产生的序列可以重现
+func RandomSeeded(ls api.LuaState) bool {
+	ls.GetField(api.LUA_REGISTRYINDEX, LUA_RANDOMSEEDED)
+	seeded := ls.ToBoolean(-1)
+	ls.Pop(1)
+	return seeded
+}
+
+// 保存随机数源的内部状态, 给状态池和快照使用.
+// 随机数源没有实现 encoding.BinaryMarshaler 或者还没有随机数源时返回 nil
+func SaveRandom(ls api.LuaState) []byte {
+	ls.GetField(api.LUA_REGISTRYINDEX, LUA_RANDOMSOURCE)
+	m, ok := ls.ToUserdata(-1).(encoding.BinaryMarshaler)
+	ls.Pop(1)
+	if !ok {
+		return nil
+	}
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// 恢复 SaveRandom 保存的状态, 随机数源要实现 encoding.BinaryUnmarshaler
+func RestoreRandom(ls api.LuaState, data []byte) error {
+	ls.GetField(api.LUA_REGISTRYINDEX, LUA_RANDOMSOURCE)
+	u, ok := ls.ToUserdata(-1).(encoding.BinaryUnmarshaler)
+	ls.Pop(1)
+	if !ok {
+		return errors.New("random source cannot be restored")
+	}
+	return u.UnmarshalBinary(data)
+}
+
+// 用新的种子重置状态的随机数源, 和新建的状态一样; 还没有随机数源时什么也不做
+func ReseedRandom(ls api.LuaState) {
+	ls.GetField(api.LUA_REGISTRYINDEX, LUA_RANDOMSOURCE)
+	if src, ok := ls.ToUserdata(-1).(RandomSource); ok {
+		src.Seed(_randSeed())
+	}
+	ls.Pop(1)
+}
+
+var _seedCounter atomic.Uint64
+
+// 同一时刻创建的状态也得到不同的种子
+func _randSeed() (n1, n2 uint64) {
+	return uint64(time.Now().Unix()), _seedCounter.Add(1) ^ uint64(time.Now().UnixNano())
+}
+
+// Lua 5.4 使用的 xoshiro256** 算法
+type Xoshiro256 struct {
+	s [4]uint64
+}
+
+func NewXoshiro256(n1, n2 uint64) *Xoshiro256 {
+	x := &Xoshiro256{}
+	x.Seed(n1, n2)
+	return x
+}
+
+func (x *Xoshiro256) Uint64() uint64 {
+	s := &x.s
+	res := bits.RotateLeft64(s[1]*5, 7) * 9
+	t := s[1] << 17
+	s[2] ^= s[0]
+	s[3] ^= s[1]
+	s[1] ^= s[2]
+	s[0] ^= s[3]
+	s[2] ^= t
+	s[3] = bits.RotateLeft64(s[3], 45)
+	return res
+}
+
+func (x *Xoshiro256) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, 32)
+	for _, v := range x.s {
+		data = binary.LittleEndian.AppendUint64(data, v)
+	}
+	return data, nil
+}
+
+func (x *Xoshiro256) UnmarshalBinary(data []byte) error {
+	if len(data) != 32 {
+		return errors.New("xoshiro256: invalid state")
+	}
+	for i := range x.s {
+		x.s[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	return nil
+}
+
+func (x *Xoshiro256) Seed(n1, n2 uint64) {
+	x.s = [4]uint64{n1, 0xff, n2, 0} /* avoid a zero state */
+	for i := 0; i < 16; i++ {
+		x.Uint64() /* discard initial values to "spread" seed */
+	}
+}
+
+/* project a random integer into the interval [0, n] */
+func _project(ran, n uint64, src RandomSource) uint64 {
+	if n&(n+1) == 0 { /* is 'n + 1' a power of 2? */
+		return ran & n
+	}
+	/* compute the smallest (2^b - 1) not smaller than n */
+	lim := n
+	lim |= lim >> 1
+	lim |= lim >> 2
+	lim |= lim >> 4
+	lim |= lim >> 8
+	lim |= lim >> 16
+	lim |= lim >> 32
+	for ran &= lim; ran > n; ran &= lim { /* not inside [0, n]? */
+		ran = src.Uint64() /* try again */
+	}
+	return ran
+}
+
+/* convert the 53 higher bits of a random integer into a float in [0, 1) */
+func _randFloat(ran uint64) float64 {
+	return float64(ran>>11) * (1.0 / (1 << 53))
+}
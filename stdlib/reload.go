@@ -0,0 +1,177 @@
+package stdlib
+
+import "errors"
+import "sort"
+import "time"
+import "luago/api"
+
+// 注册表里记录每个 Lua 模块是从哪个文件加载的
+const LUA_MODULEFILES = "_MODULE_FILES"
+
+type moduleFile struct {
+	filename string
+	modTime  time.Time
+	size     int64
+}
+
+func _moduleFiles(ls api.LuaState) map[string]*moduleFile {
+	ls.GetField(api.LUA_REGISTRYINDEX, LUA_MODULEFILES)
+	files, ok := ls.ToUserdata(-1).(map[string]*moduleFile)
+	ls.Pop(1)
+	if !ok {
+		files = map[string]*moduleFile{}
+		ls.NewUserdata(files)
+		ls.SetField(api.LUA_REGISTRYINDEX, LUA_MODULEFILES)
+	}
+	return files
+}
+
+// 记下文件当前的修改时间和大小, 用来判断文件是否变化
+func _recordModuleFile(ls api.LuaState, name, filename string) {
+	mf := &moduleFile{filename: filename}
+	if info, err := _statFile(ls, filename); err == nil {
+		mf.modTime, mf.size = info.ModTime(), info.Size()
+	}
+	_moduleFiles(ls)[name] = mf
+}
+
+/*
+** Reload module 'name' from its source file and replace package.loaded[name].
+** If the new module is a table with a '__reload' function, it is called as
+** __reload(new, old) and a non-nil result becomes the module value.
+** On any error the old value is kept and the error is returned.
+ */
+func Reload(ls api.LuaState, name string) error {
+	top := ls.GetTop()
+	defer ls.SetTop(top)
+	if !_reload(ls, name) {
+		return errors.New(ls.ToString(-1))
+	}
+	return nil
+}
+
+/*
+** Reload every module whose source file changed since it was loaded.
+** There is no background watcher: states are not safe for concurrent use,
+** so the host polls by calling this from the goroutine that owns 'ls'
+** (e.g. on a time.Ticker). A file that fails to compile is not retried
+** until it changes again.
+ */
+func ReloadChanged(ls api.LuaState) (reloaded []string, err error) {
+	files := _moduleFiles(ls)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		mf := files[name]
+		info, statErr := _statFile(ls, mf.filename)
+		if statErr != nil || info.ModTime().Equal(mf.modTime) && info.Size() == mf.size {
+			continue /* removed or unchanged */
+		}
+		if e := Reload(ls, name); e != nil {
+			errs = append(errs, e)
+			mf.modTime, mf.size = info.ModTime(), info.Size()
+		} else {
+			reloaded = append(reloaded, name)
+		}
+	}
+	return reloaded, errors.Join(errs...)
+}
+
+// package.reload (name) -> new module value, or nil plus error message
+func pkgReload(ls api.LuaState) int {
+	name := ls.CheckString(1)
+	ls.SetTop(1)
+	if !_reload(ls, name) {
+		ls.PushNil()
+		ls.Insert(-2)
+		return 2
+	}
+	return 1
+}
+
+// 成功时把新的模块值留在栈顶, 失败时留下错误信息
+func _reload(ls api.LuaState, name string) bool {
+	var filename string
+	if mf := _moduleFiles(ls)[name]; mf != nil {
+		filename = mf.filename
+	} else { /* not loaded from a file yet: search it like 'require' */
+		path, ok := "", false
+		ls.GetField(api.LUA_REGISTRYINDEX, LUA_LOADED_TABLE)
+		if ls.GetField(-1, "package") == api.LUA_TTABLE {
+			ls.GetField(-1, "path")
+			path, ok = ls.ToStringX(-1)
+			ls.Pop(1)
+		}
+		ls.Pop(2)
+		if !ok {
+			ls.PushString("'package.path' must be a string")
+			return false
+		}
+		var errMsg string
+		if filename, errMsg = _searchPath(ls, name, path, ".", LUA_DIRSEP); filename == "" {
+			ls.PushString("module '" + name + "' not found:" + errMsg)
+			return false
+		}
+	}
+
+	ls.GetField(api.LUA_REGISTRYINDEX, LUA_LOADED_TABLE)
+	loaded := ls.GetTop()
+	ls.GetField(loaded, name) /* old value */
+	old := ls.GetTop()
+
+	if ls.LoadFile(filename) != api.LUA_OK {
+		ls.PushFString("error loading module '%s' from file '%s':\n\t%s",
+			name, filename, ls.ToString(-1))
+		return false
+	}
+	_recordModuleFile(ls, name, filename)
+	ls.PushString(name)
+	ls.PushString(filename)
+	if ls.PCall(2, 1, 0) != api.LUA_OK {
+		return _restoreModule(ls, name, loaded, old)
+	}
+	if ls.IsNil(-1) { /* module may have set package.loaded itself */
+		ls.Pop(1)
+		ls.GetField(loaded, name)
+		if ls.IsNil(-1) || ls.RawEqual(-1, old) {
+			ls.Pop(1)
+			ls.PushBoolean(true)
+		}
+	}
+
+	/* let the new version migrate state from the old one */
+	if ls.Type(-1) == api.LUA_TTABLE {
+		if ls.GetField(-1, "__reload") != api.LUA_TFUNCTION {
+			ls.Pop(1) /* no hook */
+		} else {
+			ls.PushValue(-2) /* new */
+			ls.PushValue(old)
+			if ls.PCall(2, 1, 0) != api.LUA_OK {
+				return _restoreModule(ls, name, loaded, old)
+			}
+			if ls.IsNil(-1) {
+				ls.Pop(1) /* keep new */
+			} else {
+				ls.Remove(-2) /* result replaces new */
+			}
+		}
+	}
+
+	ls.PushValue(-1)
+	ls.SetField(loaded, name) /* LOADED[name] = new */
+	return true
+}
+
+// 出错时恢复原来的值, 错误信息留在栈顶
+func _restoreModule(ls api.LuaState, name string, loaded, old int) bool {
+	msg := ls.ToString(-1)
+	ls.PushValue(old)
+	ls.SetField(loaded, name)
+	ls.PushFString("error reloading module '%s':\n\t%s", name, msg)
+	return false
+}
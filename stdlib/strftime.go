@@ -0,0 +1,138 @@
+package stdlib
+
+import "fmt"
+import "strings"
+import "time"
+import "luago/api"
+
+// gmtime 的时区名
+var _gmt = time.FixedZone("GMT", 0)
+
+/* options for ANSI C 89 (only 1-char options) and C99 (with modifiers) */
+var _strftimeOptions = []string{
+	"aAbBcCdDeFgGhHIjmMnprRStTuUVwWxXyYzZ%",
+	"EcECExEXEyEY",
+	"OdOeOHOIOmOMOSOuOUOVOwOWOy",
+}
+
+// C 语言环境下的 strftime
+func _strftime(ls api.LuaState, format string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(format); {
+		if format[i] != '%' { /* not a conversion specifier? */
+			b.WriteByte(format[i])
+			i++
+			continue
+		}
+		i++ /* skip '%' */
+		conv := _checkOption(ls, format[i:])
+		i += len(conv)
+		b.WriteString(_strftimeConv(conv[len(conv)-1], t))
+	}
+	return b.String()
+}
+
+// 返回合法的转换说明符, E 和 O 修饰符在 C 语言环境下没有作用
+func _checkOption(ls api.LuaState, conv string) string {
+	for n, options := range _strftimeOptions {
+		oplen := n + 1 - n/2 /* 1, 2, 2 */
+		if oplen > len(conv) {
+			continue
+		}
+		for i := 0; i+oplen <= len(options); i += oplen {
+			if options[i:i+oplen] == conv[:oplen] {
+				return conv[:oplen]
+			}
+		}
+	}
+	ls.ArgError(1, fmt.Sprintf("invalid conversion specifier '%%%s'", conv))
+	return ""
+}
+
+func _strftimeConv(c byte, t time.Time) string {
+	switch c {
+	case 'a':
+		return t.Weekday().String()[:3]
+	case 'A':
+		return t.Weekday().String()
+	case 'b', 'h':
+		return t.Month().String()[:3]
+	case 'B':
+		return t.Month().String()
+	case 'c':
+		return _strftimeConv('a', t) + " " + _strftimeConv('b', t) + " " +
+			_strftimeConv('e', t) + " " + _strftimeConv('T', t) + " " +
+			_strftimeConv('Y', t)
+	case 'C':
+		return fmt.Sprintf("%02d", t.Year()/100)
+	case 'd':
+		return fmt.Sprintf("%02d", t.Day())
+	case 'D', 'x':
+		return fmt.Sprintf("%02d/%02d/%02d", t.Month(), t.Day(), t.Year()%100)
+	case 'e':
+		return fmt.Sprintf("%2d", t.Day())
+	case 'F':
+		return fmt.Sprintf("%d-%02d-%02d", t.Year(), t.Month(), t.Day())
+	case 'g':
+		year, _ := t.ISOWeek()
+		return fmt.Sprintf("%02d", year%100)
+	case 'G':
+		year, _ := t.ISOWeek()
+		return fmt.Sprintf("%d", year)
+	case 'H':
+		return fmt.Sprintf("%02d", t.Hour())
+	case 'I':
+		return fmt.Sprintf("%02d", (t.Hour()+11)%12+1)
+	case 'j':
+		return fmt.Sprintf("%03d", t.YearDay())
+	case 'm':
+		return fmt.Sprintf("%02d", t.Month())
+	case 'M':
+		return fmt.Sprintf("%02d", t.Minute())
+	case 'n':
+		return "\n"
+	case 'p':
+		if t.Hour() < 12 {
+			return "AM"
+		}
+		return "PM"
+	case 'r':
+		return _strftimeConv('I', t) + ":" + _strftimeConv('M', t) + ":" +
+			_strftimeConv('S', t) + " " + _strftimeConv('p', t)
+	case 'R':
+		return fmt.Sprintf("%02d:%02d", t.Hour(), t.Minute())
+	case 'S':
+		return fmt.Sprintf("%02d", t.Second())
+	case 't':
+		return "\t"
+	case 'T', 'X':
+		return fmt.Sprintf("%02d:%02d:%02d", t.Hour(), t.Minute(), t.Second())
+	case 'u':
+		return fmt.Sprintf("%d", (int(t.Weekday())+6)%7+1)
+	case 'U': /* week of the year, Sunday as the first day */
+		return fmt.Sprintf("%02d", (t.YearDay()+6-int(t.Weekday()))/7)
+	case 'V':
+		_, week := t.ISOWeek()
+		return fmt.Sprintf("%02d", week)
+	case 'w':
+		return fmt.Sprintf("%d", t.Weekday())
+	case 'W': /* week of the year, Monday as the first day */
+		return fmt.Sprintf("%02d", (t.YearDay()+6-(int(t.Weekday())+6)%7)/7)
+	case 'y':
+		return fmt.Sprintf("%02d", t.Year()%100)
+	case 'Y':
+		return fmt.Sprintf("%d", t.Year())
+	case 'z':
+		_, offset := t.Zone()
+		sign := '+'
+		if offset < 0 {
+			sign, offset = '-', -offset
+		}
+		return fmt.Sprintf("%c%02d%02d", sign, offset/3600, offset/60%60)
+	case 'Z':
+		name, _ := t.Zone()
+		return name
+	default: /* '%' */
+		return "%"
+	}
+}
@@ -0,0 +1,23 @@
+-- ADD* / SUB* / ... 的立即数和常量形式, 以及后面的 MMBIN / MMBINI / MMBINK
+local a, b, f = 10, 3, 2.5
+print(a + b, a - b, a * b, a % b, a ^ b, a / b, a // b)
+print(a + 1, a - 1, 1 + a, 1 - a, a + 300, a - 300)
+print(a + f, a * 2.0, a % 4, a ^ 2, a / 4, a // 4, f // 1)
+print(a & 6, a | 5, a ~ 3, a << 2, a >> 1, 1 << a, a << b, a >> b)
+print(-a, ~a, not a, #"abc", "x" .. a .. f)
+
+local log = {}
+local mt = {}
+for _, e in ipairs{"add", "sub", "mul", "mod", "pow", "div", "idiv",
+                   "band", "bor", "bxor", "shl", "shr"} do
+  mt["__" .. e] = function(x, y)
+    log[#log + 1] = e .. "(" .. (x == t and "t" or tostring(x)) .. ","
+      .. (y == t and "t" or tostring(y)) .. ")"
+    return e
+  end
+end
+t = setmetatable({}, mt)
+local _ = t + 1, 1 + t, t - 1, 1 - t, t * 2, 2 * t, t % 3, t ^ 2,
+  t / 2, t // 2, t & 1, 1 & t, t | 1, t ~ 1, t << 1, 1 << t, t >> 1, 1 >> t,
+  t + 2.5, t - 2.5, t + 1000, t - 1000, t + b, b - t, t - "s"
+print(table.concat(log, " "))
@@ -0,0 +1,518 @@
+// 把 luac5.4 -l -l 格式的列表 (*.lst) 汇编成 5.4 的二进制 chunk (*.luac).
+// 没有 luac5.4 的时候用它生成测试用的字节码; 装了 luac5.4 以后 run.sh -update
+// 会用真正的编译结果覆盖 *.lst 和 *.luac.
+//
+// 列表的格式和 luac 的输出相同: 函数头, 指令, 常量, 局部变量, upvalue,
+// 然后是各个子函数. 和 luac 的输出不同的地方只有两处: luac 不打印
+// EQI/LTI/LEI/GTI/GEI 的 C (立即数原来是不是浮点数) 和 upvalue 的种类,
+// 需要时分别写成指令的第四个操作数和 upvalue 的第五列.
+//
+//	go run ./testdata/lua54/asm testdata/lua54/*.lst
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var opnames = strings.Fields(`MOVE LOADI LOADF LOADK LOADKX LOADFALSE LFALSESKIP
+	LOADTRUE LOADNIL GETUPVAL SETUPVAL GETTABUP GETTABLE GETI GETFIELD SETTABUP
+	SETTABLE SETI SETFIELD NEWTABLE SELF ADDI ADDK SUBK MULK MODK POWK DIVK IDIVK
+	BANDK BORK BXORK SHRI SHLI ADD SUB MUL MOD POW DIV IDIV BAND BOR BXOR SHL SHR
+	MMBIN MMBINI MMBINK UNM BNOT NOT LEN CONCAT CLOSE TBC JMP EQ LT LE EQK EQI LTI
+	LEI GTI GEI TEST TESTSET CALL TAILCALL RETURN RETURN0 RETURN1 FORLOOP FORPREP
+	TFORPREP TFORCALL TFORLOOP SETLIST CLOSURE VARARG VARARGPREP EXTRAARG`)
+
+/*
+** 操作数的写法, 和 luac.c 的 PrintCode 一致:
+** A B C 是 8 位字段, sB sC 是带偏移的有符号立即数, k 是可选的标志,
+** Bx sBx Ax sJ 是长字段; 后缀 "?" 表示 luac 不打印, 可以省略的操作数
+ */
+var layouts = map[string]string{
+	"MOVE": "A B", "LOADI": "A sBx", "LOADF": "A sBx", "LOADK": "A Bx",
+	"LOADKX": "A", "LOADFALSE": "A", "LFALSESKIP": "A", "LOADTRUE": "A",
+	"LOADNIL": "A B", "GETUPVAL": "A B", "SETUPVAL": "A B",
+	"GETTABUP": "A B C", "GETTABLE": "A B C", "GETI": "A B C", "GETFIELD": "A B C",
+	"SETTABUP": "A B Ck", "SETTABLE": "A B Ck", "SETI": "A B Ck", "SETFIELD": "A B Ck",
+	"NEWTABLE": "A B C k?", "SELF": "A B Ck",
+	"ADDI": "A B sC", "SHRI": "A B sC", "SHLI": "A B sC",
+	"MMBIN": "A B C", "MMBINI": "A sB C k", "MMBINK": "A B C k",
+	"UNM": "A B", "BNOT": "A B", "NOT": "A B", "LEN": "A B", "CONCAT": "A B",
+	"CLOSE": "A", "TBC": "A", "JMP": "sJ",
+	"EQ": "A B k", "LT": "A B k", "LE": "A B k", "EQK": "A B k",
+	"EQI": "A sB k C?", "LTI": "A sB k C?", "LEI": "A sB k C?",
+	"GTI": "A sB k C?", "GEI": "A sB k C?",
+	"TEST": "A k", "TESTSET": "A B k",
+	"CALL": "A B C", "TAILCALL": "A B Ck", "RETURN": "A B Ck", "RETURN0": "",
+	"RETURN1": "A", "FORLOOP": "A Bx", "FORPREP": "A Bx", "TFORPREP": "A Bx",
+	"TFORCALL": "A C", "TFORLOOP": "A Bx", "SETLIST": "A B C k?",
+	"CLOSURE": "A Bx", "VARARG": "A C", "VARARGPREP": "A", "EXTRAARG": "Ax",
+}
+
+func init() {
+	for _, op := range []string{"ADDK", "SUBK", "MULK", "MODK", "POWK", "DIVK", "IDIVK",
+		"BANDK", "BORK", "BXORK", "ADD", "SUB", "MUL", "MOD", "POW", "DIV", "IDIV",
+		"BAND", "BOR", "BXOR", "SHL", "SHR"} {
+		layouts[op] = "A B C"
+	}
+}
+
+const (
+	offsetSC  = (1<<8 - 1) >> 1
+	offsetSBx = (1<<17 - 1) >> 1
+	offsetSJ  = (1<<25 - 1) >> 1
+)
+
+type proto struct {
+	source       string
+	lineDefined  int
+	lastLine     int
+	numParams    int
+	isVararg     bool
+	maxStack     int
+	code         []uint32
+	lines        []int
+	constants    []interface{}
+	locals       []local
+	upvalues     []upvalue
+	numProtos    int
+	protos       []*proto
+	numConstants int
+	numLocals    int
+	numUpvalues  int
+}
+
+type local struct {
+	name       string
+	start, end int
+}
+
+type upvalue struct {
+	name           string
+	instack, index int
+	kind           int
+}
+
+type parser struct {
+	lines []string
+	pos   int
+}
+
+var (
+	reHeader = regexp.MustCompile(`^(main|function) <(.*):(\d+),(\d+)> \((\d+) instructions?`)
+	reCounts = regexp.MustCompile(`^(\d+)(\+?) params?, (\d+) slots?, (\d+) upvalues?, (\d+) locals?, (\d+) constants?, (\d+) functions?`)
+	reInst   = regexp.MustCompile(`^\s*(\d+)\s+\[(-|\d+)\]\s+([A-Z0-9]+)\s*([^;]*)`)
+	reTable  = regexp.MustCompile(`^(constants|locals|upvalues) \((\d+)\)`)
+)
+
+func main() {
+	for _, filename := range os.Args[1:] {
+		if err := assemble(filename); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func assemble(filename string) (err error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	p := &parser{}
+	for _, line := range strings.Split(string(data), "\n") {
+		p.lines = append(p.lines, strings.TrimRight(line, "\r"))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s:%d: %v", filename, p.pos, r)
+		}
+	}()
+	main := p.function()
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		panic("unexpected text after main function")
+	}
+	out := strings.TrimSuffix(filename, ".lst") + ".luac"
+	return os.WriteFile(out, dump(main), 0o644)
+}
+
+func (p *parser) skipBlank() {
+	for p.pos < len(p.lines) && strings.TrimSpace(p.lines[p.pos]) == "" {
+		p.pos++
+	}
+}
+
+func (p *parser) next() string {
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		panic("unexpected end of listing")
+	}
+	p.pos++
+	return p.lines[p.pos-1]
+}
+
+func (p *parser) function() *proto {
+	m := reHeader.FindStringSubmatch(p.next())
+	if m == nil {
+		panic("function header expected")
+	}
+	f := &proto{source: m[2], lineDefined: atoi(m[3]), lastLine: atoi(m[4])}
+	ninst := atoi(m[5])
+	c := reCounts.FindStringSubmatch(p.next())
+	if c == nil {
+		panic("function counts expected")
+	}
+	f.numParams, f.isVararg, f.maxStack = atoi(c[1]), c[2] == "+", atoi(c[3])
+	f.numUpvalues, f.numLocals = atoi(c[4]), atoi(c[5])
+	f.numConstants, f.numProtos = atoi(c[6]), atoi(c[7])
+
+	for i := 0; i < ninst; i++ {
+		m := reInst.FindStringSubmatch(p.next())
+		if m == nil || atoi(m[1]) != i+1 {
+			panic(fmt.Sprintf("instruction %d expected", i+1))
+		}
+		line := 0
+		if m[2] != "-" {
+			line = atoi(m[2])
+		}
+		f.lines = append(f.lines, line)
+		f.code = append(f.code, encode(m[3], strings.Fields(m[4])))
+	}
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		m := reTable.FindStringSubmatch(p.lines[p.pos])
+		if m == nil {
+			break
+		}
+		p.pos++
+		n := atoi(m[2])
+		for i := 0; i < n; i++ {
+			fields := strings.SplitN(strings.TrimLeft(p.next(), " \t"), "\t", 3)
+			if atoi(fields[0]) != i {
+				panic(fmt.Sprintf("%s entry %d expected", m[1], i))
+			}
+			switch m[1] {
+			case "constants":
+				f.constants = append(f.constants, constant(fields[1], fields[2]))
+			case "locals":
+				cols := strings.Split(fields[2], "\t")
+				f.locals = append(f.locals, local{fields[1], atoi(cols[0]) - 1, atoi(cols[1]) - 1})
+			case "upvalues":
+				cols := strings.Split(fields[2], "\t")
+				uv := upvalue{name: fields[1], instack: atoi(cols[0]), index: atoi(cols[1])}
+				if len(cols) > 2 {
+					uv.kind = atoi(cols[2])
+				}
+				f.upvalues = append(f.upvalues, uv)
+			}
+		}
+	}
+	if len(f.constants) != f.numConstants || len(f.upvalues) != f.numUpvalues ||
+		len(f.locals) != f.numLocals {
+		panic("table sizes do not match the function header")
+	}
+	for i := 0; i < f.numProtos; i++ {
+		f.protos = append(f.protos, p.function())
+	}
+	return f
+}
+
+func atoi(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func encode(name string, args []string) uint32 {
+	op := -1
+	for i, n := range opnames {
+		if n == name {
+			op = i
+		}
+	}
+	layout, ok := layouts[name]
+	if op < 0 || !ok {
+		panic("unknown opcode " + name)
+	}
+	var a, b, c, k, bx int
+	var long, signedJ bool
+	operands := strings.Fields(layout)
+	for i, operand := range operands {
+		optional := strings.HasSuffix(operand, "?")
+		operand = strings.TrimSuffix(operand, "?")
+		if i >= len(args) {
+			if optional {
+				continue
+			}
+			panic(fmt.Sprintf("%s: missing operand %s", name, operand))
+		}
+		arg := args[i]
+		if operand == "Ck" && strings.HasSuffix(arg, "k") {
+			arg, k = strings.TrimSuffix(arg, "k"), 1
+		}
+		v := atoi(arg)
+		switch operand {
+		case "A":
+			a = v
+		case "B":
+			b = v
+		case "sB":
+			b = v + offsetSC
+		case "C", "Ck":
+			c = v
+		case "sC":
+			c = v + offsetSC
+		case "k":
+			k = v
+		case "Bx":
+			bx, long = v, true
+		case "sBx":
+			bx, long = v+offsetSBx, true
+		case "Ax":
+			bx, long = v, true
+			a = 0
+		case "sJ":
+			bx, signedJ = v+offsetSJ, true
+		}
+	}
+	if len(args) > len(operands) {
+		panic(fmt.Sprintf("%s: too many operands", name))
+	}
+	checkField(name, a, 0xFF)
+	checkField(name, b, 0xFF)
+	checkField(name, c, 0xFF)
+	switch {
+	case signedJ || name == "EXTRAARG":
+		checkField(name, bx, 1<<25-1)
+		return uint32(op) | uint32(bx)<<7
+	case long:
+		checkField(name, bx, 1<<17-1)
+		return uint32(op) | uint32(a)<<7 | uint32(bx)<<15
+	}
+	return uint32(op) | uint32(a)<<7 | uint32(k)<<15 | uint32(b)<<16 | uint32(c)<<24
+}
+
+func checkField(name string, v, max int) {
+	if v < 0 || v > max {
+		panic(fmt.Sprintf("%s: operand %d out of range", name, v))
+	}
+}
+
+func constant(kind, text string) interface{} {
+	switch kind {
+	case "N":
+		return nil
+	case "B":
+		return text == "true"
+	case "I":
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			panic(err)
+		}
+		return n
+	case "F":
+		switch text {
+		case "inf":
+			return math.Inf(1)
+		case "-inf":
+			return math.Inf(-1)
+		case "nan", "-nan":
+			return math.NaN()
+		}
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			panic(err)
+		}
+		return f
+	case "S":
+		return unquote(text)
+	}
+	panic("unknown constant type " + kind)
+}
+
+// luac.c 的 PrintString 的逆过程
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		panic("bad string constant " + s)
+	}
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '"':
+			b.WriteByte(s[i])
+		default:
+			n, err := strconv.Atoi(s[i : i+3])
+			if err != nil {
+				panic("bad escape in " + s)
+			}
+			b.WriteByte(byte(n))
+			i += 2
+		}
+	}
+	return b.String()
+}
+
+/* ldump.c */
+
+type writer struct {
+	buf []byte
+}
+
+func dump(main *proto) []byte {
+	w := &writer{}
+	w.buf = append(w.buf, "\x1bLua\x54\x00\x19\x93\r\n\x1a\n\x04\x08\x08"...)
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, 0x5678)
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(370.5))
+	w.buf = append(w.buf, byte(len(main.upvalues)))
+	w.function(main, "")
+	return w.buf
+}
+
+func (w *writer) size(x int) {
+	var b [10]byte
+	n := len(b) - 1
+	b[n] = byte(x&0x7F) | 0x80
+	for x >>= 7; x != 0; x >>= 7 {
+		n--
+		b[n] = byte(x & 0x7F)
+	}
+	w.buf = append(w.buf, b[n:]...)
+}
+
+func (w *writer) str(s *string) {
+	if s == nil {
+		w.size(0)
+		return
+	}
+	w.size(len(*s) + 1)
+	w.buf = append(w.buf, *s...)
+}
+
+func (w *writer) function(f *proto, psource string) {
+	source := "@" + f.source
+	if f.source == "stdin" {
+		source = "=stdin"
+	}
+	if source == psource {
+		w.str(nil)
+	} else {
+		w.str(&source)
+	}
+	w.size(f.lineDefined)
+	w.size(f.lastLine)
+	vararg := 0
+	if f.isVararg {
+		vararg = 1
+	}
+	w.buf = append(w.buf, byte(f.numParams), byte(vararg), byte(f.maxStack))
+	w.size(len(f.code))
+	for _, inst := range f.code {
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, inst)
+	}
+	w.constants(f)
+	w.size(len(f.upvalues))
+	for _, uv := range f.upvalues {
+		w.buf = append(w.buf, byte(uv.instack), byte(uv.index), byte(uv.kind))
+	}
+	w.size(len(f.protos))
+	for _, child := range f.protos {
+		w.function(child, source)
+	}
+	w.debug(f)
+}
+
+func (w *writer) constants(f *proto) {
+	w.size(len(f.constants))
+	for _, k := range f.constants {
+		switch x := k.(type) {
+		case nil:
+			w.buf = append(w.buf, 0x00)
+		case bool:
+			if x {
+				w.buf = append(w.buf, 0x11)
+			} else {
+				w.buf = append(w.buf, 0x01)
+			}
+		case int64:
+			w.buf = append(w.buf, 0x03)
+			w.buf = binary.LittleEndian.AppendUint64(w.buf, uint64(x))
+		case float64:
+			w.buf = append(w.buf, 0x13)
+			w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(x))
+		case string:
+			if len(x) <= 40 { /* LUAI_MAXSHORTLEN */
+				w.buf = append(w.buf, 0x04)
+			} else {
+				w.buf = append(w.buf, 0x14)
+			}
+			w.str(&x)
+		}
+	}
+}
+
+/* lcode.c#savelineinfo */
+const (
+	limLineDiff = 0x80
+	absLineInfo = -0x80
+	maxIWthAbs  = 128
+)
+
+func (w *writer) debug(f *proto) {
+	type absLine struct{ pc, line int }
+	var rel []byte
+	var abs []absLine
+	prev, iwthabs := f.lineDefined, 0
+	for pc, line := range f.lines {
+		diff := line - prev
+		if diff <= -limLineDiff || diff >= limLineDiff || iwthabs >= maxIWthAbs {
+			abs = append(abs, absLine{pc, line})
+			diff, iwthabs = absLineInfo, 0
+		}
+		iwthabs++
+		rel = append(rel, byte(int8(diff)))
+		prev = line
+	}
+	w.size(len(rel))
+	w.buf = append(w.buf, rel...)
+	w.size(len(abs))
+	for _, a := range abs {
+		w.size(a.pc)
+		w.size(a.line)
+	}
+	w.size(len(f.locals))
+	for _, l := range f.locals {
+		w.str(&l.name)
+		w.size(l.start)
+		w.size(l.end)
+	}
+	w.size(len(f.upvalues))
+	for _, uv := range f.upvalues {
+		w.str(&uv.name)
+	}
+}
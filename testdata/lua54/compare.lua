@@ -0,0 +1,14 @@
+-- EQ / LT / LE 及 EQK / EQI / LTI / LEI / GTI / GEI
+local a, s = 5, "b"
+print(a == 5, a ~= 5, a < 5, a <= 5, a > 5, a >= 5)
+print(5 == a, 4 < a, 6 <= a, a > 4.5, a >= 5.0, a == 5.0)
+print(s == "b", s ~= "b", s < "c", "a" <= s, a == "5", nil == false)
+local mt = {__lt = function(x, y) return true end, __le = function(x, y) return false end}
+local t = setmetatable({}, mt)
+print(t < 1, t <= 1, 1 < t, 1 <= t, t > 1, t >= 1)
+local n = 0
+if a then n = n + 1 end
+if not s then n = n + 10 end
+local x = a and s or "no"
+local y = nil or false or a
+print(n, x, y)
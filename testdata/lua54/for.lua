@@ -0,0 +1,22 @@
+-- FORPREP / FORLOOP / TFORPREP / TFORCALL / TFORLOOP
+local out = {}
+for i = 1, 3 do out[#out + 1] = i end
+for i = 3, 1, -1 do out[#out + 1] = i end
+for i = 1, 2, 0.5 do out[#out + 1] = i end
+for i = 1.0, 3 do out[#out + 1] = i end
+for i = math.maxinteger - 1, math.maxinteger do out[#out + 1] = i end
+for i = 1, 0 do out[#out + 1] = "never" end
+for i = 1, 3.9 do out[#out + 1] = i end
+print(table.concat(out, " "))
+
+local keys = {}
+for i, v in ipairs({"a", "b", "c"}) do keys[#keys + 1] = i .. v end
+for k in pairs({x = 1}) do keys[#keys + 1] = k end
+print(table.concat(keys, " "))
+
+for _, v in ipairs{{"1", 2}, {1, "2"}, {1, 2, "1"}, {1, 2, 0}} do
+  local ok, err = pcall(function()
+    for i = v[1], v[2], v[3] or 1 do end
+  end)
+  print(ok, (err:gsub("^.-:%d+: ", "")))
+end
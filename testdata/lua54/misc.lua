@@ -0,0 +1,46 @@
+-- LOADI / LOADF / LOADFALSE / LFALSESKIP / LOADTRUE, GETI / SETI / GETFIELD /
+-- SETFIELD / SELF, 提升值, VARARG, TAILCALL, RETURN0 / RETURN1, TBC / CLOSE
+local i, f, big, no, yes = 7, 1.5, 1 << 40, false, true
+print(i, f, big, no, yes, 2 > 1, 1 > 2, -0.0, 1e300)
+
+local t = {10, 20, 30, x = "X", [300] = "big"}
+t[4] = 40
+t.y = "Y"
+t[1.0] = 11
+print(t[1], t[4], t[300], t.x, t.y, #t)
+
+local obj = {n = 1}
+function obj:inc(d) self.n = self.n + d; return self end
+print(obj:inc(2):inc(3).n)
+
+local function counter()
+  local c = 0
+  return function() c = c + 1; return c end
+end
+local c1, c2 = counter(), counter()
+c1(); c1()
+print(c1(), c2())
+
+local function va(...)
+  local a, b = ...
+  return select("#", ...), a, b, ...
+end
+print(va(1, nil, 3))
+print((va(1, 2)))
+
+local function tail(n) if n == 0 then return "done" end return tail(n - 1) end
+local function none() end
+local function one() return 1 end
+print(tail(10000), none(), one())
+
+local closed = {}
+do
+  local x <close> = setmetatable({}, {__close = function(_, e) closed[#closed + 1] = "x:" .. tostring(e) end})
+  local y <const> = 5
+  closed[#closed + 1] = "body" .. y
+end
+print(pcall(function()
+  local z <close> = setmetatable({}, {__close = function(_, e) closed[#closed + 1] = "z" end})
+  error("boom", 0)
+end))
+print(table.concat(closed, " "))
@@ -0,0 +1,33 @@
+#!/bin/sh
+# 用 luac5.4 编译这个目录下的 *.lua, 让 luago 执行编译出的字节码,
+# 和 lua5.4 直接执行源码的输出 (*.out) 比较.
+# -update 重新生成 *.lst, *.luac 和 *.out, 需要安装 lua5.4 和 luac5.4.
+# 提交的 *.luac 是 asm 从 *.lst 汇编出来的 (go run ./testdata/lua54/asm),
+# *.lst 按 luac 5.4.6 的输出格式手写, 装了 luac5.4 以后应该用 -update 覆盖
+set -e
+cd "$(dirname "$0")"
+root=../..
+if [ "$1" = "-update" ]; then
+	for src in *.lua; do
+		name=${src%.lua}
+		luac5.4 -l -l -o "$name.luac" "$src" >"$name.lst"
+		lua5.4 "$src" >"$name.out" 2>&1
+	done
+fi
+
+status=0
+for src in *.lua; do
+	name=${src%.lua}
+	if [ ! -f "$name.luac" ]; then
+		echo "missing $name.luac, run $0 -update" >&2
+		status=1
+		continue
+	fi
+	if (cd $root && go run . testdata/lua54/"$name.luac") 2>&1 | diff -u "$name.out" - ; then
+		echo "ok   $name"
+	else
+		echo "FAIL $name"
+		status=1
+	fi
+done
+exit $status
@@ -0,0 +1,9 @@
+-- 终结器里调用 os.exit(code, true): 关闭状态时剩下的终结器照常执行, 然后退出
+local function mk(name, f)
+  return setmetatable({}, {__gc = function() print("gc " .. name); if f then f() end end})
+end
+a = mk("a")
+mk("b", function() os.exit(0, true) end)
+mk("c")
+collectgarbage()
+print("not reached")
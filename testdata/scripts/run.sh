@@ -0,0 +1,23 @@
+#!/bin/sh
+# 用 luago 执行这个目录下的 *.lua, 和参考实现 lua5.3 的输出 (*.out) 比较.
+# -update 用 lua5.3 重新生成 *.out
+set -e
+cd "$(dirname "$0")"
+root=../..
+if [ "$1" = "-update" ]; then
+	for src in *.lua; do
+		lua5.3 "$src" >"${src%.lua}.out" 2>&1 || true
+	done
+fi
+
+status=0
+for src in *.lua; do
+	name=${src%.lua}
+	if (cd $root && go run . testdata/scripts/"$src") 2>&1 | diff -u "$name.out" - ; then
+		echo "ok   $name"
+	else
+		echo "FAIL $name"
+		status=1
+	fi
+done
+exit $status
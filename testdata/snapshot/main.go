@@ -0,0 +1,51 @@
+// 快照的回归测试: 执行每个脚本, 保存快照, 在新打开的状态里恢复,
+// 然后分别在原来的状态和恢复的状态里调用全局函数 check, 两边的输出应该相同.
+// 用 run.sh 执行, 它把输出和 *.out 比较
+package main
+
+import (
+	"fmt"
+	"luago/api"
+	"luago/state"
+	"os"
+)
+
+func main() {
+	for _, filename := range os.Args[1:] {
+		if err := run(filename); err != nil {
+			fmt.Println("error:", err)
+		}
+	}
+}
+
+func run(filename string) error {
+	orig := state.New()
+	orig.OpenLibs()
+	if orig.LoadFile(filename) != api.LUA_OK || orig.PCall(0, 0, 0) != api.LUA_OK {
+		return fmt.Errorf("%s", orig.ToString(-1))
+	}
+	data, err := orig.Snapshot()
+	if err != nil {
+		return err
+	}
+	restored := state.New()
+	restored.OpenLibs()
+	if err = restored.Restore(data); err != nil {
+		return err
+	}
+
+	fmt.Println("-- original")
+	if err = check(orig); err != nil {
+		return err
+	}
+	fmt.Println("-- restored")
+	return check(restored)
+}
+
+func check(ls api.LuaState) error {
+	ls.GetGlobal("check")
+	if ls.PCall(0, 0, 0) != api.LUA_OK {
+		return fmt.Errorf("%s", ls.ToString(-1))
+	}
+	return nil
+}
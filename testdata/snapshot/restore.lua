@@ -0,0 +1,46 @@
+-- 全局变量别名, 共享的 upvalue, 挂起的协程和它们打开的 upvalue, 环, 随机数
+pr = print -- 恢复时 "_G.pr" 不存在, 要用 "_G.print" 找到它
+math.randomseed(7)
+
+local n = 0
+function inc() n = n + 1; return n end
+function add(d) n = n + d; return n end
+
+gen = coroutine.wrap(function(a)
+  local x = a
+  local function bump() x = x + 1; return x end
+  while true do a = coroutine.yield(bump(), a) end
+end)
+gen(100)
+
+co = coroutine.create(function(...)
+  local t = {...}
+  local got = coroutine.yield(#t)
+  return select("#", ...), t[2], got
+end)
+coroutine.resume(co, 1, 2, 3)
+
+-- 协程外面的闭包引用协程栈上还打开着的 upvalue
+co2 = coroutine.create(function()
+  local x = 1
+  getx = function() return x end
+  while true do x = x * 2; coroutine.yield() end
+end)
+coroutine.resume(co2)
+
+local shared = {}
+cyc = {shared = shared}
+cyc.self = cyc
+alias = shared
+weak = setmetatable({shared}, {__mode = "v"})
+
+function check()
+  pr(inc(), add(10), inc())
+  pr(gen(1))
+  pr(gen(2))
+  pr(coroutine.resume(co, "x"))
+  pr(coroutine.status(co), coroutine.resume(co))
+  pr(getx(), coroutine.resume(co2), getx())
+  pr(cyc.self == cyc, cyc.shared == alias, weak[1] == alias)
+  pr(math.random(1000), math.random(1000), math.random(0) ~= 0)
+end
@@ -0,0 +1,20 @@
+#!/bin/sh
+# 对这个目录下的每个 *.lua 做快照和恢复, 输出和 *.out 比较.
+# -update 用当前的输出重新生成 *.out, 更新前先确认两半输出相同
+set -e
+cd "$(dirname "$0")"
+root=../..
+status=0
+for src in *.lua; do
+	name=${src%.lua}
+	if [ "$1" = "-update" ]; then
+		(cd $root && go run ./testdata/snapshot testdata/snapshot/"$src") >"$name.out" 2>&1
+	fi
+	if (cd $root && go run ./testdata/snapshot testdata/snapshot/"$src") 2>&1 | diff -u "$name.out" - ; then
+		echo "ok   $name"
+	else
+		echo "FAIL $name"
+		status=1
+	fi
+done
+exit $status
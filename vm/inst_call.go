@@ -68,6 +68,7 @@ func _return(i Instruction, vm api.LuaVM) {
 	} else {
 		_fixStack(a, vm)
 	}
+	vm.CloseUpvalues(1) // 返回值已经在栈顶, 再关闭 <close> 变量
 }
 
 func vararg(i Instruction, vm api.LuaVM) {
@@ -109,3 +110,32 @@ func tForCall(i Instruction, vm api.LuaVM) {
 	vm.Call(2, c)
 	_popResults(a+3, c+1, vm)
 }
+
+// 函数调用完成后结果应该留下几个, ok 为 false 表示这条指令不是函数调用
+func CallResults(i Instruction) (nResults int, ok bool) {
+	_, _, c := i.ABC()
+	switch i.Opcode() {
+	case OP_CALL:
+		return c - 1, true
+	case OP_TAILCALL:
+		return -1, true
+	case OP_TFORCALL:
+		return c, true
+	}
+	return 0, false
+}
+
+// 被调函数的结果已经压栈, 完成指令剩下的部分; 用于继续执行从快照恢复的协程
+func FinishCall(i Instruction, vm api.LuaVM) {
+	a, _, c := i.ABC()
+	a += 1
+
+	switch i.Opcode() {
+	case OP_CALL:
+		_popResults(a, c, vm)
+	case OP_TAILCALL:
+		_popResults(a, 0, vm)
+	case OP_TFORCALL:
+		_popResults(a+3, c+1, vm)
+	}
+}
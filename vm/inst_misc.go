@@ -18,4 +18,10 @@ func jmp(i Instruction, vm api.LuaVM) {
 	}
 }
 
+// R(A) 是待关闭变量
+func tbc(i Instruction, vm api.LuaVM) {
+	a, _, _ := i.ABC()
+	a += 1
 
+	vm.ToClose(a)
+}
@@ -0,0 +1,120 @@
+package lua54
+
+import "luago/api"
+
+// R[A] := closure(KPROTO[Bx])
+func closure(i Instruction, vm api.LuaVM) {
+	a, bx := i.ABx()
+	vm.LoadProto(bx)
+	vm.Replace(a + 1)
+}
+
+// R[A], ... ,R[A+C-2] := R[A](R[A+1], ... ,R[A+B-1])
+func call(i Instruction, vm api.LuaVM) {
+	a, b, c, _ := i.ABCk()
+	a += 1
+
+	nArgs := _pushFuncAndArgs(a, b, vm)
+	vm.Call(nArgs, c-1)
+	_popResults(a, c, vm)
+}
+
+func tailCall(i Instruction, vm api.LuaVM) {
+	a, b, _, _ := i.ABCk()
+	a += 1
+
+	nArgs := _pushFuncAndArgs(a, b, vm)
+	vm.Call(nArgs, -1)
+	_popResults(a, 0, vm)
+}
+
+func _pushFuncAndArgs(a, b int, vm api.LuaVM) (nArgs int) {
+	if b >= 1 { // b-1 args
+		vm.CheckStack(b)
+		for i := a; i < a+b; i++ {
+			vm.PushValue(i)
+		}
+		return b - 1
+	} else {
+		_fixStack(a, vm)
+		return vm.GetTop() - vm.RegisterCount() - 1
+	}
+}
+
+func _popResults(a, c int, vm api.LuaVM) {
+	if c == 1 { // no result
+	} else if c > 1 {
+		for i := a + c - 2; i >= a; i-- {
+			vm.Replace(i)
+		}
+	} else {
+		vm.CheckStack(1)
+		vm.PushInteger(int64(a))
+	}
+}
+
+func _fixStack(a int, vm api.LuaVM) {
+	x := int(vm.ToInteger(-1))
+	vm.Pop(1)
+
+	vm.CheckStack(x - a)
+	for i := a; i < x; i++ {
+		vm.PushValue(i)
+	}
+	vm.Rotate(vm.RegisterCount()+1, x-a)
+}
+
+// return R[A], ... ,R[A+B-2]
+func _return(i Instruction, vm api.LuaVM) {
+	a, b, _, _ := i.ABCk()
+	a += 1
+
+	if b == 1 { // no return values
+	} else if b > 1 {
+		vm.CheckStack(b - 1)
+		for i := a; i <= a+b-2; i++ {
+			vm.PushValue(i)
+		}
+	} else {
+		_fixStack(a, vm)
+	}
+	vm.CloseUpvalues(1) // 返回值已经在栈顶, 再关闭 <close> 变量
+}
+
+func return0(i Instruction, vm api.LuaVM) {
+	vm.CloseUpvalues(1)
+}
+
+// return R[A]
+func return1(i Instruction, vm api.LuaVM) {
+	a, _, _, _ := i.ABCk()
+	vm.CheckStack(1)
+	vm.PushValue(a + 1)
+	vm.CloseUpvalues(1)
+}
+
+// R[A], R[A+1], ..., R[A+C-2] = vararg
+func vararg(i Instruction, vm api.LuaVM) {
+	a, _, c, _ := i.ABCk()
+	a += 1
+
+	if c != 1 {
+		vm.LoadVararg(c - 1)
+		_popResults(a, c, vm)
+	}
+}
+
+// 调整可变参数, 调用时已经处理好了
+func varargPrep(i Instruction, vm api.LuaVM) {}
+
+// R[A+1] := R[B]; R[A] := R[B][RK(C):string]
+func self(i Instruction, vm api.LuaVM) {
+	a, b, c, k := i.ABCk()
+	a += 1
+	b += 1
+
+	vm.Copy(b, a+1)
+	_pushRK(vm, c, k)
+	vm.GetTable(b)
+	vm.Replace(a)
+}
@@ -0,0 +1,160 @@
+package lua54
+
+import (
+	"fmt"
+	"luago/api"
+	"luago/number"
+	"math"
+)
+
+// 数值 for 循环占用 R[A]..R[A+3]: 初始值 上限 步长 控制变量
+// 整数循环在准备阶段算好迭代次数, 放在 R[A+1] 里代替上限
+func forPrep(i Instruction, vm api.LuaVM) {
+	a, bx := i.ABx()
+	a += 1
+
+	if _forPrep(a, vm) { // 跳过整个循环
+		vm.AddPC(bx + 1)
+	}
+}
+
+func _forPrep(a int, vm api.LuaVM) (skip bool) {
+	if vm.IsInteger(a) && vm.IsInteger(a+2) {
+		init := vm.ToInteger(a)
+		step := vm.ToInteger(a + 2)
+		if step == 0 {
+			panic("'for' step is zero")
+		}
+		vm.Copy(a, a+3)
+		limit, skip := _forLimit(a+1, init, step, vm)
+		if skip {
+			return true
+		}
+		var count uint64
+		if step > 0 {
+			count = uint64(limit) - uint64(init)
+			if step != 1 {
+				count /= uint64(step)
+			}
+		} else {
+			count = uint64(init) - uint64(limit)
+			count /= uint64(-(step + 1)) + 1
+		}
+		vm.PushInteger(int64(count))
+		vm.Replace(a + 1)
+		return false
+	}
+
+	limit := _forNumber(a+1, "limit", vm)
+	step := _forNumber(a+2, "step", vm)
+	init := _forNumber(a, "initial value", vm)
+	if step == 0 {
+		panic("'for' step is zero")
+	}
+	if step > 0 && limit < init || step < 0 && init < limit {
+		return true
+	}
+	for j, n := range []float64{init, limit, step, init} {
+		vm.PushNumber(n)
+		vm.Replace(a + j)
+	}
+	return false
+}
+
+// 5.4 不再把字符串转换成数字, 错误信息也和 5.3 不同 (ldebug.c#luaG_forerror)
+func _forNumber(idx int, what string, vm api.LuaVM) float64 {
+	n, ok := vm.ToNumberX(idx)
+	if !ok || vm.Type(idx) != api.LUA_TNUMBER {
+		panic(fmt.Sprintf("bad 'for' %s (number expected, got %s)",
+			what, vm.TypeName(vm.Type(idx))))
+	}
+	return n
+}
+
+// 把上限转成整数, 浮点数按步长方向取整, 超出范围时截断
+func _forLimit(idx int, init, step int64, vm api.LuaVM) (limit int64, skip bool) {
+	if vm.IsInteger(idx) {
+		limit = vm.ToInteger(idx)
+	} else {
+		f := _forNumber(idx, "limit", vm)
+		if step < 0 {
+			f = math.Ceil(f)
+		} else {
+			f = math.Floor(f)
+		}
+		if i, ok := number.FloatToInteger(f); ok {
+			limit = i
+		} else if f > 0 { // 太大了
+			if step < 0 {
+				return 0, true
+			}
+			limit = math.MaxInt64
+		} else { // 太小了 或者是 NaN
+			if step > 0 {
+				return 0, true
+			}
+			limit = math.MinInt64
+		}
+	}
+	if step > 0 {
+		return limit, init > limit
+	}
+	return limit, init < limit
+}
+
+func forLoop(i Instruction, vm api.LuaVM) {
+	a, bx := i.ABx()
+	a += 1
+
+	if vm.IsInteger(a + 2) {
+		count := uint64(vm.ToInteger(a + 1))
+		if count > 0 {
+			idx := vm.ToInteger(a) + vm.ToInteger(a+2)
+			vm.PushInteger(int64(count - 1))
+			vm.Replace(a + 1)
+			vm.PushInteger(idx)
+			vm.Copy(-1, a)
+			vm.Replace(a + 3)
+			vm.AddPC(-bx)
+		}
+		return
+	}
+
+	step := vm.ToNumber(a + 2)
+	limit := vm.ToNumber(a + 1)
+	idx := vm.ToNumber(a) + step
+	if step > 0 && idx <= limit || step <= 0 && limit <= idx {
+		vm.PushNumber(idx)
+		vm.Copy(-1, a)
+		vm.Replace(a + 3)
+		vm.AddPC(-bx)
+	}
+}
+
+// 创建待关闭变量 R[A+3], 然后跳到 TFORCALL
+func tForPrep(i Instruction, vm api.LuaVM) {
+	a, bx := i.ABx()
+	vm.ToClose(a + 4)
+	vm.AddPC(bx)
+}
+
+// R[A+4], ... ,R[A+3+C] := R[A](R[A+1], R[A+2])
+func tForCall(i Instruction, vm api.LuaVM) {
+	a, _, c, _ := i.ABCk()
+	a += 1
+
+	_pushFuncAndArgs(a, 3, vm)
+	vm.Call(2, c)
+	_popResults(a+4, c+1, vm)
+}
+
+// if R[A+4] ~= nil then { R[A+2] := R[A+4]; pc -= Bx }
+func tForLoop(i Instruction, vm api.LuaVM) {
+	a, bx := i.ABx()
+	a += 1
+
+	if !vm.IsNil(a + 4) {
+		vm.Copy(a+4, a+2)
+		vm.AddPC(-bx)
+	}
+}
@@ -0,0 +1,77 @@
+package lua54
+
+import "luago/api"
+
+// R[A] := R[B]
+func move(i Instruction, vm api.LuaVM) {
+	a, b, _, _ := i.ABCk()
+	vm.Copy(b+1, a+1)
+}
+
+// R[A] := sBx
+func loadI(i Instruction, vm api.LuaVM) {
+	a, sbx := i.AsBx()
+	vm.PushInteger(int64(sbx))
+	vm.Replace(a + 1)
+}
+
+// R[A] := (lua_Number)sBx
+func loadF(i Instruction, vm api.LuaVM) {
+	a, sbx := i.AsBx()
+	vm.PushNumber(float64(sbx))
+	vm.Replace(a + 1)
+}
+
+// R[A] := K[Bx]
+func loadK(i Instruction, vm api.LuaVM) {
+	a, bx := i.ABx()
+	vm.GetConst(bx)
+	vm.Replace(a + 1)
+}
+
+// R[A] := K[extra arg]
+func loadKx(i Instruction, vm api.LuaVM) {
+	a, _ := i.ABx()
+	ax := Instruction(vm.Fetch()).Ax()
+	vm.GetConst(ax)
+	vm.Replace(a + 1)
+}
+
+func loadFalse(i Instruction, vm api.LuaVM) {
+	a, _, _, _ := i.ABCk()
+	vm.PushBoolean(false)
+	vm.Replace(a + 1)
+}
+
+// R[A] := false; pc++
+func lFalseSkip(i Instruction, vm api.LuaVM) {
+	loadFalse(i, vm)
+	vm.AddPC(1)
+}
+
+func loadTrue(i Instruction, vm api.LuaVM) {
+	a, _, _, _ := i.ABCk()
+	vm.PushBoolean(true)
+	vm.Replace(a + 1)
+}
+
+// R[A], R[A+1], ..., R[A+B] := nil
+func loadNil(i Instruction, vm api.LuaVM) {
+	a, b, _, _ := i.ABCk()
+	a += 1
+
+	vm.PushNil()
+	for i := a; i <= a+b; i++ {
+		vm.Copy(-1, i)
+	}
+	vm.Pop(1)
+}
+
+// k 为真时 c 是常量索引 否则是寄存器
+func _pushRK(vm api.LuaVM, c int, k bool) {
+	if k {
+		vm.GetConst(c)
+	} else {
+		vm.PushValue(c + 1)
+	}
+}
@@ -0,0 +1,20 @@
+package lua54
+
+import "luago/api"
+
+// pc += sJ
+func jmp(i Instruction, vm api.LuaVM) {
+	vm.AddPC(i.SJ())
+}
+
+// close all upvalues >= R[A]
+func _close(i Instruction, vm api.LuaVM) {
+	a, _, _, _ := i.ABCk()
+	vm.CloseUpvalues(a + 1)
+}
+
+// R[A] 是待关闭变量
+func tbc(i Instruction, vm api.LuaVM) {
+	a, _, _, _ := i.ABCk()
+	vm.ToClose(a + 1)
+}
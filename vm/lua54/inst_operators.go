@@ -0,0 +1,210 @@
+package lua54
+
+import "luago/api"
+
+// 元方法事件 TM_ADD 到 TM_BNOT 的顺序和 api.ArithOp 相同
+const TM_ADD = 6
+
+// 栈顶两个操作数运算后放到寄存器a
+// 5.4 的算术指令后面总是跟着一条 MMBIN*, 和 luaT_trybinTM 一样:
+// 两个操作数都是数字时直接运算并跳过它, 否则按 MMBIN* 的事件和原始操作数运算
+// (x-1 编译成 ADDI -1 加上 TM_SUB, x<<1 编译成 SHRI -1 加上 TM_SHL)
+// flip 为真表示指令本身的操作数顺序是反的 (SHLI)
+func _arith(vm api.LuaVM, op api.ArithOp, a int, flip bool) {
+	next := Instruction(vm.Fetch())
+	switch next.Opcode() {
+	case OP_MMBIN, OP_MMBINI, OP_MMBINK:
+		if vm.Type(-2) != api.LUA_TNUMBER || vm.Type(-1) != api.LUA_TNUMBER {
+			vm.Pop(2)
+			op, flip = _mmBinOperands(next, vm)
+		}
+	default:
+		vm.AddPC(-1)
+	}
+	if flip {
+		vm.Rotate(-2, 1)
+	}
+	vm.Arith(op)
+	vm.Replace(a)
+}
+
+// 压入 MMBIN* 的两个操作数, 返回它的事件对应的运算
+// MMBINI 和 MMBINK 的 k 为真表示源码里常量在左边, 需要交换操作数
+func _mmBinOperands(i Instruction, vm api.LuaVM) (api.ArithOp, bool) {
+	a, b, c, k := i.ABCk()
+	vm.PushValue(a + 1)
+	switch i.Opcode() {
+	case OP_MMBIN:
+		vm.PushValue(b + 1)
+		k = false
+	case OP_MMBINI:
+		vm.PushInteger(int64(b - OFFSET_sC))
+	default:
+		vm.GetConst(b)
+	}
+	return api.ArithOp(c - TM_ADD), k
+}
+
+// R[A] := R[B] op R[C]
+func _binaryArith(i Instruction, vm api.LuaVM, op api.ArithOp) {
+	a, b, c, _ := i.ABCk()
+	vm.PushValue(b + 1)
+	vm.PushValue(c + 1)
+	_arith(vm, op, a+1, false)
+}
+
+// R[A] := R[B] op K[C]
+func _binaryArithK(i Instruction, vm api.LuaVM, op api.ArithOp) {
+	a, b, c, _ := i.ABCk()
+	vm.PushValue(b + 1)
+	vm.GetConst(c)
+	_arith(vm, op, a+1, false)
+}
+
+// R[A] := R[B] op sC
+func _binaryArithI(i Instruction, vm api.LuaVM, op api.ArithOp, flip bool) {
+	a, b, c, _ := i.ABCk()
+	vm.PushValue(b + 1)
+	vm.PushInteger(int64(c - OFFSET_sC))
+	_arith(vm, op, a+1, flip)
+}
+
+func _unaryArith(i Instruction, vm api.LuaVM, op api.ArithOp) {
+	a, b, _, _ := i.ABCk()
+	vm.PushValue(b + 1)
+	vm.Arith(op)
+	vm.Replace(a + 1)
+}
+
+func add(i Instruction, vm api.LuaVM)  { _binaryArith(i, vm, api.LUA_OPADD) }
+func sub(i Instruction, vm api.LuaVM)  { _binaryArith(i, vm, api.LUA_OPSUB) }
+func mul(i Instruction, vm api.LuaVM)  { _binaryArith(i, vm, api.LUA_OPMUL) }
+func mod(i Instruction, vm api.LuaVM)  { _binaryArith(i, vm, api.LUA_OPMOD) }
+func pow(i Instruction, vm api.LuaVM)  { _binaryArith(i, vm, api.LUA_OPPOW) }
+func div(i Instruction, vm api.LuaVM)  { _binaryArith(i, vm, api.LUA_OPDIV) }
+func idiv(i Instruction, vm api.LuaVM) { _binaryArith(i, vm, api.LUA_OPIDIV) }
+func band(i Instruction, vm api.LuaVM) { _binaryArith(i, vm, api.LUA_OPBAND) }
+func bor(i Instruction, vm api.LuaVM)  { _binaryArith(i, vm, api.LUA_OPBOR) }
+func bxor(i Instruction, vm api.LuaVM) { _binaryArith(i, vm, api.LUA_OPBXOR) }
+func shl(i Instruction, vm api.LuaVM)  { _binaryArith(i, vm, api.LUA_OPSHL) }
+func shr(i Instruction, vm api.LuaVM)  { _binaryArith(i, vm, api.LUA_OPSHR) }
+
+func addK(i Instruction, vm api.LuaVM)  { _binaryArithK(i, vm, api.LUA_OPADD) }
+func subK(i Instruction, vm api.LuaVM)  { _binaryArithK(i, vm, api.LUA_OPSUB) }
+func mulK(i Instruction, vm api.LuaVM)  { _binaryArithK(i, vm, api.LUA_OPMUL) }
+func modK(i Instruction, vm api.LuaVM)  { _binaryArithK(i, vm, api.LUA_OPMOD) }
+func powK(i Instruction, vm api.LuaVM)  { _binaryArithK(i, vm, api.LUA_OPPOW) }
+func divK(i Instruction, vm api.LuaVM)  { _binaryArithK(i, vm, api.LUA_OPDIV) }
+func idivK(i Instruction, vm api.LuaVM) { _binaryArithK(i, vm, api.LUA_OPIDIV) }
+func bandK(i Instruction, vm api.LuaVM) { _binaryArithK(i, vm, api.LUA_OPBAND) }
+func borK(i Instruction, vm api.LuaVM)  { _binaryArithK(i, vm, api.LUA_OPBOR) }
+func bxorK(i Instruction, vm api.LuaVM) { _binaryArithK(i, vm, api.LUA_OPBXOR) }
+
+// R[A] := R[B] + sC
+func addI(i Instruction, vm api.LuaVM) { _binaryArithI(i, vm, api.LUA_OPADD, false) }
+
+// R[A] := R[B] >> sC
+func shrI(i Instruction, vm api.LuaVM) { _binaryArithI(i, vm, api.LUA_OPSHR, false) }
+
+// R[A] := sC << R[B]
+func shlI(i Instruction, vm api.LuaVM) { _binaryArithI(i, vm, api.LUA_OPSHL, true) }
+
+// 元方法已经在前一条算术指令里处理过了
+func mmBin(i Instruction, vm api.LuaVM) {}
+
+func unm(i Instruction, vm api.LuaVM)  { _unaryArith(i, vm, api.LUA_OPUNM) }
+func bnot(i Instruction, vm api.LuaVM) { _unaryArith(i, vm, api.LUA_OPBNOT) }
+
+// R[A] := not R[B]
+func not(i Instruction, vm api.LuaVM) {
+	a, b, _, _ := i.ABCk()
+	vm.PushBoolean(!vm.ToBoolean(b + 1))
+	vm.Replace(a + 1)
+}
+
+// R[A] := #R[B]
+func _len(i Instruction, vm api.LuaVM) {
+	a, b, _, _ := i.ABCk()
+	vm.Len(b + 1)
+	vm.Replace(a + 1)
+}
+
+// R[A] := R[A].. ... ..R[A + B - 1]
+func concat(i Instruction, vm api.LuaVM) {
+	a, n, _, _ := i.ABCk()
+	a += 1
+
+	vm.CheckStack(n)
+	for i := a; i < a+n; i++ {
+		vm.PushValue(i)
+	}
+	vm.Concat(n)
+	vm.Replace(a)
+}
+
+// 比较栈顶两个值 结果和 k 不一致则跳过下一条跳转指令
+func _compare(vm api.LuaVM, op api.CompareOp, k bool) {
+	if vm.Compare(-2, -1, op) != k {
+		vm.AddPC(1)
+	}
+	vm.Pop(2)
+}
+
+// if ((R[A] op R[B]) ~= k) then pc++
+func _compareR(i Instruction, vm api.LuaVM, op api.CompareOp) {
+	a, b, _, k := i.ABCk()
+	vm.PushValue(a + 1)
+	vm.PushValue(b + 1)
+	_compare(vm, op, k)
+}
+
+// 和立即数比较, C 不为 0 时立即数是浮点数
+func _compareI(i Instruction, vm api.LuaVM, op api.CompareOp, flip bool) {
+	a, b, c, k := i.ABCk()
+	vm.PushValue(a + 1)
+	if c != 0 {
+		vm.PushNumber(float64(b - OFFSET_sC))
+	} else {
+		vm.PushInteger(int64(b - OFFSET_sC))
+	}
+	if flip {
+		vm.Rotate(-2, 1)
+	}
+	_compare(vm, op, k)
+}
+
+func eq(i Instruction, vm api.LuaVM) { _compareR(i, vm, api.LUA_OPEQ) }
+func lt(i Instruction, vm api.LuaVM) { _compareR(i, vm, api.LUA_OPLT) }
+func le(i Instruction, vm api.LuaVM) { _compareR(i, vm, api.LUA_OPLE) }
+
+// if ((R[A] == K[B]) ~= k) then pc++
+func eqK(i Instruction, vm api.LuaVM) {
+	a, b, _, k := i.ABCk()
+	vm.PushValue(a + 1)
+	vm.GetConst(b)
+	_compare(vm, api.LUA_OPEQ, k)
+}
+
+func eqI(i Instruction, vm api.LuaVM) { _compareI(i, vm, api.LUA_OPEQ, false) }
+func ltI(i Instruction, vm api.LuaVM) { _compareI(i, vm, api.LUA_OPLT, false) }
+func leI(i Instruction, vm api.LuaVM) { _compareI(i, vm, api.LUA_OPLE, false) }
+func gtI(i Instruction, vm api.LuaVM) { _compareI(i, vm, api.LUA_OPLT, true) }
+func geI(i Instruction, vm api.LuaVM) { _compareI(i, vm, api.LUA_OPLE, true) }
+
+// if (not R[A] == k) then pc++
+func test(i Instruction, vm api.LuaVM) {
+	a, _, _, k := i.ABCk()
+	if vm.ToBoolean(a+1) != k {
+		vm.AddPC(1)
+	}
+}
+
+// if (not R[B] == k) then pc++ else R[A] := R[B]
+func testSet(i Instruction, vm api.LuaVM) {
+	a, b, _, k := i.ABCk()
+	if vm.ToBoolean(b+1) != k {
+		vm.AddPC(1)
+	} else {
+		vm.Copy(b+1, a+1)
+	}
+}
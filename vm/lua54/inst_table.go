@@ -0,0 +1,100 @@
+package lua54
+
+import "luago/api"
+
+// R[A] := {} B 是哈希部分大小的对数加一, C 是数组部分大小
+// 后面总是跟着一条 EXTRAARG, k 为真时它保存数组大小的高位
+func newTable(i Instruction, vm api.LuaVM) {
+	a, b, c, k := i.ABCk()
+	if b > 0 {
+		b = 1 << (b - 1)
+	}
+	ax := Instruction(vm.Fetch()).Ax()
+	if k {
+		c += ax * (MAXARG_C + 1)
+	}
+	vm.CreateTable(c, b)
+	vm.Replace(a + 1)
+}
+
+// R[A] := R[B][R[C]]
+func getTable(i Instruction, vm api.LuaVM) {
+	a, b, c, _ := i.ABCk()
+	vm.PushValue(c + 1)
+	vm.GetTable(b + 1)
+	vm.Replace(a + 1)
+}
+
+// R[A] := R[B][C]
+func getI(i Instruction, vm api.LuaVM) {
+	a, b, c, _ := i.ABCk()
+	vm.GetI(b+1, int64(c))
+	vm.Replace(a + 1)
+}
+
+// R[A] := R[B][K[C]:string]
+func getField(i Instruction, vm api.LuaVM) {
+	a, b, c, _ := i.ABCk()
+	vm.GetConst(c)
+	vm.GetTable(b + 1)
+	vm.Replace(a + 1)
+}
+
+// R[A][R[B]] := RK(C)
+func setTable(i Instruction, vm api.LuaVM) {
+	a, b, c, k := i.ABCk()
+	vm.PushValue(b + 1)
+	_pushRK(vm, c, k)
+	vm.SetTable(a + 1)
+}
+
+// R[A][B] := RK(C)
+func setI(i Instruction, vm api.LuaVM) {
+	a, b, c, k := i.ABCk()
+	_pushRK(vm, c, k)
+	vm.SetI(a+1, int64(b))
+}
+
+// R[A][K[B]:string] := RK(C)
+func setField(i Instruction, vm api.LuaVM) {
+	a, b, c, k := i.ABCk()
+	vm.GetConst(b)
+	_pushRK(vm, c, k)
+	vm.SetTable(a + 1)
+}
+
+// R[A][C+i] := R[A+i], 1 <= i <= B
+// C 是已经存入的元素个数, k 为真时后面的 EXTRAARG 保存它的高位
+func setList(i Instruction, vm api.LuaVM) {
+	a, b, c, k := i.ABCk()
+	a += 1
+
+	if k {
+		c += Instruction(vm.Fetch()).Ax() * (MAXARG_C + 1)
+	}
+
+	bIsZero := b == 0
+	if bIsZero {
+		b = int(vm.ToInteger(-1)) - a - 1
+		vm.Pop(1)
+	}
+	vm.CheckStack(1)
+
+	idx := int64(c)
+	for j := 1; j <= b; j++ {
+		idx++
+		vm.PushValue(a + j)
+		vm.SetI(a, idx)
+	}
+
+	if bIsZero {
+		for j := vm.RegisterCount() + 1; j <= vm.GetTop(); j++ {
+			idx++
+			vm.PushValue(j)
+			vm.SetI(a, idx)
+		}
+
+		// clear stack
+		vm.SetTop(vm.RegisterCount())
+	}
+}
@@ -0,0 +1,31 @@
+package lua54
+
+import "luago/api"
+
+// R[A] := UpValue[B]
+func getUpval(i Instruction, vm api.LuaVM) {
+	a, b, _, _ := i.ABCk()
+	vm.Copy(api.LuaUpvalueIndex(b+1), a+1)
+}
+
+// UpValue[B] := R[A]
+func setUpval(i Instruction, vm api.LuaVM) {
+	a, b, _, _ := i.ABCk()
+	vm.Copy(a+1, api.LuaUpvalueIndex(b+1))
+}
+
+// R[A] := UpValue[B][K[C]:string]
+func getTabUp(i Instruction, vm api.LuaVM) {
+	a, b, c, _ := i.ABCk()
+	vm.GetConst(c)
+	vm.GetTable(api.LuaUpvalueIndex(b + 1))
+	vm.Replace(a + 1)
+}
+
+// UpValue[A][K[B]:string] := RK(C)
+func setTabUp(i Instruction, vm api.LuaVM) {
+	a, b, c, k := i.ABCk()
+	vm.GetConst(b)
+	_pushRK(vm, c, k)
+	vm.SetTable(api.LuaUpvalueIndex(a + 1))
+}
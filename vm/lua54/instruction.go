@@ -0,0 +1,74 @@
+package lua54
+
+import "luago/api"
+
+type Instruction uint32
+
+const (
+	MAXARG_Bx  = 1<<17 - 1      // 2 ^ 17 - 1 = 131071
+	OFFSET_sBx = MAXARG_Bx >> 1 // 65535
+	MAXARG_C   = 1<<8 - 1       // 255
+	OFFSET_sC  = MAXARG_C >> 1  // 127
+	MAXARG_sJ  = 1<<25 - 1
+	OFFSET_sJ  = MAXARG_sJ >> 1
+)
+
+// 0x7F = 1111111 提取指令后7位的opcode
+func (i Instruction) Opcode() int {
+	return int(i & 0x7F)
+}
+
+func (i Instruction) ABCk() (a, b, c int, k bool) {
+	a = int(i >> 7 & 0xFF)  // opcode 7位后的8位
+	k = i>>15&1 != 0        // opcode 7位+A8位后的1位
+	b = int(i >> 16 & 0xFF) // opcode 7位+A8位+k1位后的8位
+	c = int(i >> 24 & 0xFF) // 最高的8位
+	return
+}
+
+func (i Instruction) ABx() (a, bx int) {
+	a = int(i >> 7 & 0xFF) // opcode 7位后的8位
+	bx = int(i >> 15)      // 移除a 和 opcode 的15位 剩下的17位就是bx
+	return
+}
+
+// sBx表示的是有符号整数
+func (i Instruction) AsBx() (a, sbx int) {
+	a, bx := i.ABx()
+	return a, bx - OFFSET_sBx
+}
+
+func (i Instruction) Ax() int {
+	return int(i >> 7) // 移除opcode 的7位 剩下的25位就是Ax
+}
+
+// 跳转偏移 和 Ax 的位置相同但是有符号
+func (i Instruction) SJ() int {
+	return int(i>>7) - OFFSET_sJ
+}
+
+func (i Instruction) OpName() string {
+	return opcodes[i.Opcode()].name
+}
+
+func (i Instruction) OpMode() byte {
+	return opcodes[i.Opcode()].opMode
+}
+
+func (i Instruction) Execute(vm api.LuaVM) {
+	action := opcodes[i.Opcode()].action
+	if action != nil {
+		action(i, vm)
+	} else {
+		panic(i.OpName())
+	}
+}
+
+// 函数返回的指令 执行后当前函数结束
+func (i Instruction) IsReturn() bool {
+	switch i.Opcode() {
+	case OP_RETURN, OP_RETURN0, OP_RETURN1:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,200 @@
+package lua54
+
+import "luago/api"
+
+// 5.4 的指令 定长4字节共5种格式 83条指令
+//		  |31	 24|23	  16|15|14	   7|6	   0|
+// iABC	  | C: 8   | B: 8   |k | A: 8   |opcode:7|
+// iABx   | 	 Bx: 17        | A: 8   |opcode:7|
+// iAsBx  | 	sBx: 17        | A: 8   |opcode:7|
+// iAx    | 		 Ax: 25             |opcode:7|
+// isJ    | 		 sJ: 25             |opcode:7|
+
+const (
+	IABC = iota
+	IABx
+	IAsBx
+	IAx
+	IsJ
+)
+
+const (
+	OP_MOVE = iota
+	OP_LOADI
+	OP_LOADF
+	OP_LOADK
+	OP_LOADKX
+	OP_LOADFALSE
+	OP_LFALSESKIP
+	OP_LOADTRUE
+	OP_LOADNIL
+	OP_GETUPVAL
+	OP_SETUPVAL
+	OP_GETTABUP
+	OP_GETTABLE
+	OP_GETI
+	OP_GETFIELD
+	OP_SETTABUP
+	OP_SETTABLE
+	OP_SETI
+	OP_SETFIELD
+	OP_NEWTABLE
+	OP_SELF
+	OP_ADDI
+	OP_ADDK
+	OP_SUBK
+	OP_MULK
+	OP_MODK
+	OP_POWK
+	OP_DIVK
+	OP_IDIVK
+	OP_BANDK
+	OP_BORK
+	OP_BXORK
+	OP_SHRI
+	OP_SHLI
+	OP_ADD
+	OP_SUB
+	OP_MUL
+	OP_MOD
+	OP_POW
+	OP_DIV
+	OP_IDIV
+	OP_BAND
+	OP_BOR
+	OP_BXOR
+	OP_SHL
+	OP_SHR
+	OP_MMBIN
+	OP_MMBINI
+	OP_MMBINK
+	OP_UNM
+	OP_BNOT
+	OP_NOT
+	OP_LEN
+	OP_CONCAT
+	OP_CLOSE
+	OP_TBC
+	OP_JMP
+	OP_EQ
+	OP_LT
+	OP_LE
+	OP_EQK
+	OP_EQI
+	OP_LTI
+	OP_LEI
+	OP_GTI
+	OP_GEI
+	OP_TEST
+	OP_TESTSET
+	OP_CALL
+	OP_TAILCALL
+	OP_RETURN
+	OP_RETURN0
+	OP_RETURN1
+	OP_FORLOOP
+	OP_FORPREP
+	OP_TFORPREP
+	OP_TFORCALL
+	OP_TFORLOOP
+	OP_SETLIST
+	OP_CLOSURE
+	OP_VARARG
+	OP_VARARGPREP
+	OP_EXTRAARG
+)
+
+type opcode struct {
+	testFlag byte // operator is a test (next instruction must be a jump)
+	setAFlag byte // instruction set register A
+	opMode   byte // op mode
+	name     string
+	action   func(i Instruction, vm api.LuaVM)
+}
+
+var opcodes = []opcode{
+	/* T A mode name */
+	{0, 1, IABC, "MOVE      ", move},
+	{0, 1, IAsBx, "LOADI     ", loadI},
+	{0, 1, IAsBx, "LOADF     ", loadF},
+	{0, 1, IABx, "LOADK     ", loadK},
+	{0, 1, IABx, "LOADKX    ", loadKx},
+	{0, 1, IABC, "LOADFALSE ", loadFalse},
+	{0, 1, IABC, "LFALSESKIP", lFalseSkip},
+	{0, 1, IABC, "LOADTRUE  ", loadTrue},
+	{0, 1, IABC, "LOADNIL   ", loadNil},
+	{0, 1, IABC, "GETUPVAL  ", getUpval},
+	{0, 0, IABC, "SETUPVAL  ", setUpval},
+	{0, 1, IABC, "GETTABUP  ", getTabUp},
+	{0, 1, IABC, "GETTABLE  ", getTable},
+	{0, 1, IABC, "GETI      ", getI},
+	{0, 1, IABC, "GETFIELD  ", getField},
+	{0, 0, IABC, "SETTABUP  ", setTabUp},
+	{0, 0, IABC, "SETTABLE  ", setTable},
+	{0, 0, IABC, "SETI      ", setI},
+	{0, 0, IABC, "SETFIELD  ", setField},
+	{0, 1, IABC, "NEWTABLE  ", newTable},
+	{0, 1, IABC, "SELF      ", self},
+	{0, 1, IABC, "ADDI      ", addI},
+	{0, 1, IABC, "ADDK      ", addK},
+	{0, 1, IABC, "SUBK      ", subK},
+	{0, 1, IABC, "MULK      ", mulK},
+	{0, 1, IABC, "MODK      ", modK},
+	{0, 1, IABC, "POWK      ", powK},
+	{0, 1, IABC, "DIVK      ", divK},
+	{0, 1, IABC, "IDIVK     ", idivK},
+	{0, 1, IABC, "BANDK     ", bandK},
+	{0, 1, IABC, "BORK      ", borK},
+	{0, 1, IABC, "BXORK     ", bxorK},
+	{0, 1, IABC, "SHRI      ", shrI},
+	{0, 1, IABC, "SHLI      ", shlI},
+	{0, 1, IABC, "ADD       ", add},
+	{0, 1, IABC, "SUB       ", sub},
+	{0, 1, IABC, "MUL       ", mul},
+	{0, 1, IABC, "MOD       ", mod},
+	{0, 1, IABC, "POW       ", pow},
+	{0, 1, IABC, "DIV       ", div},
+	{0, 1, IABC, "IDIV      ", idiv},
+	{0, 1, IABC, "BAND      ", band},
+	{0, 1, IABC, "BOR       ", bor},
+	{0, 1, IABC, "BXOR      ", bxor},
+	{0, 1, IABC, "SHL       ", shl},
+	{0, 1, IABC, "SHR       ", shr},
+	{0, 0, IABC, "MMBIN     ", mmBin},
+	{0, 0, IABC, "MMBINI    ", mmBin},
+	{0, 0, IABC, "MMBINK    ", mmBin},
+	{0, 1, IABC, "UNM       ", unm},
+	{0, 1, IABC, "BNOT      ", bnot},
+	{0, 1, IABC, "NOT       ", not},
+	{0, 1, IABC, "LEN       ", _len},
+	{0, 1, IABC, "CONCAT    ", concat},
+	{0, 0, IABC, "CLOSE     ", _close},
+	{0, 0, IABC, "TBC       ", tbc},
+	{0, 0, IsJ, "JMP       ", jmp},
+	{1, 0, IABC, "EQ        ", eq},
+	{1, 0, IABC, "LT        ", lt},
+	{1, 0, IABC, "LE        ", le},
+	{1, 0, IABC, "EQK       ", eqK},
+	{1, 0, IABC, "EQI       ", eqI},
+	{1, 0, IABC, "LTI       ", ltI},
+	{1, 0, IABC, "LEI       ", leI},
+	{1, 0, IABC, "GTI       ", gtI},
+	{1, 0, IABC, "GEI       ", geI},
+	{1, 0, IABC, "TEST      ", test},
+	{1, 1, IABC, "TESTSET   ", testSet},
+	{0, 1, IABC, "CALL      ", call},
+	{0, 1, IABC, "TAILCALL  ", tailCall},
+	{0, 0, IABC, "RETURN    ", _return},
+	{0, 0, IABC, "RETURN0   ", return0},
+	{0, 0, IABC, "RETURN1   ", return1},
+	{0, 1, IABx, "FORLOOP   ", forLoop},
+	{0, 1, IABx, "FORPREP   ", forPrep},
+	{0, 0, IABx, "TFORPREP  ", tForPrep},
+	{0, 0, IABC, "TFORCALL  ", tForCall},
+	{0, 1, IABx, "TFORLOOP  ", tForLoop},
+	{0, 0, IABC, "SETLIST   ", setList},
+	{0, 1, IABx, "CLOSURE   ", closure},
+	{0, 1, IABC, "VARARG    ", vararg},
+	{0, 1, IABC, "VARARGPREP", varargPrep},
+	{0, 0, IAx, "EXTRAARG  ", nil},
+}
@@ -2,9 +2,9 @@ package vm
 
 import "luago/api"
 
-// 定长4字节的指令共4种 47条指令
+// 定长4字节的指令共4种 48条指令
 //		  |31		24|23		16|15		8|7		 0|
-// iABC	  |	B: 9	  | C: 9      | A: 8     |opcode:6| 39种
+// iABC	  |	B: 9	  | C: 9      | A: 8     |opcode:6| 40种
 // iABx   | 		 Bx: 18       | A: 8     |opcode:6| 3种
 // iAsBx  | 		sBx: 18       | A: 8     |opcode:6| 4种
 // iAx    | 			Ax: 26 			     |opcode:6| 1种
@@ -64,6 +64,7 @@ const (
 	OP_CLOSURE
 	OP_VARARG
 	OP_EXTRAARG
+	OP_TBC // 5.4 的 <close> 变量
 )
 
 const (
@@ -132,4 +133,5 @@ var opcodes = []opcode{
 	{0, 1, OpArgU, OpArgN, IABx, "CLOSURE ", closure},
 	{0, 1, OpArgU, OpArgN, IABC, "VARARG  ", vararg},
 	{0, 0, OpArgU, OpArgU, IAx, "EXTRAARG", nil},
+	{0, 0, OpArgN, OpArgN, IABC, "TBC     ", tbc},
 }